@@ -0,0 +1,283 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	bech32Charset   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const     = 1          // BIP173, witness version 0
+	bech32mConst    = 0x2bc830a3 // BIP350, witness version 1+
+	base58Alphabet  = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	maxBech32Length = 90
+)
+
+// version bytes of base58check addresses
+const (
+	versionP2PKHMainnet = 0x00
+	versionP2SHMainnet  = 0x05
+	versionP2PKHTestnet = 0x6f // testnet, signet, regtest
+	versionP2SHTestnet  = 0xc4
+)
+
+// script opcodes
+const (
+	opFalse       = 0x00
+	opPushData1   = 0x4c
+	opPushData2   = 0x4d
+	op1           = 0x51
+	opDup         = 0x76
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opHash160     = 0xa9
+	opCheckSig    = 0xac
+	opReturn      = 0x6a
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, value := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) (values []byte) {
+	for _, c := range hrp {
+		values = append(values, byte(c>>5))
+	}
+	values = append(values, 0)
+	for _, c := range hrp {
+		values = append(values, byte(c&31))
+	}
+	return
+}
+
+// bech32Decode decodes a bech32 or bech32m string into hrp and 5-bit data without the checksum
+func bech32Decode(encoded string) (hrp string, data []byte, checksumConst uint32, err error) {
+	if len(encoded) > maxBech32Length {
+		err = fmt.Errorf("too long bech32 string: %d", len(encoded))
+		return
+	}
+	if strings.ToLower(encoded) != encoded && strings.ToUpper(encoded) != encoded {
+		err = fmt.Errorf("mixed case bech32 string")
+		return
+	}
+	encoded = strings.ToLower(encoded)
+	separator := strings.LastIndex(encoded, "1")
+	if separator < 1 || separator+7 > len(encoded) {
+		err = fmt.Errorf("incorrect separator of bech32 string")
+		return
+	}
+	hrp = encoded[:separator]
+	for _, c := range encoded[separator+1:] {
+		value := strings.IndexRune(bech32Charset, c)
+		if value < 0 {
+			err = fmt.Errorf("incorrect bech32 character '%c'", c)
+			return
+		}
+		data = append(data, byte(value))
+	}
+	checksumConst = bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if checksumConst != bech32Const && checksumConst != bech32mConst {
+		err = fmt.Errorf("incorrect bech32 checksum")
+		return
+	}
+	data = data[:len(data)-6]
+	return
+}
+
+// convertBits regroups fromBits-bit values into toBits-bit values
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) (converted []byte, err error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			err = fmt.Errorf("incorrect %d-bit value %d", fromBits, value)
+			return
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		err = fmt.Errorf("incorrect padding")
+		return
+	}
+	return
+}
+
+// decodeSegwitAddress decodes a BIP173/BIP350 address of hrp bc, tb or bcrt
+func decodeSegwitAddress(address string) (witnessVersion int, program []byte, err error) {
+	hrp, data, checksumConst, err := bech32Decode(address)
+	if err != nil {
+		return
+	}
+	if hrp != "bc" && hrp != "tb" && hrp != "bcrt" {
+		err = fmt.Errorf("unknown hrp '%s'", hrp)
+		return
+	}
+	if len(data) < 1 {
+		err = fmt.Errorf("no witness version")
+		return
+	}
+	witnessVersion = int(data[0])
+	if witnessVersion > 16 {
+		err = fmt.Errorf("incorrect witness version %d", witnessVersion)
+		return
+	}
+	if (witnessVersion == 0 && checksumConst != bech32Const) || (witnessVersion != 0 && checksumConst != bech32mConst) {
+		err = fmt.Errorf("incorrect checksum type for witness version %d", witnessVersion)
+		return
+	}
+	program, err = convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		err = fmt.Errorf("@convertBits(): %v", err)
+		return
+	}
+	if len(program) < 2 || len(program) > 40 || (witnessVersion == 0 && len(program) != 20 && len(program) != 32) {
+		err = fmt.Errorf("incorrect witness program length %d for version %d", len(program), witnessVersion)
+		return
+	}
+	return
+}
+
+// encodeSegwitAddress encodes witness program of witnessVersion into a BIP173/BIP350 address of hrp
+func encodeSegwitAddress(hrp string, witnessVersion int, program []byte) (address string, err error) {
+	data, err := convertBits(program, 8, 5, true)
+	if err != nil {
+		err = fmt.Errorf("@convertBits(): %v", err)
+		return
+	}
+	data = append([]byte{byte(witnessVersion)}, data...)
+	checksumConst := uint32(bech32Const)
+	if witnessVersion != 0 {
+		checksumConst = bech32mConst
+	}
+	polymod := bech32Polymod(append(append(bech32HrpExpand(hrp), data...), 0, 0, 0, 0, 0, 0)) ^ checksumConst
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(polymod>>uint(5*(5-i))&31))
+	}
+	encoded := new(strings.Builder)
+	encoded.WriteString(hrp + "1")
+	for _, value := range data {
+		encoded.WriteByte(bech32Charset[value])
+	}
+	address = encoded.String()
+	return
+}
+
+func doubleSha256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// base58CheckDecode decodes a base58check string into its version byte and payload
+func base58CheckDecode(encoded string) (version byte, payload []byte, err error) {
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range encoded {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			err = fmt.Errorf("incorrect base58 character '%c'", c)
+			return
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+	decoded := value.Bytes()
+	for _, c := range encoded {
+		if c != '1' {
+			break
+		}
+		decoded = append([]byte{0x00}, decoded...) // leading zeros
+	}
+	if len(decoded) < 5 {
+		err = fmt.Errorf("too short base58check string")
+		return
+	}
+	checksum := doubleSha256(decoded[:len(decoded)-4])[:4]
+	if !bytes.Equal(checksum, decoded[len(decoded)-4:]) {
+		err = fmt.Errorf("incorrect base58check checksum")
+		return
+	}
+	version = decoded[0]
+	payload = decoded[1 : len(decoded)-4]
+	return
+}
+
+// AddressScript decodes a mainnet, testnet, signet or regtest address into its scriptPubKey.
+func AddressScript(address string) (script []byte, err error) {
+	lowerAddress := strings.ToLower(address)
+	if strings.HasPrefix(lowerAddress, "bc1") || strings.HasPrefix(lowerAddress, "tb1") || strings.HasPrefix(lowerAddress, "bcrt1") {
+		witnessVersion, program, errS := decodeSegwitAddress(address)
+		if errS != nil {
+			err = fmt.Errorf("@decodeSegwitAddress('%s'): %v", address, errS)
+			return
+		}
+		opVersion := byte(opFalse)
+		if witnessVersion > 0 {
+			opVersion = byte(op1 + witnessVersion - 1)
+		}
+		script = append([]byte{opVersion, byte(len(program))}, program...)
+		return
+	}
+
+	version, payload, err := base58CheckDecode(address)
+	if err != nil {
+		err = fmt.Errorf("@base58CheckDecode('%s'): %v", address, err)
+		return
+	}
+	if len(payload) != 20 {
+		err = fmt.Errorf("incorrect hash length %d of '%s'", len(payload), address)
+		return
+	}
+	switch version {
+	case versionP2PKHMainnet, versionP2PKHTestnet:
+		script = append(append([]byte{opDup, opHash160, 20}, payload...), opEqualVerify, opCheckSig)
+	case versionP2SHMainnet, versionP2SHTestnet:
+		script = append(append([]byte{opHash160, 20}, payload...), opEqual)
+	default:
+		err = fmt.Errorf("unknown version byte 0x%02x of '%s'", version, address)
+		return
+	}
+	return
+}
+
+// opReturnScript is OP_RETURN <push data>
+func opReturnScript(data []byte) (script []byte) {
+	script = []byte{opReturn}
+	switch {
+	case len(data) == 0:
+		script = append(script, opFalse)
+		return
+	case len(data) <= 75:
+		script = append(script, byte(len(data)))
+	case len(data) <= 255:
+		script = append(script, opPushData1, byte(len(data)))
+	default:
+		script = append(script, opPushData2, byte(len(data)), byte(len(data)>>8))
+	}
+	script = append(script, data...)
+	return
+}
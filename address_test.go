@@ -0,0 +1,62 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestAddressScript(t *testing.T) {
+	tests := []struct {
+		address string
+		script  string
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+	}
+	for _, test := range tests {
+		script, err := AddressScript(test.address)
+		if err != nil {
+			t.Errorf("AddressScript('%s'): %v", test.address, err)
+			continue
+		}
+		if hex.EncodeToString(script) != test.script {
+			t.Errorf("AddressScript('%s'): %x, want %s", test.address, script, test.script)
+		}
+	}
+
+	for _, address := range []string{
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",                     // checksum
+		"bc1qw508d6qejxtdg4y5r3zarvarY0c5xw7kv8f3t4",                     // mixed case
+		"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y",                     // bech32 for version 1
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3",                             // checksum
+		"xc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",                     // hrp
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du",                          // bech32 for version 2
+		"bc1qr508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",                     // checksum of program
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7", // mixed case
+	} {
+		if _, err := AddressScript(address); err == nil {
+			t.Errorf("AddressScript('%s'): no error", address)
+		}
+	}
+}
+
+func TestOpReturnScript(t *testing.T) {
+	tests := []struct {
+		dataSize int
+		prefix   string
+	}{
+		{0, "6a00"},
+		{5, "6a05"},
+		{80, "6a4c50"},
+		{300, "6a4d2c01"},
+	}
+	for _, test := range tests {
+		script := opReturnScript(make([]byte, test.dataSize))
+		if hex.EncodeToString(script)[:len(test.prefix)] != test.prefix || len(script) != opReturnScriptSize(test.dataSize) {
+			t.Errorf("opReturnScript(%d bytes): %x", test.dataSize, script)
+		}
+	}
+}
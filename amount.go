@@ -0,0 +1,81 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const SatoshiPerBitcoin = 100000000
+
+// Amount is an amount of bitcoin in satoshis.
+type Amount int64
+
+func AmountFromBTC(btc float64) (amount Amount, err error) {
+	if math.IsNaN(btc) || math.IsInf(btc, 0) {
+		err = fmt.Errorf("invalid btc amount[%f]", btc)
+		return
+	}
+	amount = Amount(math.Round(btc * SatoshiPerBitcoin))
+	return
+}
+
+// ParseAmount parses a decimal BTC string such as "0.00012345" without going through float64.
+func ParseAmount(btcStr string) (amount Amount, err error) {
+	tStr := strings.TrimSpace(btcStr)
+	negative := false
+	if strings.HasPrefix(tStr, "-") {
+		negative = true
+		tStr = tStr[1:]
+	}
+
+	parts := strings.SplitN(tStr, ".", 2)
+	if parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		err = fmt.Errorf("invalid btc amount[%s]", btcStr)
+		return
+	}
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > 8 {
+		err = fmt.Errorf("invalid btc amount[%s]: more than 8 decimal places", btcStr)
+		return
+	}
+	fraction += strings.Repeat("0", 8-len(fraction))
+
+	tDigits := parts[0] + fraction
+	for _, digit := range tDigits {
+		if digit < '0' || digit > '9' {
+			err = fmt.Errorf("invalid btc amount[%s]", btcStr)
+			return
+		}
+	}
+	sats, err := strconv.ParseInt(tDigits, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("@strconv.ParseInt('%s'): %v", tDigits, err)
+		return
+	}
+
+	amount = Amount(sats)
+	if negative {
+		amount = -amount
+	}
+	return
+}
+
+func (amount Amount) ToBTC() float64 {
+	return float64(amount) / SatoshiPerBitcoin
+}
+
+// String formats as BTC with 8 decimal places, e.g. "0.00012345"
+func (amount Amount) String() string {
+	sign := ""
+	sats := int64(amount)
+	if sats < 0 {
+		sign = "-"
+		sats = -sats
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, sats/SatoshiPerBitcoin, sats%SatoshiPerBitcoin)
+}
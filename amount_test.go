@@ -0,0 +1,42 @@
+package gobitcoinopreturn
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	cases := map[string]Amount{
+		"0.00012345":  12345,
+		"1":           SatoshiPerBitcoin,
+		"21000000.0":  21000000 * SatoshiPerBitcoin,
+		".5":          50000000,
+		"-0.00000001": -1,
+	}
+	for btcStr, expected := range cases {
+		amount, err := ParseAmount(btcStr)
+		if err != nil {
+			t.Fatalf("%s: %v", btcStr, err)
+		}
+		if amount != expected {
+			t.Fatalf("%s: expected %d, got %d", btcStr, expected, amount)
+		}
+	}
+
+	for _, btcStr := range []string{"", ".", "0.000000001", "1e-8", "0.0001a"} {
+		if _, err := ParseAmount(btcStr); err == nil {
+			t.Fatalf("%s: expected error", btcStr)
+		}
+	}
+}
+
+func TestAmountFromBTC(t *testing.T) {
+	// 0.1 + 0.2 is not exactly 0.3 in float64
+	amount, err := AmountFromBTC(0.1 + 0.2)
+	if err != nil || amount != 30000000 {
+		t.Fatalf("expected 30000000, got %d, %v", amount, err)
+	}
+	if amount.String() != "0.30000000" || Amount(-12345).String() != "-0.00012345" {
+		t.Fatalf("unexpected format %s", amount)
+	}
+	if Amount(12345).ToBTC() != 0.00012345 {
+		t.Fatalf("unexpected btc %f", Amount(12345).ToBTC())
+	}
+}
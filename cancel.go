@@ -0,0 +1,142 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+type CancelOutcome string
+
+const (
+	CancelPending           CancelOutcome = "pending"            // the cancellation is in mempool, nothing is confirmed yet
+	CancelWon               CancelOutcome = "cancelled"          // the cancellation is confirmed, the message is not written
+	CancelOriginalConfirmed CancelOutcome = "original-confirmed" // the message is confirmed first
+	CancelUnknown           CancelOutcome = "unknown"            // neither is found: evicted, or replaced by another tx
+)
+
+type CancelResult struct {
+	Outcome       CancelOutcome
+	CancelTxID    string
+	ConfirmedTxID string // "": none is confirmed
+	Confirmations int
+}
+
+// confirmedTx finds a confirmed one of txIDs, "": none
+func confirmedTx(bitcoinCli goBitcoinCli.BitcoinRpc, txIDs []string) (confirmedTxID string, confirmations int, err error) {
+	for _, txID := range txIDs {
+		if txID == "" {
+			continue
+		}
+		confirmations, _, err = txStatus(bitcoinCli, txID)
+		if err != nil {
+			return
+		}
+		if confirmations > 0 {
+			confirmedTxID = txID
+			return
+		}
+	}
+	confirmations = 0
+	return
+}
+
+// originalTxIDs are OpRetrunTxID and the txs it replaced, any of them may still be mined
+func (opReturn *OpReturn) originalTxIDs() []string {
+	return append([]string{opReturn.OpRetrunTxID}, opReturn.ReplacedTxIDs...)
+}
+
+// Cancel retracts the unconfirmed opReturn.OpRetrunTxID, replacing it by RBF with a tx spending the same inputs
+// back to Address without OP_RETURN, at feePerVByte(0: the lowest BIP125 allows).
+// Confirmed unspents of Address are added when the inputs can not pay the replacement.
+// The outcome is CancelPending after broadcasting, or CancelOriginalConfirmed when the message is confirmed first.
+func (opReturn *OpReturn) Cancel(feePerVByte float64) (result CancelResult, err error) {
+	if opReturn.OpRetrunTxID == "" {
+		err = fmt.Errorf("no OpRetrunTxID to cancel")
+		return
+	}
+	if opReturn.CancelTxID != "" {
+		err = fmt.Errorf("already cancelled by '%s'", opReturn.CancelTxID)
+		return
+	}
+	bitcoinCli := opReturn.bitcoinCli()
+
+	confirmedTxID, confirmations, err := confirmedTx(bitcoinCli, opReturn.originalTxIDs())
+	if err != nil {
+		return
+	}
+	if confirmedTxID != "" {
+		result = CancelResult{Outcome: CancelOriginalConfirmed, ConfirmedTxID: confirmedTxID, Confirmations: confirmations}
+		return
+	}
+
+	confirmationsInput := opReturn.Confirmations
+	if confirmationsInput <= 0 {
+		confirmationsInput = 1
+	}
+	r := replacement{
+		bitcoinCli:    bitcoinCli,
+		address:       opReturn.Address,
+		signer:        opReturn.Signer,
+		confirmations: confirmationsInput,
+		inputs:        expectedUnspents(opReturn.Unspents),
+		payInfos:      make(map[string]Amount), // all back to Address as the balance
+		limitFee:      opReturn.LimitFeeSats,
+		replacedTxID:  opReturn.OpRetrunTxID,
+		feePerVByte:   feePerVByte,
+	}
+	candidates, err := listUnspents(bitcoinCli, opReturn.Address)
+	if err != nil {
+		err = fmt.Errorf("@listUnspents('%s'): %v", opReturn.Address, err)
+		return
+	}
+	if errR := r.run(candidates); errR != nil {
+		// confirmed in the meantime
+		confirmedTxID, confirmations, err = confirmedTx(bitcoinCli, opReturn.originalTxIDs())
+		if err == nil && confirmedTxID != "" {
+			result = CancelResult{Outcome: CancelOriginalConfirmed, ConfirmedTxID: confirmedTxID, Confirmations: confirmations}
+			return
+		}
+		err = fmt.Errorf("@replacement.run(): %v", errR)
+		return
+	}
+
+	opReturn.CancelTxID = r.txID
+	result = CancelResult{Outcome: CancelPending, CancelTxID: r.txID}
+	return
+}
+
+// CancelStatus reports whether the cancellation of Cancel won, or the message is confirmed first.
+func (opReturn *OpReturn) CancelStatus() (result CancelResult, err error) {
+	if opReturn.CancelTxID == "" {
+		err = fmt.Errorf("no CancelTxID, not cancelled")
+		return
+	}
+	bitcoinCli := opReturn.bitcoinCli()
+	result.CancelTxID = opReturn.CancelTxID
+
+	confirmations, inMempool, err := txStatus(bitcoinCli, opReturn.CancelTxID)
+	if err != nil {
+		return
+	}
+	if confirmations > 0 {
+		result.Outcome = CancelWon
+		result.ConfirmedTxID = opReturn.CancelTxID
+		result.Confirmations = confirmations
+		return
+	}
+
+	result.ConfirmedTxID, result.Confirmations, err = confirmedTx(bitcoinCli, opReturn.originalTxIDs())
+	if err != nil {
+		return
+	}
+	switch {
+	case result.ConfirmedTxID != "":
+		result.Outcome = CancelOriginalConfirmed
+	case inMempool:
+		result.Outcome = CancelPending
+	default:
+		result.Outcome = CancelUnknown
+	}
+	return
+}
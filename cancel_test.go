@@ -0,0 +1,73 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestOpReturnCancel(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	result, err := opReturn.Cancel(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != CancelPending || result.CancelTxID != "newtxid" || opReturn.CancelTxID != "newtxid" || opReturn.OpRetrunTxID != "oldtxid" {
+		t.Errorf("result: %+v, opReturn: %+v", result, opReturn)
+	}
+	// the same input back to Address, 109.5 vbytes x 20
+	tx := bitcoind.signedRawTx(t)
+	if len(tx.Inputs) != 1 || tx.Inputs[0].PrevTxID != testTxIDA || len(tx.Outputs) != 1 || tx.Outputs[0].Value != 47810 ||
+		hex.EncodeToString(tx.Outputs[0].ScriptPubKey) != testScriptPubKey {
+		t.Errorf("raw tx: %+v", tx)
+	}
+
+	if _, err = opReturn.Cancel(30); err == nil {
+		t.Errorf("Cancel() twice: no error")
+	}
+}
+
+func TestOpReturnCancelOriginalConfirmed(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"getrawtransaction": `{"txid":"oldtxid","confirmations":3}`,
+	})
+	defer bitcoind.Close()
+
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	result, err := opReturn.Cancel(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != CancelOriginalConfirmed || result.ConfirmedTxID != "oldtxid" || result.Confirmations != 3 || opReturn.CancelTxID != "" {
+		t.Errorf("result: %+v", result)
+	}
+	if bitcoind.called("sendrawtransaction") {
+		t.Errorf("cancellation is sent")
+	}
+}
+
+func TestOpReturnCancelStatus(t *testing.T) {
+	tests := []struct {
+		results map[string]string
+		want    CancelOutcome
+	}{
+		{map[string]string{"getmempoolentry": `{"vsize":110}`}, CancelPending},
+		{map[string]string{"getrawtransaction": `{"confirmations":1}`}, CancelWon},
+		{map[string]string{}, CancelUnknown},
+	}
+	for _, test := range tests {
+		bitcoind := newTestBitcoind(test.results)
+		opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+		opReturn.CancelTxID = "canceltxid"
+		result, err := opReturn.CancelStatus()
+		bitcoind.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Outcome != test.want || result.CancelTxID != "canceltxid" {
+			t.Errorf("CancelStatus(): %+v, want %s", result, test.want)
+		}
+	}
+}
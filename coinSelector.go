@@ -0,0 +1,507 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dustThreshold              = Amount(546) // smallest balance(change) output worth creating
+	defaultLongTermFeePerVByte = 10.0        // Sats 1, as Bitcoin Core -consolidatefeerate
+	bnbTotalTries              = 100000
+	knapsackIterations         = 1000
+)
+
+type CoinSelector interface {
+	Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error)
+}
+
+type SelectionParams struct {
+	Target              Amount   // sum of pay outputs (without fee)
+	PayAddresses        []string // addresses of pay outputs, without balance(change) output
+	OpReturnSizes       []int    // data size of each OP_RETURN output
+	FeePerVByte         float64  // Sats 1
+	LongTermFeePerVByte float64  // Sats 1, expected fee rate for spending unspents later. 0: defaultLongTermFeePerVByte
+	FixedFee            Amount   // used instead of FeePerVByte when > 0
+	Address             string   // address of unspents, for fee calculation
+	Confirmations       int      // minimum confirmations of usable unspents
+}
+
+type CoinSelection struct {
+	Selector string // name of the selector which found this selection
+	Indexes  []int  // indexes of selected unspents
+	Sum      Amount // sum of selected unspents
+	Fee      Amount
+	Change   Amount // balance(change) output, 0 when changeless
+	Waste    Amount // see SelectionParams.waste()
+}
+
+type SelectionReport struct {
+	Selector            string
+	Inputs              []Unspent
+	Target              Amount
+	FeePerVByte         float64 // Sats 1
+	FeeSource           string  // RemoteFees.Source of FeePerVByte, "": given by the caller
+	LongTermFeePerVByte float64 // Sats 1
+	Fee                 Amount
+	Change              Amount
+	ChangeCost          Amount // fee for the balance(change) output now and for spending it later
+	Excess              Amount // given up to fee by a changeless tx
+	Waste               Amount
+}
+
+func (params SelectionParams) inputAddress(unspent Unspent) string {
+	if unspent.Address != "" {
+		return unspent.Address
+	}
+	return params.Address
+}
+
+// fee of a tx spending unspents[indexes]
+func (params SelectionParams) fee(unspents []Unspent, indexes []int, hasChange bool) (fee Amount) {
+	if params.FixedFee > 0 {
+		fee = params.FixedFee
+		return
+	}
+	inputAddresses := make([]string, 0)
+	for _, i := range indexes {
+		inputAddresses = append(inputAddresses, params.inputAddress(unspents[i]))
+	}
+	outputAddresses := params.PayAddresses
+	if hasChange {
+		outputAddresses = append(append([]string{}, params.PayAddresses...), params.Address)
+	}
+	fee = calFee(inputAddresses, outputAddresses, params.OpReturnSizes, params.FeePerVByte)
+	return
+}
+
+// fee for adding the unspent as an input
+func (params SelectionParams) inputFee(unspent Unspent) (fee Amount) {
+	if params.FixedFee > 0 {
+		return
+	}
+	fee = Amount(math.Ceil(inputVBytes(params.inputAddress(unspent)) * params.FeePerVByte))
+	return
+}
+
+func (params SelectionParams) longTermParams() (longTerm SelectionParams) {
+	longTerm = params
+	longTerm.FeePerVByte = params.LongTermFeePerVByte
+	if longTerm.FeePerVByte <= 0.0 {
+		longTerm.FeePerVByte = defaultLongTermFeePerVByte
+	}
+	return
+}
+
+// fee for creating the balance(change) output now and spending it later at the long term fee rate
+func (params SelectionParams) costOfChange() (cost Amount) {
+	if params.FixedFee > 0 {
+		cost = dustThreshold
+		return
+	}
+	cost = params.fee(nil, nil, true) - params.fee(nil, nil, false) + params.longTermParams().inputFee(Unspent{Address: params.Address})
+	return
+}
+
+// input fee now - input fee at long term fee rate
+func (params SelectionParams) inputWaste(unspent Unspent) Amount {
+	return params.inputFee(unspent) - params.longTermParams().inputFee(unspent)
+}
+
+// waste of Bitcoin Core:
+// sum of (input fee now - input fee at long term fee rate) + (cost of change, or excess when changeless)
+func (params SelectionParams) waste(unspents []Unspent, indexes []int, excess Amount, hasChange bool) (waste Amount) {
+	for _, i := range indexes {
+		waste += params.inputWaste(unspents[i])
+	}
+	if hasChange {
+		waste += params.costOfChange()
+	} else {
+		waste += excess
+	}
+	return
+}
+
+func (params SelectionParams) excess(unspents []Unspent, selection CoinSelection) (excess Amount) {
+	if selection.Change > 0 {
+		return
+	}
+	excess = selection.Sum - params.Target - params.fee(unspents, selection.Indexes, false)
+	return
+}
+
+func (params SelectionParams) report(unspents []Unspent, selection CoinSelection) (report SelectionReport) {
+	report.Selector = selection.Selector
+	report.Inputs = make([]Unspent, 0)
+	for _, i := range selection.Indexes {
+		report.Inputs = append(report.Inputs, unspents[i])
+	}
+	report.Target = params.Target
+	report.FeePerVByte = params.FeePerVByte
+	report.LongTermFeePerVByte = params.longTermParams().FeePerVByte
+	report.Fee = selection.Fee
+	report.Change = selection.Change
+	report.ChangeCost = params.costOfChange()
+	report.Excess = params.excess(unspents, selection)
+	report.Waste = selection.Waste
+	return
+}
+
+func selectorName(coinSelector CoinSelector) string {
+	name := fmt.Sprintf("%T", coinSelector)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func (params SelectionParams) eligibleIndexes(unspents []Unspent) (indexes []int) {
+	indexes = make([]int, 0)
+	for i, unspent := range unspents {
+		if unspent.Confirmations < params.Confirmations {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return
+}
+
+// finalizeSelection decides between a balance(change) output and a changeless tx for the selected unspents
+func finalizeSelection(unspents []Unspent, indexes []int, params SelectionParams) (selection CoinSelection, err error) {
+	if params.FixedFee <= 0 && params.FeePerVByte <= 0 {
+		err = fmt.Errorf("feePerVByte[%f] <= 0", params.FeePerVByte)
+		return
+	}
+	selection.Indexes = indexes
+	for _, i := range indexes {
+		selection.Sum += unspents[i].Amount
+	}
+
+	tFee := params.fee(unspents, indexes, true)
+	tChange := selection.Sum - tFee - params.Target
+	if tChange >= dustThreshold {
+		selection.Fee = tFee
+		selection.Change = tChange
+		selection.Waste = params.waste(unspents, indexes, 0, true)
+		return
+	}
+
+	selection, err = finalizeChangeless(unspents, indexes, params)
+	return
+}
+
+// finalizeChangeless finalizes the selected unspents without a balance(change) output, excess goes to fee
+func finalizeChangeless(unspents []Unspent, indexes []int, params SelectionParams) (selection CoinSelection, err error) {
+	if params.FixedFee <= 0 && params.FeePerVByte <= 0 {
+		err = fmt.Errorf("feePerVByte[%f] <= 0", params.FeePerVByte)
+		return
+	}
+	selection.Indexes = indexes
+	for _, i := range indexes {
+		selection.Sum += unspents[i].Amount
+	}
+
+	tFee := params.fee(unspents, indexes, false)
+	if selection.Sum < tFee+params.Target {
+		err = fmt.Errorf("not sufficient: sumUnspentAmount[%s] < fee[%s] + target[%s]", selection.Sum, tFee, params.Target)
+		return
+	}
+	selection.Fee = selection.Sum - params.Target // excess goes to fee
+	selection.Change = 0
+	selection.Waste = params.waste(unspents, indexes, params.excess(unspents, selection), false)
+	return
+}
+
+// LargestFirstSelector spends the biggest unspents first.
+type LargestFirstSelector struct{}
+
+func (selector LargestFirstSelector) Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error) {
+	indexes := params.eligibleIndexes(unspents)
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return unspents[indexes[i]].Amount > unspents[indexes[j]].Amount
+	})
+	selection, err = accumulateSelect(unspents, indexes, params)
+	return
+}
+
+// SmallestFirstSelector spends the smallest unspents first, consolidating dust at the cost of bigger txs.
+type SmallestFirstSelector struct{}
+
+func (selector SmallestFirstSelector) Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error) {
+	indexes := params.eligibleIndexes(unspents)
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return unspents[indexes[i]].Amount < unspents[indexes[j]].Amount
+	})
+	selection, err = accumulateSelect(unspents, indexes, params)
+	return
+}
+
+func accumulateSelect(unspents []Unspent, orderedIndexes []int, params SelectionParams) (selection CoinSelection, err error) {
+	sumAmountTemp := Amount(0)
+	for count, i := range orderedIndexes {
+		sumAmountTemp += unspents[i].Amount
+
+		// case 1.
+		// when Balance is 0, so did not need balance_tx
+		if sumAmountTemp == params.fee(unspents, orderedIndexes[:count+1], false)+params.Target {
+			selection, err = finalizeSelection(unspents, orderedIndexes[:count+1], params)
+			return
+		}
+
+		// case 2.
+		if sumAmountTemp >= params.fee(unspents, orderedIndexes[:count+1], true)+params.Target {
+			selection, err = finalizeSelection(unspents, orderedIndexes[:count+1], params)
+			return
+		}
+	}
+
+	err = fmt.Errorf("not sufficient: sumUnspentAmount[%s] < fee[%s] + target[%s]", sumAmountTemp, params.fee(unspents, orderedIndexes, true), params.Target)
+	return
+}
+
+// KnapsackSelector is the stochastic subset-sum approximation of Bitcoin Core (before branch-and-bound).
+type KnapsackSelector struct {
+	Rand *rand.Rand // nil: seeded by time
+}
+
+func (selector KnapsackSelector) Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error) {
+	rng := selector.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	target := params.Target + params.fee(nil, nil, false)
+	minChange := params.costOfChange()
+
+	applicable := make([]int, 0)
+	applicableValues := make([]Amount, 0)
+	applicableSum := Amount(0)
+	lowestLarger := -1
+	for _, i := range params.eligibleIndexes(unspents) {
+		tValue := unspents[i].Amount - params.inputFee(unspents[i]) // effective value
+		if tValue <= 0 {
+			continue
+		}
+		if tValue == target {
+			selection, err = finalizeSelection(unspents, []int{i}, params)
+			return
+		}
+		if tValue < target+minChange {
+			applicable = append(applicable, i)
+			applicableValues = append(applicableValues, tValue)
+			applicableSum += tValue
+			continue
+		}
+		if lowestLarger < 0 || tValue < unspents[lowestLarger].Amount-params.inputFee(unspents[lowestLarger]) {
+			lowestLarger = i
+		}
+	}
+	if applicableSum == target {
+		selection, err = finalizeSelection(unspents, applicable, params)
+		return
+	}
+	if applicableSum < target {
+		if lowestLarger < 0 {
+			err = fmt.Errorf("not sufficient: sumEffectiveValue[%s] < target[%s]", applicableSum, target)
+			return
+		}
+		selection, err = finalizeSelection(unspents, []int{lowestLarger}, params)
+		return
+	}
+
+	sort.Sort(sort.Reverse(knapsackCandidates{indexes: applicable, values: applicableValues}))
+	bestIncluded, bestSum := approximateBestSubset(rng, applicableValues, applicableSum, target)
+	if bestSum != target && applicableSum >= target+minChange {
+		bestIncluded, bestSum = approximateBestSubset(rng, applicableValues, applicableSum, target+minChange)
+	}
+
+	if lowestLarger >= 0 && ((bestSum != target && bestSum < target+minChange) || unspents[lowestLarger].Amount-params.inputFee(unspents[lowestLarger]) <= bestSum) {
+		selection, err = finalizeSelection(unspents, []int{lowestLarger}, params)
+		return
+	}
+
+	indexes := make([]int, 0)
+	for n, included := range bestIncluded {
+		if included {
+			indexes = append(indexes, applicable[n])
+		}
+	}
+	selection, err = finalizeSelection(unspents, indexes, params)
+	return
+}
+
+type knapsackCandidates struct {
+	indexes []int
+	values  []Amount
+}
+
+func (candidates knapsackCandidates) Len() int { return len(candidates.indexes) }
+func (candidates knapsackCandidates) Less(i, j int) bool {
+	return candidates.values[i] < candidates.values[j]
+}
+func (candidates knapsackCandidates) Swap(i, j int) {
+	candidates.indexes[i], candidates.indexes[j] = candidates.indexes[j], candidates.indexes[i]
+	candidates.values[i], candidates.values[j] = candidates.values[j], candidates.values[i]
+}
+
+func approximateBestSubset(rng *rand.Rand, values []Amount, totalLower Amount, target Amount) (bestIncluded []bool, bestSum Amount) {
+	bestIncluded = make([]bool, len(values))
+	for i := range bestIncluded {
+		bestIncluded[i] = true
+	}
+	bestSum = totalLower
+
+	included := make([]bool, len(values))
+	for rep := 0; rep < knapsackIterations && bestSum != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		tSum := Amount(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, value := range values {
+				// first pass: random inclusion, second pass: fill up with the rest
+				if (pass == 0 && rng.Intn(2) == 1) || (pass == 1 && !included[i]) {
+					tSum += value
+					included[i] = true
+					if tSum >= target {
+						reachedTarget = true
+						if tSum < bestSum {
+							bestSum = tSum
+							copy(bestIncluded, included)
+						}
+						tSum -= value
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// BranchAndBoundSelector searches for the changeless solution with the least waste within the cost-of-change window, as Bitcoin Core does.
+type BranchAndBoundSelector struct{}
+
+func (selector BranchAndBoundSelector) Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error) {
+	target := params.Target + params.fee(nil, nil, false)
+	upperBound := target + params.costOfChange()
+
+	indexes := make([]int, 0)
+	values := make([]Amount, 0)
+	available := Amount(0)
+	for _, i := range params.eligibleIndexes(unspents) {
+		tValue := unspents[i].Amount - params.inputFee(unspents[i])
+		if tValue <= 0 {
+			continue
+		}
+		indexes = append(indexes, i)
+		values = append(values, tValue)
+		available += tValue
+	}
+	if available < target {
+		err = fmt.Errorf("not sufficient: sumEffectiveValue[%s] < target[%s]", available, target)
+		return
+	}
+	sort.Sort(sort.Reverse(knapsackCandidates{indexes: indexes, values: values}))
+
+	var bestIncluded []int
+	bestWaste := Amount(math.MaxInt64)
+	aboveLongTerm := params.FeePerVByte > params.longTermParams().FeePerVByte
+	tries := 0
+	var search func(position int, currentValue Amount, currentWaste Amount, remaining Amount, included []int)
+	search = func(position int, currentValue Amount, currentWaste Amount, remaining Amount, included []int) {
+		tries += 1
+		if tries > bnbTotalTries {
+			return
+		}
+		if currentValue+remaining < target || currentValue > upperBound {
+			return // cannot reach target, or overshoot the window
+		}
+		if currentValue >= target {
+			tWaste := currentWaste + currentValue - target
+			if tWaste < bestWaste && isChangelessSolution(unspents, indexes, included, params) {
+				bestWaste = tWaste
+				bestIncluded = append([]int{}, included...)
+			}
+			return
+		}
+		if aboveLongTerm && currentWaste > bestWaste {
+			return // more inputs only add waste when fee rate is above long term fee rate
+		}
+		if position >= len(values) {
+			return
+		}
+
+		// include
+		search(position+1, currentValue+values[position], currentWaste+params.inputWaste(unspents[indexes[position]]), remaining-values[position], append(included, position))
+
+		// omit, skipping equal values which would explore the same branch again
+		next := position + 1
+		tRemaining := remaining - values[position]
+		for next < len(values) && values[next] == values[position] {
+			tRemaining -= values[next]
+			next += 1
+		}
+		search(next, currentValue, currentWaste, tRemaining, included)
+	}
+	search(0, 0, 0, available, make([]int, 0))
+
+	if bestIncluded == nil {
+		err = fmt.Errorf("no changeless solution: target[%s] window[%s]", target, upperBound)
+		return
+	}
+
+	selected := make([]int, 0)
+	for _, n := range bestIncluded {
+		selected = append(selected, indexes[n])
+	}
+	selection, err = finalizeChangeless(unspents, selected, params) // the window is at most cost of change, so no change output
+	return
+}
+
+func isChangelessSolution(unspents []Unspent, indexes []int, included []int, params SelectionParams) bool {
+	sum := Amount(0)
+	selected := make([]int, 0)
+	for _, n := range included {
+		sum += unspents[indexes[n]].Amount
+		selected = append(selected, indexes[n])
+	}
+	return sum >= params.Target+params.fee(unspents, selected, false)
+}
+
+// MinWasteSelector runs every selector and keeps the selection with the least waste.
+type MinWasteSelector struct {
+	Selectors []CoinSelector // nil: BranchAndBound, Knapsack, LargestFirst
+}
+
+func (selector MinWasteSelector) Select(unspents []Unspent, params SelectionParams) (selection CoinSelection, err error) {
+	selectors := selector.Selectors
+	if len(selectors) == 0 {
+		selectors = []CoinSelector{BranchAndBoundSelector{}, KnapsackSelector{}, LargestFirstSelector{}}
+	}
+
+	found := false
+	errs := make([]string, 0)
+	for _, coinSelector := range selectors {
+		tSelection, errI := coinSelector.Select(unspents, params)
+		if errI != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", selectorName(coinSelector), errI))
+			continue
+		}
+		if tSelection.Selector == "" {
+			tSelection.Selector = selectorName(coinSelector)
+		}
+		// on equal waste, prefer more inputs for consolidation
+		if !found || tSelection.Waste < selection.Waste || (tSelection.Waste == selection.Waste && len(tSelection.Indexes) > len(selection.Indexes)) {
+			selection = tSelection
+			found = true
+		}
+	}
+	if !found {
+		err = fmt.Errorf("no selection: %s", strings.Join(errs, ", "))
+		return
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package gobitcoinopreturn
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func testUnspents(amounts ...Amount) (unspents []Unspent) {
+	for i, amount := range amounts {
+		unspents = append(unspents, Unspent{TxID: "tx", Vout: i, Amount: amount, Confirmations: 6})
+	}
+	return
+}
+
+func TestCoinSelectors(t *testing.T) {
+	unspents := testUnspents(100000, 50000, 20000, 11500, 3000)
+	params := SelectionParams{
+		Target:        10000,
+		PayAddresses:  []string{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"},
+		OpReturnSizes: []int{10},
+		FeePerVByte:   10,
+		Address:       "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		Confirmations: 3,
+	}
+
+	selectors := map[string]CoinSelector{
+		"LargestFirst":   LargestFirstSelector{},
+		"SmallestFirst":  SmallestFirstSelector{},
+		"Knapsack":       KnapsackSelector{Rand: rand.New(rand.NewSource(1))},
+		"BranchAndBound": BranchAndBoundSelector{},
+	}
+	for name, selector := range selectors {
+		selection, err := selector.Select(unspents, params)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if selection.Sum != selection.Fee+selection.Change+params.Target {
+			t.Fatalf("%s: unbalanced selection %+v", name, selection)
+		}
+		if selection.Fee < params.fee(unspents, selection.Indexes, selection.Change > 0) {
+			t.Fatalf("%s: fee too low %+v", name, selection)
+		}
+	}
+
+	selection, _ := LargestFirstSelector{}.Select(unspents, params)
+	if len(selection.Indexes) != 1 || selection.Indexes[0] != 0 {
+		t.Fatalf("LargestFirst: expected unspent 0, got %+v", selection)
+	}
+}
+
+func TestBranchAndBoundChangeless(t *testing.T) {
+	params := SelectionParams{
+		Target:        10000,
+		PayAddresses:  []string{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"},
+		OpReturnSizes: []int{10},
+		FeePerVByte:   10,
+		Address:       "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		Confirmations: 3,
+	}
+	// 11500 covers target + fee of a 1-in-2-out tx without balance output, within the cost of change
+	unspents := testUnspents(100000, 50000, 11500)
+	selection, err := BranchAndBoundSelector{}.Select(unspents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Change != 0 || len(selection.Indexes) != 1 || selection.Indexes[0] != 2 {
+		t.Fatalf("expected changeless selection of unspent 2, got %+v", selection)
+	}
+
+	_, err = BranchAndBoundSelector{}.Select(testUnspents(100000), params)
+	if err == nil {
+		t.Fatal("expected no changeless solution")
+	}
+}
+
+func TestBranchAndBoundHighFeeRate(t *testing.T) {
+	params := SelectionParams{
+		Target:              10000,
+		OpReturnSizes:       []int{10},
+		FeePerVByte:         200,
+		LongTermFeePerVByte: 20,
+		Address:             "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		Confirmations:       3,
+	}
+	// the window of cost of change is wider than dust, an amount above dust over the fee with balance output is still changeless
+	unspents := testUnspents(0)
+	unspents[0].Amount = params.Target + params.fee(unspents, []int{0}, true) + dustThreshold + 100
+	if params.costOfChange() <= dustThreshold {
+		t.Fatalf("expected cost of change[%s] > dust", params.costOfChange())
+	}
+	selection, err := BranchAndBoundSelector{}.Select(unspents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Change != 0 || selection.Fee != selection.Sum-params.Target {
+		t.Fatalf("expected changeless selection with excess to fee, got %+v", selection)
+	}
+}
+
+func TestCoinSelectorsInsufficient(t *testing.T) {
+	params := SelectionParams{Target: 1000000, OpReturnSizes: []int{10}, FeePerVByte: 10, Confirmations: 3}
+	unspents := testUnspents(100000, 200000)
+	for _, selector := range []CoinSelector{LargestFirstSelector{}, SmallestFirstSelector{}, KnapsackSelector{}, BranchAndBoundSelector{}} {
+		if _, err := selector.Select(unspents, params); err == nil {
+			t.Fatalf("%T: expected insufficient error", selector)
+		}
+	}
+}
+
+func TestCoinSelectorsZeroFee(t *testing.T) {
+	params := SelectionParams{Target: 1000, OpReturnSizes: []int{10}, Confirmations: 3}
+	unspents := testUnspents(100000, 200000)
+	for _, selector := range []CoinSelector{LargestFirstSelector{}, SmallestFirstSelector{}, KnapsackSelector{}, BranchAndBoundSelector{}, MinWasteSelector{}} {
+		if selection, err := selector.Select(unspents, params); err == nil {
+			t.Errorf("%T: zero fee selected %+v", selector, selection)
+		}
+	}
+}
+
+func TestSelectionWaste(t *testing.T) {
+	params := SelectionParams{
+		Target:              10000,
+		PayAddresses:        []string{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"},
+		OpReturnSizes:       []int{10},
+		FeePerVByte:         10,
+		LongTermFeePerVByte: 5,
+		Address:             "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		Confirmations:       3,
+	}
+	unspents := testUnspents(100000, 50000, 11500)
+
+	changeless, err := BranchAndBoundSelector{}.Select(unspents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withChange, err := LargestFirstSelector{}.Select(unspents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 input at 10 sat/vB instead of 5 sat/vB
+	inputWaste := params.inputWaste(unspents[2])
+	if inputWaste <= 0 || withChange.Waste != inputWaste+params.costOfChange() {
+		t.Fatalf("unexpected waste with change: %s", withChange.Waste)
+	}
+	if changeless.Waste != inputWaste+params.excess(unspents, changeless) {
+		t.Fatalf("unexpected changeless waste: %s", changeless.Waste)
+	}
+
+	selection, err := MinWasteSelector{}.Select(unspents, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Selector != "BranchAndBoundSelector" || selection.Waste != changeless.Waste {
+		t.Fatalf("expected BranchAndBoundSelector selection, got %+v", selection)
+	}
+
+	report := params.report(unspents, selection)
+	if len(report.Inputs) != 1 || report.Inputs[0].Amount != 11500 || report.Change != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestCoinSelectorsMixedInputTypes(t *testing.T) {
+	params := SelectionParams{
+		Target:              10000,
+		PayAddresses:        []string{"tb1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c"},
+		FeePerVByte:         10,
+		LongTermFeePerVByte: 5,
+		Address:             "tb1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		Confirmations:       3,
+	}
+	unspents := []Unspent{
+		{TxID: "a", Address: "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", Amount: 20000, Confirmations: 6},
+		{TxID: "b", Amount: 19000, Confirmations: 6}, // address of params
+	}
+	if params.inputFee(unspents[0]) != 1480 || params.inputFee(unspents[1]) != 680 {
+		t.Fatalf("unexpected input fees %d %d", params.inputFee(unspents[0]), params.inputFee(unspents[1]))
+	}
+
+	// above the long term fee rate, the least waste is spending the smaller tb1q input
+	selection, err := MinWasteSelector{}.Select(unspents, params)
+	if err != nil || len(selection.Indexes) != 1 || selection.Indexes[0] != 1 {
+		t.Fatalf("unexpected selection %+v, %v", selection, err)
+	}
+	// 10 + 0.5 + 148 + 0.25 + 68 + 31 + 31
+	if fee := params.fee(unspents, []int{0, 1}, true); fee != 2888 {
+		t.Fatalf("unexpected mixed fee %d", fee)
+	}
+}
@@ -0,0 +1,121 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+)
+
+// package limits of bitcoind: -limitancestorcount, -limitancestorsize, -limitdescendantcount, -limitdescendantsize
+const (
+	maxAncestorCount   = 25
+	maxAncestorVSize   = 101000
+	maxDescendantCount = 25
+	maxDescendantVSize = 101000
+)
+
+// cpfpChildFee is the fee for a child of childVSize lifting the package of parentEntry and its ancestors to feePerVByte.
+func cpfpChildFee(parentEntry MempoolEntry, childVSize int, feePerVByte float64) (fee Amount, err error) {
+	if parentEntry.AncestorCount+1 > maxAncestorCount || parentEntry.AncestorSize+childVSize > maxAncestorVSize {
+		err = fmt.Errorf("ancestor limits exceeded: %d txs, %d vbytes with the child", parentEntry.AncestorCount+1, parentEntry.AncestorSize+childVSize)
+		return
+	}
+	if parentEntry.DescendantCount+1 > maxDescendantCount || parentEntry.DescendantSize+childVSize > maxDescendantVSize {
+		err = fmt.Errorf("descendant limits exceeded: %d txs, %d vbytes with the child", parentEntry.DescendantCount+1, parentEntry.DescendantSize+childVSize)
+		return
+	}
+
+	ancestorFees, err := AmountFromBTC(parentEntry.Fees.Ancestor)
+	if err != nil {
+		err = fmt.Errorf("@AmountFromBTC(%f): %v", parentEntry.Fees.Ancestor, err)
+		return
+	}
+	packageVSize := parentEntry.AncestorSize + childVSize
+	fee = Amount(math.Ceil(feePerVByte*float64(packageVSize))) - ancestorFees
+	if fee <= 0 {
+		err = fmt.Errorf("package of %d vbytes already pays %s, over %f sats/vbyte", parentEntry.AncestorSize, ancestorFees, feePerVByte)
+		return
+	}
+	if minFee := Amount(math.Ceil(minRelayFeePerVByte * float64(childVSize))); fee < minFee {
+		fee = minFee
+	}
+	return
+}
+
+// CPFP spends the unconfirmed balance output of opReturn.OpRetrunTxID back to Address in a child tx,
+// paying for the parent and its unconfirmed ancestors to reach feePerVByte as a package.
+func (opReturn *OpReturn) CPFP(feePerVByte float64) (childTxID string, err error) {
+	if opReturn.OpRetrunTxID == "" {
+		err = fmt.Errorf("no OpRetrunTxID to accelerate")
+		return
+	}
+	bitcoinCli := opReturn.bitcoinCli()
+
+	parent, err := getRawTxVerbose(bitcoinCli, opReturn.OpRetrunTxID)
+	if err != nil {
+		return
+	}
+	if parent.Confirmations > 0 {
+		err = fmt.Errorf("tx '%s' is already confirmed", opReturn.OpRetrunTxID)
+		return
+	}
+	balance := Unspent{TxID: parent.TxID, Vout: -1, Address: opReturn.Address, Expected: true}
+	for _, vout := range parent.Vout {
+		if vout.ScriptPubKey.address() != opReturn.Address {
+			continue
+		}
+		if balance.Vout >= 0 {
+			err = fmt.Errorf("more than one output to '%s' in tx '%s'", opReturn.Address, parent.TxID)
+			return
+		}
+		balance.Vout = vout.N
+		balance.Amount, err = AmountFromBTC(vout.Value)
+		if err != nil {
+			err = fmt.Errorf("@AmountFromBTC(%f): %v", vout.Value, err)
+			return
+		}
+	}
+	if balance.Vout < 0 {
+		err = fmt.Errorf("no balance output to '%s' in tx '%s'", opReturn.Address, parent.TxID)
+		return
+	}
+
+	parentEntry, err := getMempoolEntry(bitcoinCli, parent.TxID)
+	if err != nil {
+		err = fmt.Errorf("tx '%s' is not in mempool: %v", parent.TxID, err)
+		return
+	}
+	childVSize := int(math.Ceil(estimateVBytes([]string{opReturn.Address}, []string{opReturn.Address}, nil)))
+	childFee, err := cpfpChildFee(parentEntry, childVSize, feePerVByte)
+	if err != nil {
+		err = fmt.Errorf("@cpfpChildFee(): %v", err)
+		return
+	}
+	if opReturn.LimitFeeSats > 0 && childFee > opReturn.LimitFeeSats {
+		err = fmt.Errorf("fee[%s] of the child is over the limit[%s]", childFee, opReturn.LimitFeeSats)
+		return
+	}
+	if balance.Amount-childFee < dustThreshold {
+		err = fmt.Errorf("balance output[%s] can not pay the fee[%s] of the child", balance.Amount, childFee)
+		return
+	}
+
+	// child: balance -> Address
+	rawTx, err := createRawTx([]Unspent{balance}, map[string]Amount{opReturn.Address: balance.Amount - childFee}, "")
+	if err != nil {
+		err = fmt.Errorf("@createRawTx(): %v", err)
+		return
+	}
+	signedRawTx, err := signerOrDefault(opReturn.Signer).SignRawTx(bitcoinCli, rawTx, []Unspent{balance}, opReturn.Address)
+	if err != nil {
+		err = fmt.Errorf("@signer.SignRawTx(): %v", err)
+		return
+	}
+	err = callRpc(bitcoinCli, "sendrawtransaction", []interface{}{signedRawTx}, &childTxID)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('sendrawtransaction'): %v", err)
+		return
+	}
+
+	opReturn.ChildTxIDs = append(opReturn.ChildTxIDs, childTxID)
+	return
+}
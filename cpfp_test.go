@@ -0,0 +1,61 @@
+package gobitcoinopreturn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCpfpChildFee(t *testing.T) {
+	entry := MempoolEntry{VSize: 126, AncestorCount: 1, AncestorSize: 126, DescendantCount: 1, DescendantSize: 126}
+	entry.Fees.Ancestor = 0.00000252 // 2 sats/vbyte
+
+	// (126 + 110) x 20 - 252
+	fee, err := cpfpChildFee(entry, 110, 20)
+	if err != nil || fee != 4468 {
+		t.Errorf("fee: %s, err: %v", fee, err)
+	}
+
+	if _, err = cpfpChildFee(entry, 110, 1); err == nil {
+		t.Errorf("expected an error for the package paying enough")
+	}
+
+	entry.AncestorCount = 25
+	if _, err = cpfpChildFee(entry, 110, 20); err == nil || !strings.Contains(err.Error(), "ancestor") {
+		t.Errorf("expected an error of ancestor limits: %v", err)
+	}
+	entry.AncestorCount = 1
+	entry.DescendantSize = 100950
+	if _, err = cpfpChildFee(entry, 110, 20); err == nil || !strings.Contains(err.Error(), "descendant") {
+		t.Errorf("expected an error of descendant limits: %v", err)
+	}
+}
+
+func TestOpReturnCPFP(t *testing.T) {
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	bitcoind := newTestBitcoind(map[string]string{
+		"getrawtransaction": `{"txid":"` + testTxIDA + `","vsize":126,"confirmations":0,
+			"vout":[{"value":0.00048745,"n":0,"scriptPubKey":{"type":"witness_v0_keyhash","address":"` + address + `"}},
+				{"value":0,"n":1,"scriptPubKey":{"hex":"6a0568656c6c6f","type":"nulldata"}}]}`,
+		"getmempoolentry":    `{"vsize":126,"ancestorcount":1,"ancestorsize":126,"descendantcount":1,"descendantsize":126,"fees":{"base":0.00000252,"ancestor":0.00000252,"descendant":0.00000252}}`,
+		"sendrawtransaction": `"childtxid"`,
+	})
+	defer bitcoind.Close()
+
+	opReturn := OpReturn{Address: address, Signer: testWIFSigner(), OpRetrunTxID: testTxIDA}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	childTxID, err := opReturn.CPFP(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if childTxID != "childtxid" || len(opReturn.ChildTxIDs) != 1 {
+		t.Errorf("childTxID: %s, %v", childTxID, opReturn.ChildTxIDs)
+	}
+	// child of 1 P2WPKH input, 1 P2WPKH output: 110 vbytes, (126 + 110) x 20 - 252
+	tx := bitcoind.signedRawTx(t)
+	if len(tx.Inputs) != 1 || tx.Inputs[0].PrevTxID != testTxIDA || tx.Inputs[0].Vout != 0 || len(tx.Outputs) != 1 || tx.Outputs[0].Value != 44277 {
+		t.Errorf("raw tx: %+v", tx)
+	}
+	if bitcoind.called("dumpprivkey") {
+		t.Errorf("dumpprivkey with Signer")
+	}
+}
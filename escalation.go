@@ -0,0 +1,292 @@
+package gobitcoinopreturn
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EscalationCurve maps progress(0 ~ 1) from publishing to the deadline onto 0 ~ 1 of the way
+// from the first fee rate up to LimitFeeSatsPerVByteMax.
+type EscalationCurve func(progress float64) float64
+
+func LinearEscalation(progress float64) float64 {
+	return progress
+}
+
+// QuadraticEscalation stays near the first fee rate early and climbs as the deadline approaches.
+func QuadraticEscalation(progress float64) float64 {
+	return progress * progress
+}
+
+const (
+	defaultEscalationInterval   = time.Minute
+	defaultEscalationSpeedLevel = SpeedLevel2
+)
+
+// EscalationState is persisted at FeeEscalator.StatePath after every change,
+// so a restarted process goes on with the same tx.
+type EscalationState struct {
+	Deadline                  time.Time
+	PublishedAt               time.Time
+	FirstFeePerVByte          float64 // Sats 1
+	FeePerVByte               float64 // Sats 1, of TxID
+	TxID                      string  // "": SignedRawTx is not known to be sent
+	TxIDs                     []string
+	SignedRawTx               string
+	Unspents                  []Unspent
+	PayInfos                  map[string]Amount
+	MessageHex                string
+	Fee                       Amount
+	AmountBalanceUsedUnspends Amount
+	Confirmations             int
+	ConflictConfirmed         bool // inputs are spent in blocks by a tx not persisted, a bump confirmed before a restart
+	UpdatedAt                 time.Time
+}
+
+func (state *EscalationState) saveJob(opReturn OpReturn) {
+	state.TxID = opReturn.OpRetrunTxID
+	state.SignedRawTx = opReturn.SignedRawTx
+	state.Unspents = expectedUnspents(opReturn.Unspents)
+	state.PayInfos = opReturn.PayInfos
+	state.MessageHex = opReturn.MessageHex
+	state.Fee = opReturn.Fee
+	state.AmountBalanceUsedUnspends = opReturn.AmountBalanceUsedUnspends
+	state.addTxID(opReturn.OpRetrunTxID)
+}
+
+func (state *EscalationState) addTxID(txID string) {
+	if txID != "" && (len(state.TxIDs) == 0 || state.TxIDs[len(state.TxIDs)-1] != txID) {
+		state.TxIDs = append(state.TxIDs, txID)
+	}
+}
+
+func (state EscalationState) loadJob(opReturn *OpReturn) {
+	opReturn.OpRetrunTxID = state.TxID
+	opReturn.SignedRawTx = state.SignedRawTx
+	opReturn.Unspents = state.Unspents
+	opReturn.PayInfos = state.PayInfos
+	opReturn.MessageHex = state.MessageHex
+	opReturn.Fee = state.Fee
+	opReturn.AmountBalanceUsedUnspends = state.AmountBalanceUsedUnspends
+}
+
+// FeeEscalator publishes OpReturn at a low speed level, and bumps it by RBF along Curve
+// up to LimitFeeSatsPerVByteMax and LimitFeeSats while it is unconfirmed before Deadline.
+type FeeEscalator struct {
+	OpReturn      OpReturn // SpeedLevelFee "": Level2, LimitFeeSatsPerVByteMax is required
+	Deadline      time.Time
+	Curve         EscalationCurve // nil: QuadraticEscalation
+	StatePath     string          // JSON file of EscalationState
+	Interval      time.Duration   // of Run, 0: 1 min
+	Confirmations int             // to finish, 0: 1
+
+	now func() time.Time // nil: time.Now
+}
+
+func (escalator *FeeEscalator) timeNow() time.Time {
+	if escalator.now != nil {
+		return escalator.now()
+	}
+	return time.Now()
+}
+
+// State reads the persisted state, exists is false before publishing.
+func (escalator *FeeEscalator) State() (state EscalationState, exists bool, err error) {
+	exists, err = loadJSON(escalator.StatePath, &state)
+	return
+}
+
+func (escalator *FeeEscalator) save(state *EscalationState) (err error) {
+	state.UpdatedAt = escalator.timeNow()
+	err = saveJSON(escalator.StatePath, state)
+	if err != nil {
+		err = fmt.Errorf("@saveJSON('%s'): %v", escalator.StatePath, err)
+		return
+	}
+	return
+}
+
+// feePerVByteAt is the fee rate of the curve at now
+func (escalator *FeeEscalator) feePerVByteAt(state EscalationState, now time.Time) float64 {
+	curve := escalator.Curve
+	if curve == nil {
+		curve = QuadraticEscalation
+	}
+	progress := 1.0
+	if span := state.Deadline.Sub(state.PublishedAt); span > 0 {
+		progress = float64(now.Sub(state.PublishedAt)) / float64(span)
+	}
+	if progress < 0.0 {
+		progress = 0.0
+	}
+	if progress > 1.0 {
+		progress = 1.0
+	}
+	maxFeePerVByte := escalator.OpReturn.LimitFeeSatsPerVByteMax
+	if maxFeePerVByte < state.FirstFeePerVByte {
+		maxFeePerVByte = state.FirstFeePerVByte
+	}
+	return state.FirstFeePerVByte + (maxFeePerVByte-state.FirstFeePerVByte)*curve(progress)
+}
+
+// Step publishes, resumes, or bumps once. done is true once the tx has Confirmations.
+func (escalator *FeeEscalator) Step() (state EscalationState, done bool, err error) {
+	if escalator.StatePath == "" {
+		err = fmt.Errorf("no StatePath")
+		return
+	}
+	if escalator.OpReturn.LimitFeeSatsPerVByteMax <= 0 {
+		err = fmt.Errorf("no LimitFeeSatsPerVByteMax to escalate up to")
+		return
+	}
+	state, exists, err := escalator.State()
+	if err != nil {
+		return
+	}
+	opReturn := escalator.OpReturn
+	// copy, preparing adds balance-pay-info
+	opReturn.PayInfos = make(map[string]Amount)
+	for address, amount := range escalator.OpReturn.PayInfos {
+		opReturn.PayInfos[address] = amount
+	}
+	opReturn.Unspents = append([]Unspent{}, escalator.OpReturn.Unspents...)
+	bitcoinCli := opReturn.bitcoinCli()
+	confirmations := escalator.Confirmations
+	if confirmations <= 0 {
+		confirmations = 1
+	}
+
+	// 1. publish, persisting the signed tx before broadcasting it
+	if !exists {
+		if opReturn.SpeedLevelFee == "" && opReturn.ConfirmationTarget == 0 {
+			opReturn.SpeedLevelFee = defaultEscalationSpeedLevel
+		}
+		if err = opReturn.Prepare(); err != nil {
+			err = fmt.Errorf("@opReturn.Prepare(): %v", err)
+			return
+		}
+		state = EscalationState{
+			Deadline:         escalator.Deadline,
+			PublishedAt:      escalator.timeNow(),
+			FirstFeePerVByte: opReturn.EffectiveFeePerVByte,
+			FeePerVByte:      opReturn.EffectiveFeePerVByte,
+		}
+		state.saveJob(opReturn)
+		if err = escalator.save(&state); err != nil {
+			return
+		}
+	}
+
+	if state.ConflictConfirmed {
+		done = true
+		return
+	}
+
+	// 2. broadcast the signed tx, again after a restart, sendrawtransaction of the same tx is harmless
+	if state.TxID == "" {
+		state.TxID, err = sendSignedRawTx(bitcoinCli, state.SignedRawTx)
+		if err != nil {
+			return
+		}
+		state.addTxID(state.TxID)
+		if err = escalator.save(&state); err != nil {
+			return
+		}
+	}
+
+	// 3. confirmed, or replaced by a bump not persisted before a restart
+	txConfirmations, inMempool, err := txStatus(bitcoinCli, state.TxID)
+	if err != nil {
+		return
+	}
+	state.Confirmations = txConfirmations
+	if txConfirmations >= confirmations {
+		done = true
+		err = escalator.save(&state)
+		return
+	}
+	if txConfirmations > 0 {
+		return
+	}
+	if !inMempool {
+		if len(state.Unspents) == 0 {
+			err = fmt.Errorf("tx '%s' is not found, no inputs to follow", state.TxID)
+			return
+		}
+		input := state.Unspents[0]
+		spending, errS := spendingTxID(bitcoinCli, input.TxID, input.Vout)
+		if errS != nil {
+			err = errS
+			return
+		}
+		switch spending {
+		case "":
+			// spent in blocks by another tx: an earlier bump, or one not persisted before a restart, confirmed
+			spent := false
+			if input.Confirmations > 0 {
+				if spent, err = txOutSpent(bitcoinCli, input.TxID, input.Vout); err != nil {
+					return
+				}
+			}
+			if spent {
+				state.ConflictConfirmed = true
+				done = true
+				break
+			}
+			// evicted: broadcast again
+			state.TxID = ""
+		default:
+			if err = opReturn.LoadPending(spending); err != nil {
+				err = fmt.Errorf("@opReturn.LoadPending('%s'): %v", spending, err)
+				return
+			}
+			state.saveJob(opReturn)
+			state.FeePerVByte = opReturn.EffectiveFeePerVByte
+		}
+		err = escalator.save(&state)
+		return
+	}
+
+	// 4. bump along the curve
+	feePerVByte := escalator.feePerVByteAt(state, escalator.timeNow())
+	if feePerVByte < state.FeePerVByte+incrementalRelayFeePerVByte {
+		return
+	}
+	state.loadJob(&opReturn)
+	if err = opReturn.BumpFee(feePerVByte); err != nil {
+		err = fmt.Errorf("@opReturn.BumpFee(%f): %v", feePerVByte, err)
+		return
+	}
+	state.saveJob(opReturn)
+	state.FeePerVByte = opReturn.EffectiveFeePerVByte
+	err = escalator.save(&state)
+	return
+}
+
+// Run steps every Interval until the tx is confirmed or ctx is done. Errors of a step are given to onError, and Run goes on.
+func (escalator *FeeEscalator) Run(ctx context.Context, onError func(err error)) (state EscalationState, err error) {
+	interval := escalator.Interval
+	if interval <= 0 {
+		interval = defaultEscalationInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done := false
+		state, done, err = escalator.Step()
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		err = nil
+		if done {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
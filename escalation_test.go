@@ -0,0 +1,212 @@
+package gobitcoinopreturn
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFeeEscalatorFeePerVByteAt(t *testing.T) {
+	publishedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := EscalationState{PublishedAt: publishedAt, Deadline: publishedAt.Add(time.Hour), FirstFeePerVByte: 10}
+	escalator := FeeEscalator{OpReturn: OpReturn{LimitFeeSatsPerVByteMax: 50}}
+
+	tests := []struct {
+		curve   EscalationCurve
+		elapsed time.Duration
+		want    float64
+	}{
+		{nil, 0, 10},
+		{nil, 30 * time.Minute, 20},
+		{LinearEscalation, 30 * time.Minute, 30},
+		{nil, time.Hour, 50},
+		{nil, 2 * time.Hour, 50},
+		{nil, -time.Minute, 10},
+	}
+	for _, test := range tests {
+		escalator.Curve = test.curve
+		got := escalator.feePerVByteAt(state, publishedAt.Add(test.elapsed))
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("feePerVByteAt(%v): %f, want %f", test.elapsed, got, test.want)
+		}
+	}
+
+	// no room above the first fee rate
+	escalator.OpReturn.LimitFeeSatsPerVByteMax = 0
+	if got := escalator.feePerVByteAt(state, publishedAt.Add(time.Hour)); got != 10 {
+		t.Errorf("feePerVByteAt() without LimitFeeSatsPerVByteMax: %f", got)
+	}
+}
+
+func testEscalator(t *testing.T, bitcoind *testBitcoind, now time.Time) (escalator *FeeEscalator) {
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	opReturn.LimitFeeSatsPerVByteMax = 20
+	opReturn.SignedRawTx = testSignedRawTx
+	escalator = &FeeEscalator{
+		OpReturn:  opReturn,
+		Deadline:  now,
+		StatePath: filepath.Join(t.TempDir(), "escalation.json"),
+		now:       func() time.Time { return now },
+	}
+
+	// published an hour ago at 10 sats/vbyte, then restarted
+	state := EscalationState{Deadline: now, PublishedAt: now.Add(-time.Hour), FirstFeePerVByte: 10, FeePerVByte: 10}
+	state.saveJob(opReturn)
+	if err := escalator.save(&state); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFeeEscalatorStepBumps(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+	escalator := testEscalator(t, bitcoind, time.Now())
+
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done {
+		t.Errorf("done before the confirmation")
+	}
+	// the deadline: LimitFeeSatsPerVByteMax 20 x 125.5 vbytes
+	if state.TxID != "newtxid" || state.Fee != 2510 || state.FeePerVByte < 19.9 || len(state.TxIDs) != 2 || state.TxIDs[0] != "oldtxid" {
+		t.Errorf("state: %+v", state)
+	}
+	persisted, exists, err := escalator.State()
+	if err != nil || !exists || persisted.TxID != "newtxid" || persisted.SignedRawTx == "" || persisted.SignedRawTx == testSignedRawTx {
+		t.Errorf("persisted: %+v, %v, %v", persisted, exists, err)
+	}
+}
+
+func TestFeeEscalatorStepResumes(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+	now := time.Now()
+	escalator := testEscalator(t, bitcoind, now)
+	// a minute after publishing, below the next increment
+	escalator.now = func() time.Time { return now.Add(-59 * time.Minute) }
+
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done || state.TxID != "oldtxid" || len(state.TxIDs) != 1 {
+		t.Errorf("state: %+v, done: %v", state, done)
+	}
+	if bitcoind.called("sendrawtransaction") || bitcoind.called("listunspent") {
+		t.Errorf("published again: %v", bitcoind.methods)
+	}
+}
+
+func TestFeeEscalatorStepPublishes(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+	opReturn := testQueuedOpReturn(bitcoind, "escalated")
+	opReturn.FeeEstimator = StaticFeeEstimator{FeePerVByte: 10}
+	opReturn.PayInfos = map[string]Amount{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT": 1000}
+	escalator := &FeeEscalator{
+		OpReturn:  opReturn,
+		Deadline:  time.Now().Add(time.Hour),
+		StatePath: filepath.Join(t.TempDir(), "escalation.json"),
+	}
+
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done || state.TxID != "newtxid" || len(state.PayInfos) != 2 {
+		t.Errorf("state: %+v, done: %v", state, done)
+	}
+	// the balance-pay-info is not added to the OpReturn of the escalator
+	if len(escalator.OpReturn.PayInfos) != 1 || escalator.OpReturn.Unspents != nil {
+		t.Errorf("OpReturn of the escalator changed: %+v, %+v", escalator.OpReturn.PayInfos, escalator.OpReturn.Unspents)
+	}
+}
+
+func TestFeeEscalatorStepNoLimitFeeSatsPerVByteMax(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+	escalator := testEscalator(t, bitcoind, time.Now())
+	escalator.OpReturn.LimitFeeSatsPerVByteMax = 0
+	escalator.StatePath = filepath.Join(t.TempDir(), "escalation.json")
+
+	if _, _, err := escalator.Step(); err == nil {
+		t.Fatal("expected error without LimitFeeSatsPerVByteMax")
+	}
+	if _, exists, _ := escalator.State(); exists || bitcoind.called("listunspent") || bitcoind.called("sendrawtransaction") {
+		t.Errorf("published without LimitFeeSatsPerVByteMax: %v", bitcoind.methods)
+	}
+}
+
+func TestFeeEscalatorStepConfirmed(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"getrawtransaction": `{"txid":"oldtxid","confirmations":2}`,
+	})
+	defer bitcoind.Close()
+	escalator := testEscalator(t, bitcoind, time.Now())
+
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done || state.Confirmations != 2 || state.TxID != "oldtxid" {
+		t.Errorf("state: %+v, done: %v", state, done)
+	}
+}
+
+func TestFeeEscalatorStepRebroadcastsEvicted(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+	escalator := testEscalator(t, bitcoind, time.Now())
+
+	// oldtxid is neither in mempool nor in blocks, and nothing spends its input
+	entry := bitcoind.results["getmempoolentry"]
+	delete(bitcoind.results, "getmempoolentry")
+	bitcoind.results["gettxspendingprevout"] = `[{"txid":"` + testTxIDA + `","vout":0}]`
+	bitcoind.results["gettxout"] = `{"value":0.0005,"confirmations":10}`
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done || state.TxID != "" || state.SignedRawTx == "" {
+		t.Errorf("state: %+v, done: %v", state, done)
+	}
+
+	bitcoind.results["getmempoolentry"] = entry
+	bitcoind.results["sendrawtransaction"] = `"oldtxid"`
+	escalator.now = func() time.Time { return state.PublishedAt }
+	state, _, err = escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bitcoind.called("sendrawtransaction") || state.TxID != "oldtxid" || len(state.TxIDs) != 1 {
+		t.Errorf("state: %+v", state)
+	}
+}
+
+func TestFeeEscalatorStepConflictConfirmed(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+	escalator := testEscalator(t, bitcoind, time.Now())
+
+	// oldtxid is gone, and its input is spent in blocks by a bump not persisted
+	delete(bitcoind.results, "getmempoolentry")
+	bitcoind.results["gettxspendingprevout"] = `[{"txid":"` + testTxIDA + `","vout":0}]`
+	bitcoind.results["gettxout"] = `null`
+	state, done, err := escalator.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done || !state.ConflictConfirmed || bitcoind.called("sendrawtransaction") {
+		t.Errorf("state: %+v, done: %v", state, done)
+	}
+
+	// finished after a restart, without broadcasting again
+	bitcoind.methods = nil
+	if _, done, err = escalator.Step(); err != nil || !done || len(bitcoind.methods) != 0 {
+		t.Errorf("done: %v, err: %v, called: %v", done, err, bitcoind.methods)
+	}
+}
@@ -0,0 +1,117 @@
+package gobitcoinopreturn
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultFeeCacheTTL = time.Minute
+
+type FeeCacheStats struct {
+	Hits      int64 // fresh fees
+	StaleHits int64 // stale fees served while revalidating
+	Misses    int64 // callers which waited for Estimator
+	Coalesced int64 // misses which joined a request already in flight
+	Errors    int64 // failed requests of Estimator, not cached
+}
+
+type feeCall struct {
+	done       chan struct{}
+	remoteFees RemoteFees
+	err        error
+}
+
+// CachedFeeEstimator shares the fees of Estimator among callers for TTL,
+// serves them for StaleTTL more while one background request revalidates,
+// and lets concurrent misses wait on a single request. Use it by pointer.
+type CachedFeeEstimator struct {
+	Estimator FeeEstimator
+	TTL       time.Duration // 0: 1 min
+	StaleTTL  time.Duration // 0: no stale fees
+
+	now        func() time.Time // nil: time.Now
+	mutex      sync.Mutex
+	remoteFees RemoteFees
+	fetchedAt  time.Time
+	hasFees    bool
+	inFlight   *feeCall
+	stats      FeeCacheStats
+}
+
+func (cache *CachedFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	now := time.Now
+	if cache.now != nil {
+		now = cache.now
+	}
+	ttl := cache.TTL
+	if ttl <= 0 {
+		ttl = defaultFeeCacheTTL
+	}
+
+	cache.mutex.Lock()
+	age := now().Sub(cache.fetchedAt)
+	if cache.hasFees && age < ttl {
+		cache.stats.Hits += 1
+		remoteFees = cache.remoteFees
+		cache.mutex.Unlock()
+		return
+	}
+	if cache.hasFees && age < ttl+cache.StaleTTL {
+		cache.stats.StaleHits += 1
+		remoteFees = cache.remoteFees
+		if cache.inFlight == nil {
+			cache.fetch(now)
+		}
+		cache.mutex.Unlock()
+		return
+	}
+
+	cache.stats.Misses += 1
+	call := cache.inFlight
+	if call != nil {
+		cache.stats.Coalesced += 1
+	} else {
+		call = cache.fetch(now)
+	}
+	cache.mutex.Unlock()
+
+	<-call.done
+	remoteFees, err = call.remoteFees, call.err
+	return
+}
+
+// fetch starts a request of Estimator. cache.mutex must be held.
+func (cache *CachedFeeEstimator) fetch(now func() time.Time) (call *feeCall) {
+	call = &feeCall{done: make(chan struct{})}
+	cache.inFlight = call
+	go func() {
+		call.remoteFees, call.err = cache.Estimator.EstimateFees()
+
+		cache.mutex.Lock()
+		if call.err == nil {
+			cache.remoteFees = call.remoteFees
+			cache.fetchedAt = now()
+			cache.hasFees = true
+		} else {
+			cache.stats.Errors += 1
+		}
+		cache.inFlight = nil
+		cache.mutex.Unlock()
+		close(call.done)
+	}()
+	return
+}
+
+func (cache *CachedFeeEstimator) Stats() (stats FeeCacheStats) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	stats = cache.stats
+	return
+}
+
+// Invalidate drops the cached fees, the next call waits for Estimator.
+func (cache *CachedFeeEstimator) Invalidate() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.hasFees = false
+}
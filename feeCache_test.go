@@ -0,0 +1,98 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingFeeEstimator struct {
+	calls   int64
+	release chan struct{}
+	fail    bool
+}
+
+func (estimator *countingFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	calls := atomic.AddInt64(&estimator.calls, 1)
+	if estimator.release != nil {
+		<-estimator.release
+	}
+	if estimator.fail {
+		err = fmt.Errorf("unavailable")
+		return
+	}
+	remoteFees = RemoteFees{FastestFee: float64(calls), Source: "counting"}
+	return
+}
+
+func TestCachedFeeEstimatorCoalescing(t *testing.T) {
+	estimator := &countingFeeEstimator{release: make(chan struct{})}
+	cache := &CachedFeeEstimator{Estimator: estimator, TTL: time.Hour}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			remoteFees, err := cache.EstimateFees()
+			if err != nil || remoteFees.FastestFee != 1 {
+				t.Errorf("remoteFees: %+v, err: %v", remoteFees, err)
+			}
+		}()
+	}
+	for cache.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(estimator.release)
+	wg.Wait()
+
+	if _, err := cache.EstimateFees(); err != nil {
+		t.Fatal(err)
+	}
+	stats := cache.Stats()
+	if estimator.calls != 1 || stats.Misses != 10 || stats.Coalesced != 9 || stats.Hits != 1 {
+		t.Errorf("calls: %d, stats: %+v", estimator.calls, stats)
+	}
+}
+
+func TestCachedFeeEstimatorStale(t *testing.T) {
+	clock := time.Unix(0, 0)
+	var clockMutex sync.Mutex
+	now := func() time.Time {
+		clockMutex.Lock()
+		defer clockMutex.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		clockMutex.Lock()
+		defer clockMutex.Unlock()
+		clock = clock.Add(d)
+	}
+
+	estimator := &countingFeeEstimator{}
+	cache := &CachedFeeEstimator{Estimator: estimator, TTL: time.Minute, StaleTTL: time.Minute, now: now}
+	if remoteFees, _ := cache.EstimateFees(); remoteFees.FastestFee != 1 {
+		t.Fatalf("remoteFees: %+v", remoteFees)
+	}
+
+	// stale: the cached fees right away, revalidated in background
+	advance(time.Minute + time.Second)
+	if remoteFees, _ := cache.EstimateFees(); remoteFees.FastestFee != 1 {
+		t.Errorf("stale remoteFees: %+v", remoteFees)
+	}
+	for remoteFees, _ := cache.EstimateFees(); remoteFees.FastestFee != 2; remoteFees, _ = cache.EstimateFees() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// expired: errors are not cached
+	estimator.fail = true
+	advance(time.Hour)
+	if _, err := cache.EstimateFees(); err == nil {
+		t.Errorf("expected an error of expired fees")
+	}
+	if stats := cache.Stats(); stats.StaleHits == 0 || stats.Errors != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
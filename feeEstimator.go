@@ -0,0 +1,347 @@
+package gobitcoinopreturn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+const (
+	defaultMempoolSpaceBaseURL = "https://mempool.space"
+	defaultFeeTimeout          = time.Millisecond * 1300 // TimeOut 1.3(sec)
+)
+
+type FeeEstimator interface {
+	EstimateFees() (remoteFees RemoteFees, err error)
+}
+
+// MempoolSpaceFeeEstimator requests /api/v1/fees/recommended of mempool.space or a compatible instance.
+type MempoolSpaceFeeEstimator struct {
+	BaseURL string        // "": https://mempool.space, e.g. https://mempool.space/testnet, https://mempool.space/signet
+	Client  *http.Client  // nil: http.DefaultClient
+	Timeout time.Duration // 0: 1.3(sec)
+}
+
+func (estimator MempoolSpaceFeeEstimator) baseURL() string {
+	if estimator.BaseURL == "" {
+		return defaultMempoolSpaceBaseURL
+	}
+	return strings.TrimRight(estimator.BaseURL, "/")
+}
+
+func (estimator MempoolSpaceFeeEstimator) getJson(path string, result interface{}) (err error) {
+	timeout := estimator.Timeout
+	if timeout <= 0 {
+		timeout = defaultFeeTimeout
+	}
+	client := estimator.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	tURL := estimator.baseURL() + path
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tURL, nil)
+	if err != nil {
+		err = fmt.Errorf("@http.NewRequestWithContext('%s'): %v", tURL, err)
+		return
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("@client.Do('%s'): %v", tURL, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("'%s': status %s", tURL, res.Status)
+		return
+	}
+
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		err = fmt.Errorf("@json.NewDecoder(res.Body).Decode(): '%s': %v", tURL, err)
+		return
+	}
+	return
+}
+
+func (estimator MempoolSpaceFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	err = estimator.getJson("/api/v1/fees/recommended", &remoteFees)
+	if err != nil {
+		return
+	}
+	remoteFees.Source = estimator.baseURL()
+
+	if remoteFees.FastestFee <= 0.0 {
+		err = fmt.Errorf("'%s': no fees: %+v", remoteFees.Source, remoteFees)
+		return
+	}
+	if (remoteFees.FastestFee == remoteFees.MinimumFee) && remoteFees.MinimumFee > 1.0 {
+		err = fmt.Errorf("'%s': suspicious fees, fastestFee == minimumFee: %+v", remoteFees.Source, remoteFees)
+		return
+	}
+	return
+}
+
+// BitcoindFeeEstimator maps estimatesmartfee of the node onto the levels of RemoteFees.
+type BitcoindFeeEstimator struct {
+	RpcUser      string
+	RpcPW        string
+	RpcConnect   string
+	RpcPort      string
+	RpcPath      string
+	EstimateMode string // "": node default, "economical", "conservative"
+}
+
+// confirmation targets(blocks) of RemoteFees levels
+const (
+	confTargetFastestFee  = 1
+	confTargetHalfHourFee = 3
+	confTargetHourFee     = 6
+	confTargetEconomyFee  = 144
+	confTargetMinimumFee  = 1008
+)
+
+type levelTarget struct {
+	confTarget int
+	fee        *float64
+}
+
+// levelTargets points the levels of remoteFees with their confirmation targets
+func (remoteFees *RemoteFees) levelTargets() []levelTarget {
+	return []levelTarget{
+		{confTargetFastestFee, &remoteFees.FastestFee},
+		{confTargetHalfHourFee, &remoteFees.HalfHourFee},
+		{confTargetHourFee, &remoteFees.HourFee},
+		{confTargetEconomyFee, &remoteFees.EconomyFee},
+		{confTargetMinimumFee, &remoteFees.MinimumFee},
+	}
+}
+
+func (estimator BitcoindFeeEstimator) bitcoinCli() goBitcoinCli.BitcoinRpc {
+	return goBitcoinCli.BitcoinRpc{
+		RpcUser:    estimator.RpcUser,
+		RpcPW:      estimator.RpcPW,
+		RpcConnect: estimator.RpcConnect,
+		RpcPort:    estimator.RpcPort,
+		RpcPath:    estimator.RpcPath,
+	}
+}
+
+// EstimateFeeForTarget returns sats/vbyte of estimatesmartfee for confirmation in confTarget blocks.
+func (estimator BitcoindFeeEstimator) EstimateFeeForTarget(confTarget int) (feePerVByte float64, err error) {
+	params := []interface{}{confTarget}
+	if estimator.EstimateMode != "" {
+		params = append(params, estimator.EstimateMode)
+	}
+
+	type resultEstimateSmartFee struct {
+		FeeRate float64  `json:"feerate"` // BTC/kvB
+		Errors  []string `json:"errors"`
+		Blocks  int      `json:"blocks"`
+	}
+	result := resultEstimateSmartFee{}
+	err = callRpc(estimator.bitcoinCli(), "estimatesmartfee", params, &result)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('estimatesmartfee', %d): %v", confTarget, err)
+		return
+	}
+	if result.FeeRate <= 0.0 {
+		err = fmt.Errorf("estimatesmartfee(%d): no feerate: %v", confTarget, result.Errors)
+		return
+	}
+
+	feePerVByte = result.FeeRate * SatoshiPerBitcoin / 1000.0
+	return
+}
+
+func (estimator BitcoindFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	for _, level := range remoteFees.levelTargets() {
+		*level.fee, err = estimator.EstimateFeeForTarget(level.confTarget)
+		if err != nil {
+			return
+		}
+	}
+	remoteFees.Source = "bitcoind:estimatesmartfee"
+	return
+}
+
+// StaticFeeEstimator returns the same fee rate for every level.
+type StaticFeeEstimator struct {
+	FeePerVByte float64 // Sats 1
+}
+
+func (estimator StaticFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	if estimator.FeePerVByte <= 0.0 {
+		err = fmt.Errorf("incorrect StaticFeeEstimator.FeePerVByte[%f]", estimator.FeePerVByte)
+		return
+	}
+	remoteFees = RemoteFees{
+		FastestFee:  estimator.FeePerVByte,
+		HalfHourFee: estimator.FeePerVByte,
+		HourFee:     estimator.FeePerVByte,
+		EconomyFee:  estimator.FeePerVByte,
+		MinimumFee:  estimator.FeePerVByte,
+		Source:      "static",
+	}
+	return
+}
+
+// snapshotFeeEstimator answers the same RemoteFees fetched once, to price many txs alike.
+type snapshotFeeEstimator RemoteFees
+
+func (snapshot snapshotFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	remoteFees = RemoteFees(snapshot)
+	return
+}
+
+// FallbackFeeEstimator returns the fees of the first estimator which succeeds.
+type FallbackFeeEstimator struct {
+	Estimators []FeeEstimator
+}
+
+func (estimator FallbackFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	errs := make([]string, 0)
+	for _, feeEstimator := range estimator.Estimators {
+		remoteFees, err = feeEstimator.EstimateFees()
+		if err == nil {
+			return
+		}
+		errs = append(errs, err.Error())
+	}
+	err = fmt.Errorf("all of fee estimators failed: [%s]", strings.Join(errs, "], ["))
+	return
+}
+
+// DefaultFeeCache shares mempool.space fees among all of OpReturn and Payment without FeeEstimator.
+var DefaultFeeCache = &CachedFeeEstimator{
+	Estimator: MempoolSpaceFeeEstimator{},
+	TTL:       defaultFeeCacheTTL,
+	StaleTTL:  defaultFeeCacheTTL * 4,
+}
+
+// DefaultFeeEstimator is used by OpReturn and Payment without FeeEstimator, and fails while mempool.space is unavailable.
+// Replace it before any Run to change the timeout or http.Client of every job, or to opt in to a static fee rate:
+// FallbackFeeEstimator{Estimators: []FeeEstimator{DefaultFeeCache, StaticFeeEstimator{FeePerVByte: 30}}}
+var DefaultFeeEstimator FeeEstimator = DefaultFeeCache
+
+const defaultConsensusMaxDeviation = 1.0
+
+// ConsensusFeeEstimator asks all of Estimators in parallel, rejects sources far from the others
+// and takes the median of each level of the rest.
+type ConsensusFeeEstimator struct {
+	Estimators   []FeeEstimator
+	MinSources   int     // sources required after rejection, at least 2. 0: a majority of Estimators
+	MaxDeviation float64 // a source is rejected when a level is over (1+MaxDeviation) x or under 1/(1+MaxDeviation) x the median, and 1 sat/vbyte apart. 0: 1.0
+}
+
+func (estimator ConsensusFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	minSources := estimator.MinSources
+	if minSources <= 0 {
+		minSources = len(estimator.Estimators)/2 + 1
+	}
+	if minSources < 2 { // never decided by one response
+		minSources = 2
+	}
+	maxDeviation := estimator.MaxDeviation
+	if maxDeviation <= 0.0 {
+		maxDeviation = defaultConsensusMaxDeviation
+	}
+
+	results := make([]RemoteFees, len(estimator.Estimators))
+	errs := make([]error, len(estimator.Estimators))
+	var wg sync.WaitGroup
+	for i, feeEstimator := range estimator.Estimators {
+		wg.Add(1)
+		go func(i int, feeEstimator FeeEstimator) {
+			defer wg.Done()
+			results[i], errs[i] = feeEstimator.EstimateFees()
+		}(i, feeEstimator)
+	}
+	wg.Wait()
+
+	candidates := make([]RemoteFees, 0)
+	failures := make([]string, 0)
+	for i := range results {
+		if errs[i] != nil {
+			failures = append(failures, errs[i].Error())
+			continue
+		}
+		candidates = append(candidates, results[i])
+	}
+
+	// outliers can only be told apart by a majority
+	accepted := candidates
+	if len(candidates) >= 3 {
+		medians := medianRemoteFees(candidates)
+		accepted = make([]RemoteFees, 0)
+		for _, candidate := range candidates {
+			if candidate.deviatesFrom(medians, maxDeviation) {
+				remoteFees.Rejected = append(remoteFees.Rejected, candidate.Source)
+				continue
+			}
+			accepted = append(accepted, candidate)
+		}
+	}
+	if len(accepted) < minSources {
+		err = fmt.Errorf("%d of %d fee sources agreed, %d required: rejected %v, failed [%s]",
+			len(accepted), len(estimator.Estimators), minSources, remoteFees.Rejected, strings.Join(failures, "], ["))
+		return
+	}
+
+	rejected := remoteFees.Rejected
+	remoteFees = medianRemoteFees(accepted)
+	remoteFees.Rejected = rejected
+	for _, fees := range accepted {
+		remoteFees.Sources = append(remoteFees.Sources, fees.Source)
+	}
+	remoteFees.Source = "consensus(" + strings.Join(remoteFees.Sources, ", ") + ")"
+	return
+}
+
+func (remoteFees RemoteFees) levels() []float64 {
+	return []float64{remoteFees.FastestFee, remoteFees.HalfHourFee, remoteFees.HourFee, remoteFees.EconomyFee, remoteFees.MinimumFee}
+}
+
+func (remoteFees RemoteFees) deviatesFrom(medians RemoteFees, maxDeviation float64) bool {
+	medianLevels := medians.levels()
+	for i, fee := range remoteFees.levels() {
+		if math.Abs(fee-medianLevels[i]) <= 1.0 { // near the floor of 1 sat/vbyte
+			continue
+		}
+		if fee > medianLevels[i]*(1.0+maxDeviation) || fee < medianLevels[i]/(1.0+maxDeviation) {
+			return true
+		}
+	}
+	return false
+}
+
+func medianRemoteFees(remoteFeesList []RemoteFees) (medians RemoteFees) {
+	median := func(level func(RemoteFees) float64) float64 {
+		fees := make([]float64, 0, len(remoteFeesList))
+		for _, remoteFees := range remoteFeesList {
+			fees = append(fees, level(remoteFees))
+		}
+		sort.Float64s(fees)
+		if len(fees)%2 == 0 {
+			return (fees[len(fees)/2-1] + fees[len(fees)/2]) / 2
+		}
+		return fees[len(fees)/2]
+	}
+	medians.FastestFee = median(func(r RemoteFees) float64 { return r.FastestFee })
+	medians.HalfHourFee = median(func(r RemoteFees) float64 { return r.HalfHourFee })
+	medians.HourFee = median(func(r RemoteFees) float64 { return r.HourFee })
+	medians.EconomyFee = median(func(r RemoteFees) float64 { return r.EconomyFee })
+	medians.MinimumFee = median(func(r RemoteFees) float64 { return r.MinimumFee })
+	return
+}
@@ -0,0 +1,186 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMempoolSpaceFeeEstimator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/testnet/api/v1/fees/recommended" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"fastestFee":40,"halfHourFee":30,"hourFee":20,"economyFee":10,"minimumFee":5}`)
+	}))
+	defer server.Close()
+
+	estimator := MempoolSpaceFeeEstimator{BaseURL: server.URL + "/testnet/"}
+	remoteFees, err := estimator.EstimateFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFees.FastestFee != 40 || remoteFees.MinimumFee != 5 || remoteFees.Source != server.URL+"/testnet" {
+		t.Errorf("remoteFees: %+v", remoteFees)
+	}
+
+	_, err = MempoolSpaceFeeEstimator{BaseURL: server.URL}.EstimateFees()
+	if err == nil {
+		t.Errorf("expected an error for 404")
+	}
+}
+
+func TestMempoolSpaceFeeEstimatorSuspicious(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"fastestFee":12,"halfHourFee":12,"hourFee":12,"economyFee":12,"minimumFee":12}`)
+	}))
+	defer server.Close()
+
+	_, err := MempoolSpaceFeeEstimator{BaseURL: server.URL}.EstimateFees()
+	if err == nil {
+		t.Errorf("expected an error for fastestFee == minimumFee")
+	}
+}
+
+func TestBitcoindFeeEstimator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), `"params":[1]`):
+			fmt.Fprint(w, `{"result":{"feerate":0.0004,"blocks":2},"error":null,"id":"GoBitcoinOpReturn"}`)
+		case strings.Contains(string(body), `"params":[1008]`):
+			fmt.Fprint(w, `{"result":{"errors":["Insufficient data or no feerate found"],"blocks":0},"error":null,"id":"GoBitcoinOpReturn"}`)
+		default:
+			fmt.Fprint(w, `{"result":{"feerate":0.0001,"blocks":6},"error":null,"id":"GoBitcoinOpReturn"}`)
+		}
+	}))
+	defer server.Close()
+
+	hostPort := strings.TrimPrefix(server.URL, "http://")
+	estimator := BitcoindFeeEstimator{
+		RpcConnect: hostPort[:strings.LastIndex(hostPort, ":")],
+		RpcPort:    hostPort[strings.LastIndex(hostPort, ":")+1:],
+	}
+
+	fee, err := estimator.EstimateFeeForTarget(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 40 {
+		t.Errorf("fee: %f, expected 40", fee)
+	}
+
+	_, err = estimator.EstimateFees()
+	if err == nil {
+		t.Errorf("expected an error for no feerate of 1008 blocks")
+	}
+}
+
+type failingFeeEstimator struct{}
+
+func (failingFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	err = fmt.Errorf("unavailable")
+	return
+}
+
+func TestFallbackFeeEstimator(t *testing.T) {
+	estimator := FallbackFeeEstimator{Estimators: []FeeEstimator{
+		failingFeeEstimator{},
+		StaticFeeEstimator{FeePerVByte: 7},
+	}}
+	fee, source, err := getFeePerVByte3(estimator, 1, 100, SpeedLevel8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 7 || source != "static" {
+		t.Errorf("fee: %f, source: %s", fee, source)
+	}
+
+	_, _, err = getFeePerVByte3(FallbackFeeEstimator{Estimators: []FeeEstimator{failingFeeEstimator{}}}, 1, 100, SpeedLevel8, 0)
+	if err == nil {
+		t.Errorf("expected an error when all estimators fail")
+	}
+}
+
+func TestDefaultFeeEstimatorNoStaticFallback(t *testing.T) {
+	estimator := DefaultFeeCache.Estimator
+	DefaultFeeCache.Estimator = failingFeeEstimator{}
+	defer func() { DefaultFeeCache.Estimator = estimator }()
+
+	if _, _, err := getFeePerVByte3(nil, 1, 100, SpeedLevel8, 0); err == nil {
+		t.Errorf("expected an error without an opted in static fee rate")
+	}
+}
+
+func TestSelectUnspentsForSendFeeSource(t *testing.T) {
+	opReturn := OpReturn{
+		Address:       "bc1qtest",
+		PayInfos:      map[string]Amount{},
+		MessageHex:    "00",
+		Unspents:      testUnspents(100000),
+		FeeEstimator:  StaticFeeEstimator{FeePerVByte: 2},
+		SpeedLevelFee: "Level6",
+	}
+	opReturn.LimitFeeSatsPerVByteMax = 100
+	if err := opReturn.selectUnspentsForSend(); err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.SelectionReport.FeeSource != "static" || opReturn.SelectionReport.FeePerVByte != 2 {
+		t.Errorf("report: %+v", opReturn.SelectionReport)
+	}
+}
+
+type testFeeEstimator RemoteFees
+
+func (estimator testFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	remoteFees = RemoteFees(estimator)
+	return
+}
+
+func TestConsensusFeeEstimator(t *testing.T) {
+	estimator := ConsensusFeeEstimator{Estimators: []FeeEstimator{
+		testFeeEstimator{FastestFee: 40, HalfHourFee: 30, HourFee: 20, EconomyFee: 10, MinimumFee: 2, Source: "a"},
+		testFeeEstimator{FastestFee: 44, HalfHourFee: 34, HourFee: 22, EconomyFee: 12, MinimumFee: 1, Source: "b"},
+		testFeeEstimator{FastestFee: 900, HalfHourFee: 800, HourFee: 700, EconomyFee: 600, MinimumFee: 500, Source: "bad"},
+		testFeeEstimator{FastestFee: 42, HalfHourFee: 31, HourFee: 21, EconomyFee: 11, MinimumFee: 1, Source: "c"},
+		failingFeeEstimator{},
+	}}
+	remoteFees, err := estimator.EstimateFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFees.FastestFee != 42 || remoteFees.HalfHourFee != 31 || remoteFees.MinimumFee != 1 {
+		t.Errorf("remoteFees: %+v", remoteFees)
+	}
+	if strings.Join(remoteFees.Sources, ",") != "a,b,c" || strings.Join(remoteFees.Rejected, ",") != "bad" {
+		t.Errorf("sources: %v, rejected: %v", remoteFees.Sources, remoteFees.Rejected)
+	}
+
+	estimator.MinSources = 4
+	_, err = estimator.EstimateFees()
+	if err == nil {
+		t.Errorf("expected an error for 3 of required 4 sources")
+	}
+
+	// a majority of the sources by default, and never one
+	a, b := estimator.Estimators[0], estimator.Estimators[1]
+	tests := []struct {
+		estimator ConsensusFeeEstimator
+		wantErr   bool
+	}{
+		{ConsensusFeeEstimator{Estimators: []FeeEstimator{a, b, failingFeeEstimator{}}}, false},
+		{ConsensusFeeEstimator{Estimators: []FeeEstimator{a, failingFeeEstimator{}, failingFeeEstimator{}}}, true},
+		{ConsensusFeeEstimator{Estimators: []FeeEstimator{a, b, failingFeeEstimator{}, failingFeeEstimator{}}}, true},
+		{ConsensusFeeEstimator{Estimators: []FeeEstimator{a}}, true},
+		{ConsensusFeeEstimator{Estimators: []FeeEstimator{a, failingFeeEstimator{}}, MinSources: 1}, true},
+	}
+	for i, test := range tests {
+		if _, err = test.estimator.EstimateFees(); (err != nil) != test.wantErr {
+			t.Errorf("test %d: %v", i, err)
+		}
+	}
+}
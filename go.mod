@@ -2,4 +2,14 @@ module github.com/ideajoo/go-bitcoin-opreturn
 
 go 1.19
 
-require github.com/ideajoo/go-bitcoin-cli-light v0.1.7
+require (
+	github.com/btcsuite/btcd/btcec/v2 v2.3.2
+	github.com/ideajoo/go-bitcoin-cli-light v0.1.7
+	golang.org/x/crypto v0.9.0
+)
+
+require (
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
+	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
+)
@@ -22,27 +22,44 @@ type OpReturn struct {
 	RpcPort                   string
 	RpcPath                   string
 	Address                   string
-	PrivKey                   string
-	PayInfos                  map[string]float64
+	Signer                    Signer               `json:"-"` // nil: AutoSigner, dumpprivkey falling back to the wallet
+	KeyOrigins                map[string]KeyOrigin // address: BIP32 origin of its key for ExportPsbt, nil: no derivations
+	PayInfos                  map[string]Amount
 	Message                   string
 	MessageHex                string
 	Unspents                  []Unspent
 	Confirmations             int
-	SpeedLevelFee             string  // Lv1.Min, Lv2.Eco, Lv3.(Eco+1H)/2 Lv4.1H Lv5.(1H+30m)/2 Lv6.30m Lv7.(30m+Fast)/2 Lv8.Fast
-	LimitFeeSatsPerVByteMax   float64 // Sats 1
-	LimitFeeSatsPerVByteMin   float64 // Sats 1
-	LimitFeeSats              float64 // Sats 1
-	Fee                       float64 // BTC 0.00000001
-	AmountBalanceUsedUnspends float64
+	CoinSelector              CoinSelector // nil: LargestFirstSelector
+	FeeEstimator              FeeEstimator // nil: DefaultFeeEstimator, cached mempool.space without fallback
+	SpeedLevelFee             SpeedLevel   // Lv1.Min, Lv2.Eco, Lv3.(Eco+1H)/2 Lv4.1H Lv5.(1H+30m)/2 Lv6.30m Lv7.(30m+Fast)/2 Lv8.Fast, "": Lv6
+	ConfirmationTarget        int          // blocks 1 ~ 1008 instead of SpeedLevelFee, 0: SpeedLevelFee
+	LimitFeeSatsPerVByteMax   float64      // Sats 1
+	LimitFeeSatsPerVByteMin   float64      // Sats 1
+	LimitFeeSats              Amount
+	LongTermFeePerVByte       float64 // Sats 1, for waste of selection. 0: 10
+	FeeReconcileMargin        float64 // rebuild when the signed tx pays more than (1+margin) x fee rate. 0: only when paying less
+	Fee                       Amount
+	AmountBalanceUsedUnspends Amount
+	SelectionReport           SelectionReport
 	RawTx                     string
 	SignedRawTx               string
+	VSize                     int     // vbytes of SignedRawTx
+	EffectiveFeePerVByte      float64 // Sats 1, Fee / VSize
 	OpRetrunTxID              string
+	ReplacedTxIDs             []string // replaced by BumpFee, oldest first
+	ChildTxIDs                []string // sent by CPFP
+	CancelTxID                string   // sent by Cancel
+
+	chainReserve Amount // kept in the balance for the txs chained on it by PublishQueue
 }
 
+const maxFeeReconciles = 3
+
 type Unspent struct {
 	TxID          string
 	Vout          int
-	Amount        float64
+	Address       string // "": address of OpReturn or Payment
+	Amount        Amount
 	Confirmations int
 	Expected      bool
 }
@@ -71,200 +88,305 @@ func ConvertHexToText(hexStr string) (readableStr string, validUTF8 bool, err er
 	return
 }
 
-func calFee(countTxIns int, countTxOuts int, feePerVByte float64, address ...string) (fee float64) {
-
-	tAddressType := "P2PKH" // Legacy
-	if len(address) > 0 {
-		if len(address[0]) > 4 {
-			if address[0][0:4] == "bc1q" {
-				tAddressType = "P2WPKH"
-			}
+func listUnspents(bitcoinCli goBitcoinCli.BitcoinRpc, address string) (unspents []Unspent, err error) {
+	listUnspents, err := bitcoinCli.ListUnspentOfAddress(0, 0, []string{address})
+	if err != nil {
+		err = fmt.Errorf("@bitcoinCli.ListUnspentOfAddress('%s'): %v", address, err)
+		return
+	}
+	unspents = make([]Unspent, 0)
+	for _, lUnspent := range listUnspents {
+		unspent := Unspent{}
+		unspent.TxID = lUnspent["txid"].(string)
+		unspent.Vout = (int)(lUnspent["vout"].(float64))
+		unspent.Address, _ = lUnspent["address"].(string)
+		unspent.Amount, err = AmountFromBTC(lUnspent["amount"].(float64))
+		if err != nil {
+			err = fmt.Errorf("@AmountFromBTC(%v): %v", lUnspent["amount"], err)
+			return
 		}
+		unspent.Confirmations = (int)(lUnspent["confirmations"].(float64))
+		unspent.Expected = false
+		unspents = append(unspents, unspent)
 	}
-	vBytes := 0.0
-	switch tAddressType {
-	case "P2WPKH":
-		//	P2WPKH
-		// 	Overhead	10.5 	vbytes
-		//  Inputs		68	vbytes x countTxIns
-		//  Outputs		31	vbytes x countTxOuts
-		vBytes = (10.5 + float64(countTxIns*68+countTxOuts*31))
-	default:
-		//	P2PKH (Legacy)
-		// 	Overhead	10 	vbytes
-		//  Inputs		148	vbytes x countTxIns
-		//  Outputs		34	vbytes x countTxOuts
-		vBytes = (10.0 + float64(countTxIns*148+countTxOuts*34))
-	}
-	fee = math.Ceil(vBytes*feePerVByte) / 100000000.0
-
+	sort.Slice(unspents, func(i, j int) bool {
+		return unspents[i].Amount > unspents[j].Amount
+	})
 	return
 }
 
 func (opReturn *OpReturn) selectUnspentsForSend() (err error) {
+	feePerVByte := 0.0
+	feeSource := ""
+	if opReturn.Fee <= 0 {
+		feePerVByte, feeSource, err = getFeePerVByte3(opReturn.FeeEstimator, opReturn.LimitFeeSatsPerVByteMin, opReturn.LimitFeeSatsPerVByteMax, opReturn.SpeedLevelFee, opReturn.ConfirmationTarget)
+		if err != nil {
+			err = fmt.Errorf("@getFeePerVByte3(): %v", err)
+			return
+		}
+	}
+	err = opReturn.SelectUnspents(feePerVByte)
+	opReturn.SelectionReport.FeeSource = feeSource
+	return
+}
+
+// SelectUnspents selects opReturn.Unspents without any remote call. feePerVByte is ignored when opReturn.Fee is set.
+func (opReturn *OpReturn) SelectUnspents(feePerVByte float64) (err error) {
 	sort.Slice(opReturn.Unspents, func(i, j int) bool {
 		return opReturn.Unspents[i].Amount > opReturn.Unspents[j].Amount
 	})
 
-	payValueExtra := 0.0
-	countExtra := 0
-	for _, feeVal := range opReturn.PayInfos {
+	payValueExtra := Amount(0)
+	payAddresses := make([]string, 0)
+	for address, feeVal := range opReturn.PayInfos {
 		payValueExtra += feeVal
-		countExtra += 1
+		payAddresses = append(payAddresses, address)
 	}
 
-	if opReturn.Fee > 0.00000001 {
-		sumAmountTemp := 0.0
-		countInUnspents := 0
-		for i, unspent := range opReturn.Unspents {
-			if unspent.Confirmations < opReturn.Confirmations {
-				continue
-			}
-			countInUnspents += 1
-			opReturn.Unspents[i].Expected = true
-			sumAmountTemp += unspent.Amount
-			if sumAmountTemp >= opReturn.Fee+payValueExtra {
-				break
-			}
-		}
-		opReturn.AmountBalanceUsedUnspends = math.Round((sumAmountTemp-opReturn.Fee-payValueExtra)*100000000.0) / 100000000.0
-		return
+	messageHex := opReturn.MessageHex
+	if messageHex == "" {
+		messageHex = ConvertTextToHex(opReturn.Message)
 	}
 
-	opReturn.Fee = -1.0
-	sumAmountTemp := 0.0
-	countInUnspents := 0
-	feePerVByte := getFeePerVByte3(opReturn.LimitFeeSatsPerVByteMin, opReturn.LimitFeeSatsPerVByteMax, opReturn.SpeedLevelFee)
-	for i, unspent := range opReturn.Unspents {
-		if unspent.Confirmations < opReturn.Confirmations {
-			continue
-		}
-		countInUnspents += 1
-		opReturn.Unspents[i].Expected = true
-
-		sumAmountTemp += unspent.Amount
-
-		// case 1.
-		// when Balance is 0, so did not need balance_tx
-		tCountTxOuts := 1 + countExtra //  1(opreturn_data_tx) + extra_tx
-		tFee := calFee(countInUnspents, tCountTxOuts, feePerVByte, opReturn.Address)
-		if sumAmountTemp == tFee+payValueExtra {
-			opReturn.Fee = tFee
-			break
-		}
+	params := SelectionParams{
+		Target:              payValueExtra + opReturn.chainReserve,
+		PayAddresses:        payAddresses,
+		OpReturnSizes:       []int{len(messageHex) / 2}, // opreturn_data_tx
+		LongTermFeePerVByte: opReturn.LongTermFeePerVByte,
+		Address:             opReturn.Address,
+		Confirmations:       opReturn.Confirmations,
+	}
+	params.FeePerVByte = feePerVByte
+	if opReturn.Fee > 0 {
+		params.FixedFee = opReturn.Fee
+	}
 
-		// case 2.
-		tCountTxOuts = 1 + 1 + countExtra //  1(opreturn_data_tx) + 1(balance_tx) + extra_tx
-		tFee = calFee(countInUnspents, tCountTxOuts, feePerVByte, opReturn.Address)
-		if sumAmountTemp >= tFee+payValueExtra {
-			opReturn.Fee = tFee
-			break
-		}
+	coinSelector := opReturn.CoinSelector
+	if coinSelector == nil {
+		coinSelector = LargestFirstSelector{}
 	}
-	if opReturn.Fee <= 0.0 {
-		err = fmt.Errorf("opReturn.Fee <= 0.0: not sufficient: sumUnspentAmount[%f] < fee[%f] + payValueExtra[%f]", sumAmountTemp, opReturn.Fee, payValueExtra)
+	selection, err := coinSelector.Select(opReturn.Unspents, params)
+	if err != nil {
+		err = fmt.Errorf("@coinSelector.Select(): %v", err)
 		return
 	}
+	if selection.Selector == "" {
+		selection.Selector = selectorName(coinSelector)
+	}
+	for i := range opReturn.Unspents {
+		opReturn.Unspents[i].Expected = false
+	}
+	for _, i := range selection.Indexes {
+		opReturn.Unspents[i].Expected = true
+	}
+	opReturn.Fee = selection.Fee
 
-	if opReturn.LimitFeeSats > 1.0 && opReturn.Fee > (opReturn.LimitFeeSats/100000000.0) {
-		opReturn.Fee = opReturn.LimitFeeSats / 100000000.0
+	if params.FixedFee <= 0 && opReturn.LimitFeeSats > 0 && opReturn.Fee > opReturn.LimitFeeSats {
+		opReturn.Fee = opReturn.LimitFeeSats
+	}
+	if opReturn.Fee <= 0 {
+		err = fmt.Errorf("opReturn.Fee[%s] <= 0: feePerVByte[%f]", opReturn.Fee, feePerVByte)
+		return
 	}
-	opReturn.AmountBalanceUsedUnspends = math.Round((sumAmountTemp-opReturn.Fee-payValueExtra)*100000000.0) / 100000000.0
+	opReturn.AmountBalanceUsedUnspends = selection.Sum - opReturn.Fee - payValueExtra
+
+	selection.Fee = opReturn.Fee
+	selection.Change = opReturn.AmountBalanceUsedUnspends
+	opReturn.SelectionReport = params.report(opReturn.Unspents, selection)
 
 	return
 }
 
-func (opReturn *OpReturn) Run() (err error) {
-	bitcoinCli := goBitcoinCli.BitcoinRpc{
+func (opReturn *OpReturn) bitcoinCli() goBitcoinCli.BitcoinRpc {
+	return goBitcoinCli.BitcoinRpc{
 		RpcUser:    opReturn.RpcUser,
 		RpcPW:      opReturn.RpcPW,
 		RpcConnect: opReturn.RpcConnect,
 		RpcPort:    opReturn.RpcPort,
 		RpcPath:    opReturn.RpcPath,
 	}
+}
 
-	if opReturn.PayInfos == nil {
-		opReturn.PayInfos = make(map[string]float64)
+// createRawTransaction creates opReturn.RawTx of the expected unspents, adding balance-pay-info to opReturn.PayInfos
+func (opReturn *OpReturn) createRawTransaction() (err error) {
+	if opReturn.AmountBalanceUsedUnspends > 0 {
+		opReturn.PayInfos[opReturn.Address] = opReturn.AmountBalanceUsedUnspends // add balance-pay-info
+	}
+	opReturn.RawTx, err = createRawTx(opReturn.Unspents, opReturn.PayInfos, opReturn.MessageHex)
+	if err != nil {
+		err = fmt.Errorf("@createRawTx(opReturn.Unspents, opReturn.PayInfos, opReturn.MessageHex): %v", err)
+		return
+	}
+	return
+}
+
+func (opReturn *OpReturn) Run() (err error) {
+	if err = opReturn.Prepare(); err != nil {
+		err = fmt.Errorf("@opReturn.Prepare(): %v", err)
+		return
 	}
+	if err = opReturn.Broadcast(); err != nil {
+		err = fmt.Errorf("@opReturn.Broadcast(): %v", err)
+		return
+	}
+	return
+}
 
+// listUnspentsForSend lists opReturn.Unspents of opReturn.Address, and converts the message, whose size is needed for fee
+func (opReturn *OpReturn) listUnspentsForSend() (err error) {
 	// 1. ListUnspent
-	listUnspents, err := bitcoinCli.ListUnspentOfAddress(0, 0, []string{opReturn.Address})
+	opReturn.Unspents, err = listUnspents(opReturn.bitcoinCli(), opReturn.Address)
 	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.ListUnspentOfAddress('%s'): %v", opReturn.Address, err)
+		err = fmt.Errorf("@listUnspents('%s'): %v", opReturn.Address, err)
 		return
 	}
-	opReturn.Unspents = make([]Unspent, 0)
-	for _, lUnspent := range listUnspents {
-		unspent := Unspent{}
-		unspent.TxID = lUnspent["txid"].(string)
-		unspent.Vout = (int)(lUnspent["vout"].(float64))
-		unspent.Amount = lUnspent["amount"].(float64)
-		unspent.Confirmations = (int)(lUnspent["confirmations"].(float64))
-		unspent.Expected = false
-		opReturn.Unspents = append(opReturn.Unspents, unspent)
-	}
-	sort.Slice(opReturn.Unspents, func(i, j int) bool {
-		return opReturn.Unspents[i].Amount > opReturn.Unspents[j].Amount
-	})
 	opReturn.Confirmations = 3
 
 	// 2. 3. Deprecate
 
+	// 5. convertTextToHex
+	if opReturn.MessageHex == "" {
+		opReturn.MessageHex = ConvertTextToHex(opReturn.Message)
+	}
+	return
+}
+
+// build is Run before signing, shared by Prepare, Quote and ExportPsbt: lists unspents, selects them and creates opReturn.RawTx.
+// listing false: selects opReturn.Unspents as they are.
+func (opReturn *OpReturn) build(listing bool) (err error) {
+	if opReturn.PayInfos == nil {
+		opReturn.PayInfos = make(map[string]Amount)
+	}
+
+	if listing {
+		if err = opReturn.listUnspentsForSend(); err != nil {
+			err = fmt.Errorf("@opReturn.listUnspentsForSend(): %v", err)
+			return
+		}
+	} else if opReturn.MessageHex == "" {
+		opReturn.MessageHex = ConvertTextToHex(opReturn.Message)
+	}
+
 	// 4. selectUnspentsForSend
 	if err = opReturn.selectUnspentsForSend(); err != nil {
 		err = fmt.Errorf("@opReturn.selectUnspentsForSend(): %v", err)
 		return
 	}
 
-	// 5. convertTextToHex
-	if opReturn.MessageHex == "" {
-		opReturn.MessageHex = ConvertTextToHex(opReturn.Message)
-	}
-
 	// 6. CreateRawTransaction
-	createTxUnSpents := make([]map[string]interface{}, 0)
-	for _, unspent := range opReturn.Unspents {
-		if !unspent.Expected {
-			continue
-		}
-		tCreateTxUnSpent := make(map[string]interface{})
-		tCreateTxUnSpent["txid"] = unspent.TxID
-		tCreateTxUnSpent["vout"] = unspent.Vout
-		createTxUnSpents = append(createTxUnSpents, tCreateTxUnSpent)
+	if err = opReturn.createRawTransaction(); err != nil {
+		err = fmt.Errorf("@opReturn.createRawTransaction(): %v", err)
+		return
 	}
+	return
+}
 
-	if opReturn.AmountBalanceUsedUnspends > 0.00000000 {
-		opReturn.PayInfos[opReturn.Address] = opReturn.AmountBalanceUsedUnspends // add balance-pay-info
+// Prepare lists unspents, selects them, creates and signs opReturn.SignedRawTx of Run, without broadcasting.
+func (opReturn *OpReturn) Prepare() (err error) {
+	err = opReturn.prepare(true)
+	return
+}
+
+// prepare is Prepare, of opReturn.Unspents as they are when listing is false
+func (opReturn *OpReturn) prepare(listing bool) (err error) {
+	bitcoinCli := opReturn.bitcoinCli()
+
+	payInfos := make(map[string]Amount) // without balance-pay-info, for rebuilding
+	for address, amount := range opReturn.PayInfos {
+		payInfos[address] = amount
 	}
-	opReturn.RawTx, err = bitcoinCli.CreateRawTransaction(createTxUnSpents, opReturn.PayInfos, opReturn.MessageHex)
-	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.CreateRawTransaction(createTxUnSpents, opReturn.PayInfos, opReturn.MessageHex): %v", err)
+	hasFixedFee := opReturn.Fee > 0
+
+	// 1. - 6.
+	if err = opReturn.build(listing); err != nil {
+		err = fmt.Errorf("@opReturn.build(): %v", err)
 		return
 	}
 
-	// 7. DumpPrivateKey
-	if opReturn.PrivKey == "" {
-		opReturn.PrivKey, err = bitcoinCli.DumpPrivateKey(opReturn.Address)
+	for countReconciles := 0; ; countReconciles++ {
+		// 7. 8. Signer, dumpprivkey and signRawTx by default, or the wallet for descriptor wallets
+		opReturn.SignedRawTx, err = signerOrDefault(opReturn.Signer).SignRawTx(bitcoinCli, opReturn.RawTx, opReturn.Unspents, opReturn.Address)
 		if err != nil {
-			err = fmt.Errorf("@bitcoinCli.DumpPrivateKey('%s'): %v", opReturn.Address, err)
+			err = fmt.Errorf("@signer.SignRawTx(opReturn.RawTx): %v", err)
 			return
 		}
-	}
 
-	// 8. SignRawTransactionWithKey
-	opReturn.SignedRawTx, err = bitcoinCli.SignRawTransactionWithKey(opReturn.RawTx, opReturn.PrivKey)
-	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.SignRawTransactionWithKey(opReturn.RawTx, opReturn.PrivKey): %v", err)
-		return
+		// 8-1. reconcile fee with the real weight of the signed tx
+		signedTx, errD := DecodeTx(opReturn.SignedRawTx)
+		if errD != nil {
+			err = fmt.Errorf("@DecodeTx(opReturn.SignedRawTx): %v", errD)
+			return
+		}
+		weight, errW := signedTx.Weight()
+		if errW != nil {
+			err = fmt.Errorf("@signedTx.Weight(): %v", errW)
+			return
+		}
+		opReturn.VSize = vSizeOfWeight(weight)
+		opReturn.EffectiveFeePerVByte = float64(opReturn.Fee) / float64(opReturn.VSize)
+		if hasFixedFee || countReconciles >= maxFeeReconciles {
+			break
+		}
+		reconciledFee, rebuild := opReturn.reconciledFee()
+		if !rebuild {
+			break
+		}
+
+		opReturn.PayInfos = make(map[string]Amount)
+		for address, amount := range payInfos {
+			opReturn.PayInfos[address] = amount
+		}
+		opReturn.Fee = reconciledFee
+		feePerVByte, feeSource := opReturn.SelectionReport.FeePerVByte, opReturn.SelectionReport.FeeSource
+		if err = opReturn.SelectUnspents(feePerVByte); err != nil {
+			err = fmt.Errorf("@opReturn.SelectUnspents(%f): reconciled fee[%s]: %v", feePerVByte, reconciledFee, err)
+			return
+		}
+		// the report of the reconciled fee goes on with the requested fee rate and its source
+		opReturn.SelectionReport.FeePerVByte = feePerVByte
+		opReturn.SelectionReport.FeeSource = feeSource
+		if err = opReturn.createRawTransaction(); err != nil {
+			err = fmt.Errorf("@opReturn.createRawTransaction(): %v", err)
+			return
+		}
 	}
 
+	return
+}
+
+// Broadcast sends opReturn.SignedRawTx made by Prepare.
+func (opReturn *OpReturn) Broadcast() (err error) {
 	// 9. SendRawTransaction
-	opReturn.OpRetrunTxID, err = bitcoinCli.SendRawTransaction(opReturn.SignedRawTx)
+	opReturn.OpRetrunTxID, err = opReturn.bitcoinCli().SendRawTransaction(opReturn.SignedRawTx)
 	if err != nil {
 		err = fmt.Errorf("@bitcoinCli.SendRawTransaction(opReturn.SignedRawTx): %v", err)
 		return
 	}
+	if opReturn.OpRetrunTxID == "" {
+		err = fmt.Errorf("SendRawTransaction(opReturn.SignedRawTx): rejected, no txid")
+		return
+	}
+	return
+}
+
+// reconciledFee returns the fee for the target fee rate at the real vsize of the signed tx,
+// and whether the tx should be rebuilt for it.
+func (opReturn *OpReturn) reconciledFee() (fee Amount, rebuild bool) {
+	feePerVByte := opReturn.SelectionReport.FeePerVByte
+	if feePerVByte <= 0.0 || opReturn.VSize <= 0 {
+		return
+	}
+	fee = Amount(math.Ceil(feePerVByte * float64(opReturn.VSize)))
+	if opReturn.LimitFeeSats > 0 && fee > opReturn.LimitFeeSats {
+		fee = opReturn.LimitFeeSats
+	}
 
+	switch {
+	case opReturn.EffectiveFeePerVByte < feePerVByte:
+		rebuild = fee > opReturn.Fee
+	case opReturn.FeeReconcileMargin > 0.0 && opReturn.EffectiveFeePerVByte > feePerVByte*(1.0+opReturn.FeeReconcileMargin):
+		rebuild = fee < opReturn.Fee
+	}
 	return
 }
 
@@ -275,48 +397,56 @@ type Payment struct {
 	RpcPort                   string
 	RpcPath                   string
 	Address                   string
-	PrivKey                   string
-	PayInfos                  map[string]float64
+	Signer                    Signer               `json:"-"` // nil: AutoSigner, dumpprivkey falling back to the wallet
+	KeyOrigins                map[string]KeyOrigin // address: BIP32 origin of its key for ExportPsbt, nil: no derivations
+	PayInfos                  map[string]Amount    // -1: all of balance amount
 	Unspents                  []Unspent
 	Confirmations             int
-	SpeedLevelFee             string // Lv1.Min, Lv2.Eco, Lv3.(Eco+1H)/2 Lv4.1H Lv5.(1H+30m)/2 Lv6.30m Lv7.(30m+Fast)/2 Lv8.Fast
+	CoinSelector              CoinSelector // nil: LargestFirstSelector
+	FeeEstimator              FeeEstimator // nil: DefaultFeeEstimator, cached mempool.space without fallback
+	SpeedLevelFee             SpeedLevel   // Lv1.Min, Lv2.Eco, Lv3.(Eco+1H)/2 Lv4.1H Lv5.(1H+30m)/2 Lv6.30m Lv7.(30m+Fast)/2 Lv8.Fast, "": Lv6
+	ConfirmationTarget        int          // blocks 1 ~ 1008 instead of SpeedLevelFee, 0: SpeedLevelFee
 	LimitFeePerVByteMax       float64
 	LimitFeePerVByteMin       float64
-	Fee                       float64
-	AmountBalanceUsedUnspends float64
+	LongTermFeePerVByte       float64 // Sats 1, for waste of selection. 0: 10
+	Fee                       Amount
+	AmountBalanceUsedUnspends Amount
+	SweepAddress              string // of -1 in PayInfos, its output is reduced by BumpFee. "": no sweep
+	SelectionReport           SelectionReport
 	RawTx                     string
 	SignedRawTx               string
 	PaymentTxID               string
+	ReplacedTxIDs             []string // replaced by BumpFee, oldest first
 }
 
-func (payment *Payment) Run() (err error) {
-	bitcoinCli := goBitcoinCli.BitcoinRpc{
+func (payment *Payment) bitcoinCli() goBitcoinCli.BitcoinRpc {
+	return goBitcoinCli.BitcoinRpc{
 		RpcUser:    payment.RpcUser,
 		RpcPW:      payment.RpcPW,
 		RpcConnect: payment.RpcConnect,
 		RpcPort:    payment.RpcPort,
 		RpcPath:    payment.RpcPath,
 	}
+}
 
-	// 1. ListUnspent
-	listUnspents, err := bitcoinCli.ListUnspentOfAddress(0, 0, []string{payment.Address})
+// createRawTransaction creates payment.RawTx of the expected unspents to payment.PayInfos
+func (payment *Payment) createRawTransaction() (err error) {
+	payment.RawTx, err = createRawTx(payment.Unspents, payment.PayInfos, "")
 	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.ListUnspentOfAddress('%s'): %v", payment.Address, err)
+		err = fmt.Errorf("@createRawTx(payment.Unspents, payment.PayInfos, ''): %v", err)
 		return
 	}
-	payment.Unspents = make([]Unspent, 0)
-	for _, lUnspent := range listUnspents {
-		unspent := Unspent{}
-		unspent.TxID = lUnspent["txid"].(string)
-		unspent.Vout = (int)(lUnspent["vout"].(float64))
-		unspent.Amount = lUnspent["amount"].(float64)
-		unspent.Confirmations = (int)(lUnspent["confirmations"].(float64))
-		unspent.Expected = false
-		payment.Unspents = append(payment.Unspents, unspent)
+	return
+}
+
+// build is Run before signing, shared by Run, Quote and ExportPsbt: lists unspents, selects them and creates payment.RawTx.
+func (payment *Payment) build() (err error) {
+	// 1. ListUnspent
+	payment.Unspents, err = listUnspents(payment.bitcoinCli(), payment.Address)
+	if err != nil {
+		err = fmt.Errorf("@listUnspents('%s'): %v", payment.Address, err)
+		return
 	}
-	sort.Slice(payment.Unspents, func(i, j int) bool {
-		return payment.Unspents[i].Amount > payment.Unspents[j].Amount
-	})
 	payment.Confirmations = 3
 
 	// 4. selectUnspentsForSend
@@ -326,36 +456,26 @@ func (payment *Payment) Run() (err error) {
 	}
 
 	// 6. CreateRawTransaction
-	createTxUnSpents := make([]map[string]interface{}, 0)
-	for _, unspent := range payment.Unspents {
-		if !unspent.Expected {
-			continue
-		}
-		tCreateTxUnSpent := make(map[string]interface{})
-		tCreateTxUnSpent["txid"] = unspent.TxID
-		tCreateTxUnSpent["vout"] = unspent.Vout
-		createTxUnSpents = append(createTxUnSpents, tCreateTxUnSpent)
-	}
-
-	payment.RawTx, err = bitcoinCli.CreateRawTransaction(createTxUnSpents, payment.PayInfos, "")
-	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.CreateRawTransaction(createTxUnSpents, payment.PayInfos, ''): %v", err)
+	if err = payment.createRawTransaction(); err != nil {
+		err = fmt.Errorf("@payment.createRawTransaction(): %v", err)
 		return
 	}
+	return
+}
 
-	// 7. DumpPrivateKey
-	if payment.PrivKey == "" {
-		payment.PrivKey, err = bitcoinCli.DumpPrivateKey(payment.Address)
-		if err != nil {
-			err = fmt.Errorf("@bitcoinCli.DumpPrivateKey('%s'): %v", payment.Address, err)
-			return
-		}
+func (payment *Payment) Run() (err error) {
+	bitcoinCli := payment.bitcoinCli()
+
+	// 1. - 6.
+	if err = payment.build(); err != nil {
+		err = fmt.Errorf("@payment.build(): %v", err)
+		return
 	}
 
-	// 8. SignRawTransactionWithKey
-	payment.SignedRawTx, err = bitcoinCli.SignRawTransactionWithKey(payment.RawTx, payment.PrivKey)
+	// 7. 8. Signer, dumpprivkey and signRawTx by default, or the wallet for descriptor wallets
+	payment.SignedRawTx, err = signerOrDefault(payment.Signer).SignRawTx(bitcoinCli, payment.RawTx, payment.Unspents, payment.Address)
 	if err != nil {
-		err = fmt.Errorf("@bitcoinCli.SignRawTransactionWithKey(payment.RawTx, payment.PrivKey): %v", err)
+		err = fmt.Errorf("@signer.SignRawTx(payment.RawTx): %v", err)
 		return
 	}
 
@@ -365,11 +485,27 @@ func (payment *Payment) Run() (err error) {
 		err = fmt.Errorf("@bitcoinCli.SendRawTransaction(payment.SignedRawTx): %v", err)
 		return
 	}
+	if payment.PaymentTxID == "" {
+		err = fmt.Errorf("SendRawTransaction(payment.SignedRawTx): rejected, no txid")
+		return
+	}
 
 	return
 }
 
 func (payment *Payment) selectUnspentsForSend() (err error) {
+	feePerVByte, feeSource, err := getFeePerVByte3(payment.FeeEstimator, payment.LimitFeePerVByteMin, payment.LimitFeePerVByteMax, payment.SpeedLevelFee, payment.ConfirmationTarget)
+	if err != nil {
+		err = fmt.Errorf("@getFeePerVByte3(): %v", err)
+		return
+	}
+	err = payment.SelectUnspents(feePerVByte)
+	payment.SelectionReport.FeeSource = feeSource
+	return
+}
+
+// SelectUnspents selects payment.Unspents without any remote call.
+func (payment *Payment) SelectUnspents(feePerVByte float64) (err error) {
 
 	sort.Slice(payment.Unspents, func(i, j int) bool {
 		return payment.Unspents[i].Amount > payment.Unspents[j].Amount
@@ -380,7 +516,7 @@ func (payment *Payment) selectUnspentsForSend() (err error) {
 	totalAmountCaseCount := 0
 	totalAmountCaseAddress := ""
 	for tAddress, tAmount := range payment.PayInfos {
-		if tAmount < 0.0 { // means case[all of balance amount].
+		if tAmount < 0 { // means case[all of balance amount].
 			totalAmountCaseCount += 1
 			totalAmountCaseAddress = tAddress
 		}
@@ -396,55 +532,90 @@ func (payment *Payment) selectUnspentsForSend() (err error) {
 		return
 	}
 
-	countPayment := len(payment.PayInfos)
-	sumPaymentAmount := 0.0
-	for _, tAmount := range payment.PayInfos {
-		if tAmount >= 0.0 {
+	payAddresses := make([]string, 0)
+	sumPaymentAmount := Amount(0)
+	for tAddress, tAmount := range payment.PayInfos {
+		payAddresses = append(payAddresses, tAddress)
+		if tAmount >= 0 {
 			sumPaymentAmount += tAmount
 		}
 	}
 
-	sumSelectedUnspentsAmount := 0.0
-	countSelectedUnspents := 0
-	validSelectedUnspents := false
-	feePerVByte := getFeePerVByte3(payment.LimitFeePerVByteMin, payment.LimitFeePerVByteMax, payment.SpeedLevelFee)
-	for i, unspent := range payment.Unspents {
+	payment.SweepAddress = ""
+	params := SelectionParams{
+		Target:              sumPaymentAmount,
+		PayAddresses:        payAddresses,
+		FeePerVByte:         feePerVByte,
+		LongTermFeePerVByte: payment.LongTermFeePerVByte,
+		Address:             payment.Address,
+		Confirmations:       payment.Confirmations,
+	}
 
-		if unspent.Confirmations < payment.Confirmations {
-			payment.Unspents[i].Expected = false
-			continue
+	if hasTotalAmountCase { // for all of balance-amount
+		sumSelectedUnspentsAmount := Amount(0)
+		selection := CoinSelection{Selector: "SweepAll", Indexes: make([]int, 0)}
+		for i, unspent := range payment.Unspents {
+			if unspent.Confirmations < payment.Confirmations {
+				payment.Unspents[i].Expected = false
+				continue
+			}
+			payment.Unspents[i].Expected = true
+			sumSelectedUnspentsAmount += unspent.Amount
+			selection.Indexes = append(selection.Indexes, i)
+		}
+		payment.Fee = params.fee(payment.Unspents, selection.Indexes, false)
+		if payment.Fee <= 0 {
+			err = fmt.Errorf("payment.Fee[%s] <= 0: feePerVByte[%f]", payment.Fee, feePerVByte)
+			return
+		}
+		if sumSelectedUnspentsAmount < payment.Fee+sumPaymentAmount {
+			err = fmt.Errorf("validSelectedUnspents is false: not sufficient: sumSelectedUnspentsAmount[%s] < fee[%s]+sumPaymentAmount[%s]", sumSelectedUnspentsAmount, payment.Fee, sumPaymentAmount)
+			return
 		}
 
-		payment.Unspents[i].Expected = true
-		sumSelectedUnspentsAmount += unspent.Amount
-		countSelectedUnspents += 1
-		payment.Fee = calFee(countSelectedUnspents, countPayment, feePerVByte, payment.Address)
-		if sumSelectedUnspentsAmount >= payment.Fee+sumPaymentAmount {
-			validSelectedUnspents = true
-			if !hasTotalAmountCase { // for all of balance-amount
-				break
-			}
+		payment.AmountBalanceUsedUnspends = 0
+		tTotalAmount := sumSelectedUnspentsAmount - payment.Fee - sumPaymentAmount
+		if tTotalAmount > 0 {
+			payment.PayInfos[totalAmountCaseAddress] = tTotalAmount // Update minus-amount-value to final-amount-value[tTotalAmount]
+			payment.SweepAddress = totalAmountCaseAddress
 		}
+
+		selection.Sum = sumSelectedUnspentsAmount
+		selection.Fee = payment.Fee
+		selection.Waste = params.waste(payment.Unspents, selection.Indexes, 0, false)
+		payment.SelectionReport = params.report(payment.Unspents, selection)
+		return
 	}
 
-	if !validSelectedUnspents {
-		err = fmt.Errorf("validSelectedUnspents is false: not sufficient: sumSelectedUnspentsAmount[%f] < fee[%f]+sumPaymentAmount[%f]", sumSelectedUnspentsAmount, payment.Fee, sumPaymentAmount)
+	coinSelector := payment.CoinSelector
+	if coinSelector == nil {
+		coinSelector = LargestFirstSelector{}
+	}
+	selection, err := coinSelector.Select(payment.Unspents, params)
+	if err != nil {
+		err = fmt.Errorf("@coinSelector.Select(): %v", err)
+		return
+	}
+	if selection.Selector == "" {
+		selection.Selector = selectorName(coinSelector)
+	}
+	for i := range payment.Unspents {
+		payment.Unspents[i].Expected = false
+	}
+	for _, i := range selection.Indexes {
+		payment.Unspents[i].Expected = true
+	}
+	payment.Fee = selection.Fee
+	if payment.Fee <= 0 {
+		err = fmt.Errorf("payment.Fee[%s] <= 0: feePerVByte[%f]", payment.Fee, feePerVByte)
 		return
 	}
 
-	switch hasTotalAmountCase {
-	case true:
-		payment.AmountBalanceUsedUnspends = 0.0
-		tTotalAmount := math.Round((sumSelectedUnspentsAmount-payment.Fee-sumPaymentAmount)*100000000) / 100000000
-		if tTotalAmount > 0.0 {
-			payment.PayInfos[totalAmountCaseAddress] = tTotalAmount // Update minus-amount-value to final-amount-value[tTotalAmount]
-		}
-	case false:
-		payment.AmountBalanceUsedUnspends = math.Round((sumSelectedUnspentsAmount-payment.Fee-sumPaymentAmount)*100000000) / 100000000
-		if payment.AmountBalanceUsedUnspends > 0.0 {
-			payment.PayInfos[payment.Address] = payment.AmountBalanceUsedUnspends // Add payment.PayInfos{payment.Address:AmountBalanceUsedUnspends}
-		}
+	payment.AmountBalanceUsedUnspends = selection.Change
+	if payment.AmountBalanceUsedUnspends > 0 {
+		payment.PayInfos[payment.Address] = payment.AmountBalanceUsedUnspends // Add payment.PayInfos{payment.Address:AmountBalanceUsedUnspends}
 	}
+	payment.SelectionReport = params.report(payment.Unspents, selection)
 
 	return
 }
@@ -626,49 +797,54 @@ func getFeePerVByte(limitFeePerVByte float64) (fee float64) {
 	return
 }
 
-func getFeePerVByte3(limitFeePerVByteMin float64, limitFeePerVByteMax float64, speedType string) (fee float64) {
-
-	fee = 30.0 // default
+func feeEstimatorOrDefault(feeEstimator FeeEstimator) FeeEstimator {
+	if feeEstimator == nil {
+		return DefaultFeeEstimator
+	}
+	return feeEstimator
+}
 
-	remoteFees := RemoteFees{}
-	err := remoteFees.remoteFeePerVByte2()
-	if err != nil {
+// getFeePerVByte3 asks feeEstimator(nil: DefaultFeeEstimator) for speedLevel, or for confirmation in confTarget(>0) blocks,
+// and reports which source answered.
+func getFeePerVByte3(feeEstimator FeeEstimator, limitFeePerVByteMin float64, limitFeePerVByteMax float64, speedLevel SpeedLevel, confTarget int) (fee float64, source string, err error) {
+	if err = speedLevel.Validate(); err != nil {
 		return
 	}
+	if confTarget != 0 {
+		if speedLevel != "" {
+			err = fmt.Errorf("both of speed level['%s'] and confirmation target[%d] are set", string(speedLevel), confTarget)
+			return
+		}
+		if err = validateConfirmationTarget(confTarget); err != nil {
+			return
+		}
+	}
+	feeEstimator = feeEstimatorOrDefault(feeEstimator)
 
-	if (remoteFees.FastestFee == remoteFees.MinimumFee) && remoteFees.MinimumFee > 1.0 {
+	if targetFeeEstimator, ok := feeEstimator.(TargetFeeEstimator); ok && confTarget > 0 {
+		fee, err = targetFeeEstimator.EstimateFeeForTarget(confTarget)
+		if err != nil {
+			err = fmt.Errorf("@targetFeeEstimator.EstimateFeeForTarget(%d): %v", confTarget, err)
+			return
+		}
+		fee = clampFeePerVByte(fee, limitFeePerVByteMin, limitFeePerVByteMax)
+		source = fmt.Sprintf("%T", feeEstimator)
+		source = fmt.Sprintf("%s(%d blocks)", source[strings.LastIndex(source, ".")+1:], confTarget)
 		return
 	}
 
-	fee = remoteFees.HalfHourFee
-	switch speedType {
-	case "Level1":
-		fee = remoteFees.MinimumFee
-	case "Level2":
-		fee = remoteFees.EconomyFee
-	case "Level3":
-		fee = (remoteFees.EconomyFee + remoteFees.HourFee) / 2
-	case "Level4":
-		fee = remoteFees.HourFee
-	case "Level5":
-		fee = (remoteFees.HalfHourFee + remoteFees.HourFee) / 2
-	case "Level6":
-		fee = remoteFees.HalfHourFee
-	case "Level7":
-		fee = (remoteFees.FastestFee + remoteFees.HalfHourFee) / 2
-	case "Level8":
-		fee = remoteFees.FastestFee
+	remoteFees, err := feeEstimator.EstimateFees()
+	if err != nil {
+		err = fmt.Errorf("@feeEstimator.EstimateFees(): %v", err)
+		return
 	}
+	source = remoteFees.Source
 
-	if fee < limitFeePerVByteMin {
-		fee = limitFeePerVByteMin
-		return // min
-	}
-	if fee > limitFeePerVByteMax {
-		fee = limitFeePerVByteMax
-		return // max
+	if confTarget > 0 {
+		fee, err = remoteFees.FeePerVByteForTarget(limitFeePerVByteMin, limitFeePerVByteMax, confTarget)
+		return
 	}
-
+	fee, err = remoteFees.FeePerVByte(limitFeePerVByteMin, limitFeePerVByteMax, speedLevel)
 	return
 }
 
@@ -706,11 +882,42 @@ func getFeePerVByte2(limitFeePerVByte float64) (fee float64) {
 }
 
 type RemoteFees struct {
-	FastestFee  float64 `json:"fastestFee"`
-	HalfHourFee float64 `json:"halfHourFee"`
-	HourFee     float64 `json:"hourFee"`
-	EconomyFee  float64 `json:"economyFee"`
-	MinimumFee  float64 `json:"minimumFee"`
+	FastestFee  float64  `json:"fastestFee"`
+	HalfHourFee float64  `json:"halfHourFee"`
+	HourFee     float64  `json:"hourFee"`
+	EconomyFee  float64  `json:"economyFee"`
+	MinimumFee  float64  `json:"minimumFee"`
+	Source      string   `json:"-"` // which FeeEstimator answered
+	Sources     []string `json:"-"` // sources which contributed to a consensus
+	Rejected    []string `json:"-"` // sources rejected as outliers by a consensus
+}
+
+func (remoteFees RemoteFees) FeePerVByte(limitFeePerVByteMin float64, limitFeePerVByteMax float64, speedLevel SpeedLevel) (fee float64, err error) {
+	if err = speedLevel.Validate(); err != nil {
+		return
+	}
+
+	switch speedLevel {
+	case SpeedLevel1:
+		fee = remoteFees.MinimumFee
+	case SpeedLevel2:
+		fee = remoteFees.EconomyFee
+	case SpeedLevel3:
+		fee = (remoteFees.EconomyFee + remoteFees.HourFee) / 2
+	case SpeedLevel4:
+		fee = remoteFees.HourFee
+	case SpeedLevel5:
+		fee = (remoteFees.HalfHourFee + remoteFees.HourFee) / 2
+	case SpeedLevel6, "":
+		fee = remoteFees.HalfHourFee
+	case SpeedLevel7:
+		fee = (remoteFees.FastestFee + remoteFees.HalfHourFee) / 2
+	case SpeedLevel8:
+		fee = remoteFees.FastestFee
+	}
+
+	fee = clampFeePerVByte(fee, limitFeePerVByteMin, limitFeePerVByteMax)
+	return
 }
 
 func (remoteFees *RemoteFees) remoteFeePerVByte2() (err error) {
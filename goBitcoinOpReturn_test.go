@@ -8,7 +8,7 @@ import (
 )
 
 func TestCalFee(t *testing.T) {
-	calFee(1, 2, 40, "bc1q")
+	calFee([]string{"bc1q"}, []string{"bc1q", "bc1q"}, nil, 40)
 }
 
 func TestPayment(t *testing.T) {
@@ -24,7 +24,7 @@ func TestPayment(t *testing.T) {
 	payment.LimitFeePerVByteMin = 10
 	payment.SpeedLevelFee = "Level4"
 
-	payment.PayInfos = make(map[string]float64)
+	payment.PayInfos = make(map[string]Amount)
 	// 17M94TyjrY832rDgyY4cn92qSf697LtWgS
 	payment.PayInfos["bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c"] = -1
 	// payment.PayInfos["tb1q8yu29c59hlmem3hed28f49k4f3kwwkrv4smgkh"] = 0.0001
@@ -40,6 +40,24 @@ func TestPayment(t *testing.T) {
 	fmt.Printf("\n\n\n%+v\n\n", string(jsonDump))
 }
 
+func TestPaymentRunNoTxID(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+	bitcoind.results["sendrawtransaction"] = `""`
+
+	payment := Payment{
+		Address:             "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Signer:              testWIFSigner(),
+		PayInfos:            map[string]Amount{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT": 1000},
+		FeeEstimator:        StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeePerVByteMax: 100,
+	}
+	payment.RpcConnect, payment.RpcPort = bitcoind.connectPort()
+	if err := payment.Run(); err == nil || !strings.Contains(err.Error(), "no txid") {
+		t.Errorf("expected an error of no txid: %v", err)
+	}
+}
+
 func TestOpRetrun(t *testing.T) {
 
 	opReturn := OpReturn{}
@@ -55,9 +73,9 @@ func TestOpRetrun(t *testing.T) {
 	opReturn.LimitFeeSats = 8000
 	opReturn.SpeedLevelFee = "Level4"
 
-	opReturn.PayInfos = make(map[string]float64)
+	opReturn.PayInfos = make(map[string]Amount)
 
-	// opReturn.PayInfos["1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"] = 0.00001000
+	// opReturn.PayInfos["1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"] = 1000
 	// payment.PayInfos["tb1q8yu29c59hlmem3hed28f49k4f3kwwkrv4smgkh"] = 0.0001
 	// payment.PayInfos["tb1qtc7nhjtqkkghvzc62gxf2crjf6fd9jde007juu"] = -1
 
@@ -0,0 +1,123 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+const bip32Hardened = 0x80000000
+
+// KeyOrigin is the BIP32 origin of the key of an address, written into psbts for external signers to find the key.
+type KeyOrigin struct {
+	Fingerprint string // hex of 4 bytes, of the master key
+	Path        string // e.g. m/84'/0'/0'/1/3, h as well as '
+	PubKey      string // hex of the public key, x-only internal key for P2TR
+}
+
+// parseBip32Path parses path into indexes, hardened ones with bip32Hardened
+func parseBip32Path(path string) (indexes []uint32, err error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "m"), "/")
+	indexes = make([]uint32, 0)
+	if path == "" {
+		return
+	}
+	for _, step := range strings.Split(path, "/") {
+		hardened := strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") || strings.HasSuffix(step, "H")
+		if hardened {
+			step = step[:len(step)-1]
+		}
+		index, errP := strconv.ParseUint(step, 10, 31)
+		if errP != nil {
+			err = fmt.Errorf("incorrect step '%s' of path: %v", step, errP)
+			return
+		}
+		if hardened {
+			index += bip32Hardened
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return
+}
+
+func formatBip32Path(indexes []uint32) string {
+	path := "m"
+	for _, index := range indexes {
+		if index >= bip32Hardened {
+			path += fmt.Sprintf("/%d'", index-bip32Hardened)
+		} else {
+			path += fmt.Sprintf("/%d", index)
+		}
+	}
+	return path
+}
+
+// derivation is the value of a BIP32 derivation record of psbt: fingerprint and indexes of path, little endian
+func (origin KeyOrigin) derivation() (value []byte, err error) {
+	fingerprint, err := hex.DecodeString(origin.Fingerprint)
+	if err != nil || len(fingerprint) != 4 {
+		err = fmt.Errorf("incorrect fingerprint '%s'", origin.Fingerprint)
+		return
+	}
+	indexes, err := parseBip32Path(origin.Path)
+	if err != nil {
+		err = fmt.Errorf("@parseBip32Path('%s'): %v", origin.Path, err)
+		return
+	}
+	buffer := bytes.NewBuffer(fingerprint)
+	for _, index := range indexes {
+		binary.Write(buffer, binary.LittleEndian, index)
+	}
+	value = buffer.Bytes()
+	return
+}
+
+// decodeKeyOrigin decodes the derivation of a BIP32 derivation record of pubKey
+func decodeKeyOrigin(pubKey []byte, derivation []byte) (origin KeyOrigin, err error) {
+	if len(derivation) < 4 || len(derivation)%4 != 0 {
+		err = fmt.Errorf("incorrect length %d of derivation", len(derivation))
+		return
+	}
+	indexes := make([]uint32, 0, len(derivation)/4-1)
+	for i := 4; i < len(derivation); i += 4 {
+		indexes = append(indexes, binary.LittleEndian.Uint32(derivation[i:i+4]))
+	}
+	origin.Fingerprint = hex.EncodeToString(derivation[:4])
+	origin.Path = formatBip32Path(indexes)
+	origin.PubKey = hex.EncodeToString(pubKey)
+	return
+}
+
+// keyOf checks origin.PubKey is the key of script, P2PKH, P2WPKH, P2SH-P2WPKH or P2TR(x-only internal key)
+func (origin KeyOrigin) keyOf(script []byte) (pubKey []byte, isTaproot bool, err error) {
+	pubKey, err = hex.DecodeString(origin.PubKey)
+	if err != nil {
+		err = fmt.Errorf("@hex.DecodeString('%s'): %v", origin.PubKey, err)
+		return
+	}
+	if isP2TRScript(script) {
+		isTaproot = true
+		outputKey, errT := taprootOutputKey(pubKey)
+		if errT != nil {
+			err = fmt.Errorf("@taprootOutputKey('%s'): %v", origin.PubKey, errT)
+			return
+		}
+		if !matchesP2TR(script, outputKey) {
+			err = fmt.Errorf("key '%s' is not the internal key of script %x", origin.PubKey, script)
+		}
+		return
+	}
+	if _, err = btcec.ParsePubKey(pubKey); err != nil {
+		err = fmt.Errorf("@btcec.ParsePubKey('%s'): %v", origin.PubKey, err)
+		return
+	}
+	if !isKeyOfScript(pubKey, script) {
+		err = fmt.Errorf("key '%s' is not of script %x", origin.PubKey, script)
+	}
+	return
+}
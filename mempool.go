@@ -0,0 +1,310 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+type MempoolEntry struct {
+	VSize           int  `json:"vsize"`
+	Weight          int  `json:"weight"`
+	AncestorCount   int  `json:"ancestorcount"`
+	AncestorSize    int  `json:"ancestorsize"` // vbytes with the tx
+	DescendantCount int  `json:"descendantcount"`
+	DescendantSize  int  `json:"descendantsize"` // vbytes with the tx
+	Replaceable     bool `json:"bip125-replaceable"`
+	Fees            struct {
+		Base       float64 `json:"base"`       // BTC
+		Ancestor   float64 `json:"ancestor"`   // BTC, with the tx
+		Descendant float64 `json:"descendant"` // BTC, with the tx
+	} `json:"fees"`
+	Depends []string `json:"depends"` // unconfirmed parents
+}
+
+// getMempoolEntry fails for a tx not in mempool of the node: confirmed, evicted or unknown.
+func getMempoolEntry(bitcoinCli goBitcoinCli.BitcoinRpc, txID string) (entry MempoolEntry, err error) {
+	err = callRpc(bitcoinCli, "getmempoolentry", []interface{}{txID}, &entry)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('getmempoolentry', '%s'): %v", txID, err)
+		return
+	}
+	return
+}
+
+type scriptPubKeyInfo struct {
+	Hex       string   `json:"hex"`
+	Type      string   `json:"type"`
+	Address   string   `json:"address"`
+	Addresses []string `json:"addresses"` // before bitcoind v22
+}
+
+func (scriptPubKey scriptPubKeyInfo) address() string {
+	if scriptPubKey.Address == "" && len(scriptPubKey.Addresses) > 0 {
+		return scriptPubKey.Addresses[0]
+	}
+	return scriptPubKey.Address
+}
+
+type rawTxVerbose struct {
+	TxID          string `json:"txid"`
+	Hex           string `json:"hex"`
+	VSize         int    `json:"vsize"`
+	Confirmations int    `json:"confirmations"`
+	Vin           []struct {
+		TxID     string `json:"txid"`
+		Vout     int    `json:"vout"`
+		Sequence uint32 `json:"sequence"`
+	} `json:"vin"`
+	Vout []struct {
+		Value        float64          `json:"value"` // BTC
+		N            int              `json:"n"`
+		ScriptPubKey scriptPubKeyInfo `json:"scriptPubKey"`
+	} `json:"vout"`
+}
+
+func getRawTxVerbose(bitcoinCli goBitcoinCli.BitcoinRpc, txID string) (rawTx rawTxVerbose, err error) {
+	err = callRpc(bitcoinCli, "getrawtransaction", []interface{}{txID, true}, &rawTx)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('getrawtransaction', '%s', true): %v", txID, err)
+		return
+	}
+	return
+}
+
+// prevOut finds the address and amount of an input, from chainstate without txindex,
+// or from mempool for an unconfirmed parent.
+func prevOut(bitcoinCli goBitcoinCli.BitcoinRpc, txID string, vout int) (unspent Unspent, err error) {
+	type resultTxOut struct {
+		Value         float64          `json:"value"`
+		Confirmations int              `json:"confirmations"`
+		ScriptPubKey  scriptPubKeyInfo `json:"scriptPubKey"`
+	}
+	var txOut *resultTxOut
+	err = callRpc(bitcoinCli, "gettxout", []interface{}{txID, vout, false}, &txOut)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('gettxout', '%s', %d): %v", txID, vout, err)
+		return
+	}
+
+	unspent = Unspent{TxID: txID, Vout: vout, Expected: true}
+	value := 0.0
+	if txOut != nil {
+		value = txOut.Value
+		unspent.Address = txOut.ScriptPubKey.address()
+		unspent.Confirmations = txOut.Confirmations
+	} else {
+		parent, errP := getRawTxVerbose(bitcoinCli, txID)
+		if errP != nil {
+			err = errP
+			return
+		}
+		if vout >= len(parent.Vout) {
+			err = fmt.Errorf("no output %d in tx '%s'", vout, txID)
+			return
+		}
+		value = parent.Vout[vout].Value
+		unspent.Address = parent.Vout[vout].ScriptPubKey.address()
+		unspent.Confirmations = parent.Confirmations
+	}
+	unspent.Amount, err = AmountFromBTC(value)
+	if err != nil {
+		err = fmt.Errorf("@AmountFromBTC(%f): %v", value, err)
+		return
+	}
+	return
+}
+
+// opReturnDataHex returns the data of OP_RETURN <push data>
+func opReturnDataHex(scriptHex string) (dataHex string, err error) {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		err = fmt.Errorf("@hex.DecodeString(scriptHex): %v", err)
+		return
+	}
+	if len(script) == 0 || script[0] != 0x6a {
+		err = fmt.Errorf("not OP_RETURN script['%s']", scriptHex)
+		return
+	}
+	data := script[1:]
+	switch {
+	case len(data) == 0:
+	case data[0] == 0x00: // OP_0
+		data = data[1:]
+	case data[0] <= 75:
+		data = data[1:]
+	case data[0] == 0x4c && len(data) >= 2: // OP_PUSHDATA1
+		data = data[2:]
+	case data[0] == 0x4d && len(data) >= 3: // OP_PUSHDATA2
+		data = data[3:]
+	default:
+		err = fmt.Errorf("unsupported OP_RETURN script['%s']", scriptHex)
+		return
+	}
+	dataHex = hex.EncodeToString(data)
+	return
+}
+
+// PendingTx is an unconfirmed tx read back from the node.
+type PendingTx struct {
+	TxID        string
+	Hex         string
+	VSize       int
+	Inputs      []Unspent         // with Address and Amount, Expected
+	Outputs     map[string]Amount // without OP_RETURN
+	MessageHex  string            // data of OP_RETURN, "": none
+	Fee         Amount
+	Replaceable bool // signals BIP125
+}
+
+func loadPendingTx(bitcoinCli goBitcoinCli.BitcoinRpc, txID string) (pending PendingTx, err error) {
+	rawTx, err := getRawTxVerbose(bitcoinCli, txID)
+	if err != nil {
+		return
+	}
+	if rawTx.Confirmations > 0 {
+		err = fmt.Errorf("tx '%s' is already confirmed: %d confirmations", txID, rawTx.Confirmations)
+		return
+	}
+
+	pending = PendingTx{TxID: rawTx.TxID, Hex: rawTx.Hex, VSize: rawTx.VSize, Outputs: make(map[string]Amount)}
+	sumInputs := Amount(0)
+	for _, vin := range rawTx.Vin {
+		input, errI := prevOut(bitcoinCli, vin.TxID, vin.Vout)
+		if errI != nil {
+			err = errI
+			return
+		}
+		sumInputs += input.Amount
+		pending.Inputs = append(pending.Inputs, input)
+		if vin.Sequence < 0xfffffffe {
+			pending.Replaceable = true
+		}
+	}
+
+	sumOutputs := Amount(0)
+	for _, vout := range rawTx.Vout {
+		amount, errA := AmountFromBTC(vout.Value)
+		if errA != nil {
+			err = fmt.Errorf("@AmountFromBTC(%f): %v", vout.Value, errA)
+			return
+		}
+		sumOutputs += amount
+		if vout.ScriptPubKey.Type == "nulldata" {
+			pending.MessageHex, err = opReturnDataHex(vout.ScriptPubKey.Hex)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		pending.Outputs[vout.ScriptPubKey.address()] += amount
+	}
+	pending.Fee = sumInputs - sumOutputs
+	return
+}
+
+// txStatus finds txID in mempool, in blocks, or in the wallet of the node. Neither means replaced, evicted or unknown.
+func txStatus(bitcoinCli goBitcoinCli.BitcoinRpc, txID string) (confirmations int, inMempool bool, err error) {
+	isNotFound := func(err error) bool {
+		rpcError, ok := err.(*RpcError)
+		return ok && rpcError.Code == rpcInvalidAddressOrKey
+	}
+
+	err = callRpc(bitcoinCli, "getmempoolentry", []interface{}{txID}, nil)
+	if err == nil {
+		inMempool = true
+		return
+	}
+	if !isNotFound(err) {
+		err = fmt.Errorf("@callRpc('getmempoolentry', '%s'): %v", txID, err)
+		return
+	}
+
+	type resultConfirmations struct {
+		Confirmations int `json:"confirmations"`
+	}
+	result := resultConfirmations{}
+	for _, method := range []string{"getrawtransaction", "gettransaction"} {
+		params := []interface{}{txID}
+		if method == "getrawtransaction" {
+			params = append(params, true)
+		}
+		err = callRpc(bitcoinCli, method, params, &result)
+		if err == nil {
+			confirmations = result.Confirmations
+			return
+		}
+		if !isNotFound(err) {
+			err = fmt.Errorf("@callRpc('%s', '%s'): %v", method, txID, err)
+			return
+		}
+	}
+	err = nil
+	return
+}
+
+// spendingTxID finds a tx in mempool spending txID:vout, "": none
+func spendingTxID(bitcoinCli goBitcoinCli.BitcoinRpc, txID string, vout int) (spendingTxID string, err error) {
+	type resultSpending struct {
+		SpendingTxID string `json:"spendingtxid"`
+	}
+	results := make([]resultSpending, 0)
+	outpoints := []map[string]interface{}{{"txid": txID, "vout": vout}}
+	err = callRpc(bitcoinCli, "gettxspendingprevout", []interface{}{outpoints}, &results)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('gettxspendingprevout', '%s:%d'): %v", txID, vout, err)
+		return
+	}
+	if len(results) > 0 {
+		spendingTxID = results[0].SpendingTxID
+	}
+	return
+}
+
+// txOutSpent reports whether an output is spent, in blocks or in mempool. An output of an evicted tx is also not found.
+func txOutSpent(bitcoinCli goBitcoinCli.BitcoinRpc, txID string, vout int) (spent bool, err error) {
+	var txOut *struct {
+		Confirmations int `json:"confirmations"`
+	}
+	err = callRpc(bitcoinCli, "gettxout", []interface{}{txID, vout, true}, &txOut)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('gettxout', '%s', %d): %v", txID, vout, err)
+		return
+	}
+	spent = txOut == nil
+	return
+}
+
+// txAlreadyKnown reports whether err of sendrawtransaction is of a tx the node already has, in mempool or in blocks
+func txAlreadyKnown(err error) bool {
+	rpcError, ok := err.(*RpcError)
+	if !ok {
+		return false
+	}
+	if rpcError.Code == rpcVerifyAlreadyInChain {
+		return true
+	}
+	return strings.Contains(rpcError.Message, "txn-already-known") || strings.Contains(rpcError.Message, "txn-already-in-mempool")
+}
+
+// sendSignedRawTx broadcasts signedRawTx. A tx the node already knows is not an error: txID is of signedRawTx,
+// to be followed by txStatus.
+func sendSignedRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, signedRawTx string) (txID string, err error) {
+	err = callRpc(bitcoinCli, "sendrawtransaction", []interface{}{signedRawTx}, &txID)
+	if err == nil {
+		return
+	}
+	if !txAlreadyKnown(err) {
+		err = fmt.Errorf("@callRpc('sendrawtransaction'): %v", err)
+		return
+	}
+	tx, err := DecodeTx(signedRawTx)
+	if err != nil {
+		err = fmt.Errorf("@DecodeTx(): %v", err)
+		return
+	}
+	txID, err = tx.TxID()
+	return
+}
@@ -0,0 +1,73 @@
+package gobitcoinopreturn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendSignedRawTx(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	signedRawTx, err := signRawTx(rawTx, unspents, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := DecodeTx(signedRawTx)
+	wantTxID, _ := tx.TxID()
+
+	tests := []struct {
+		rpcError RpcError
+		wantErr  bool
+	}{
+		{RpcError{Code: rpcVerifyAlreadyInChain, Message: "Transaction already in block chain"}, false},
+		{RpcError{Code: -26, Message: "txn-already-known"}, false},
+		{RpcError{Code: -26, Message: "txn-already-in-mempool"}, false},
+		{RpcError{Code: -26, Message: "min relay fee not met, 100 < 110"}, true},
+		{RpcError{Code: -26, Message: "txn-mempool-conflict"}, true},
+		{RpcError{Code: -25, Message: "bad-txns-inputs-missingorspent"}, true},
+	}
+	for _, test := range tests {
+		bitcoind := newTestBitcoind(map[string]string{})
+		bitcoind.rpcErrors = map[string]RpcError{"sendrawtransaction": test.rpcError}
+		opReturn := OpReturn{}
+		opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+		txID, err := sendSignedRawTx(opReturn.bitcoinCli(), signedRawTx)
+		bitcoind.Close()
+		if test.wantErr {
+			if err == nil || !strings.Contains(err.Error(), test.rpcError.Message) {
+				t.Errorf("%+v: txID '%s', err %v", test.rpcError, txID, err)
+			}
+			continue
+		}
+		if err != nil || txID != wantTxID {
+			t.Errorf("%+v: txID '%s', err %v, want '%s'", test.rpcError, txID, err, wantTxID)
+		}
+	}
+}
+
+func TestTxStatus(t *testing.T) {
+	tests := []struct {
+		results       map[string]string
+		rpcErrors     map[string]RpcError
+		confirmations int
+		inMempool     bool
+		wantErr       bool
+	}{
+		{results: map[string]string{"getmempoolentry": `{"vsize":110}`}, inMempool: true},
+		{results: map[string]string{"getrawtransaction": `{"confirmations":2}`}, confirmations: 2},
+		{results: map[string]string{"gettransaction": `{"confirmations":3}`}, confirmations: 3},
+		{results: map[string]string{}},
+		{rpcErrors: map[string]RpcError{"getmempoolentry": {Code: -28, Message: "Loading block index..."}}, wantErr: true},
+		{rpcErrors: map[string]RpcError{"gettransaction": {Code: -18, Message: "Requested wallet does not exist or is not loaded"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		bitcoind := newTestBitcoind(test.results)
+		bitcoind.rpcErrors = test.rpcErrors
+		opReturn := OpReturn{}
+		opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+		confirmations, inMempool, err := txStatus(opReturn.bitcoinCli(), "txid")
+		bitcoind.Close()
+		if (err != nil) != test.wantErr || confirmations != test.confirmations || inMempool != test.inMempool {
+			t.Errorf("%v %v: confirmations %d, inMempool %v, err %v", test.results, test.rpcErrors, confirmations, inMempool, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// loadJSON reads path into v, exists is false without the file.
+func loadJSON(path string, v interface{}) (exists bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("@os.ReadFile('%s'): %v", path, err)
+		return
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		err = fmt.Errorf("@json.Unmarshal('%s'): %v", path, err)
+		return
+	}
+	exists = true
+	return
+}
+
+// saveJSON writes v to path through a temporary file and rename, not to leave a half-written state.
+func saveJSON(path string, v interface{}) (err error) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		err = fmt.Errorf("@json.MarshalIndent(): %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		err = fmt.Errorf("@os.CreateTemp('%s'): %v", path, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if errC := tmp.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		err = fmt.Errorf("write '%s': %v", tmp.Name(), err)
+		return
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		err = fmt.Errorf("@os.Rename('%s', '%s'): %v", tmp.Name(), path, err)
+		return
+	}
+	return
+}
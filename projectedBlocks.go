@@ -0,0 +1,252 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	maxBlockVSize             = 1000000.0
+	countProjectedBlocks      = 8   // like mempool.space, the last block holds the rest of mempool
+	minRelayFeePerVByte       = 1.0 // Sats 1, beyond the projection
+	defaultFeeRangePercentile = 50.0
+)
+
+// percentiles of ProjectedBlock.FeeRange with 7 values, as mempool.space
+var feeRangePercentiles = []float64{0, 10, 25, 50, 75, 90, 100}
+
+type ProjectedBlock struct {
+	BlockSize  int       `json:"blockSize"`
+	BlockVSize float64   `json:"blockVSize"`
+	NTx        int       `json:"nTx"`
+	TotalFees  int64     `json:"totalFees"` // Sats 1
+	MedianFee  float64   `json:"medianFee"`
+	FeeRange   []float64 `json:"feeRange"` // sats/vbyte at percentiles 0, 10, 25, 50, 75, 90, 100 of the block
+}
+
+// feeAtPercentile interpolates FeeRange, percentile 100 pays the most in the block
+func (block ProjectedBlock) feeAtPercentile(percentile float64) (fee float64) {
+	feeRange := block.FeeRange
+	if len(feeRange) == 0 {
+		fee = block.MedianFee
+		return
+	}
+	percentiles := feeRangePercentiles
+	if len(feeRange) != len(feeRangePercentiles) {
+		percentiles = make([]float64, len(feeRange))
+		for i := range feeRange {
+			if len(feeRange) > 1 {
+				percentiles[i] = 100.0 * float64(i) / float64(len(feeRange)-1)
+			}
+		}
+	}
+
+	fee = feeRange[len(feeRange)-1]
+	for i := 1; i < len(feeRange); i++ {
+		if percentile > percentiles[i] {
+			continue
+		}
+		ratio := (percentile - percentiles[i-1]) / (percentiles[i] - percentiles[i-1])
+		fee = feeRange[i-1] + (feeRange[i]-feeRange[i-1])*ratio
+		return
+	}
+	return
+}
+
+type ProjectedBlocksSource interface {
+	ProjectedBlocks() (blocks []ProjectedBlock, err error)
+}
+
+// ProjectedBlocks requests /api/v1/fees/mempool-blocks
+func (estimator MempoolSpaceFeeEstimator) ProjectedBlocks() (blocks []ProjectedBlock, err error) {
+	err = estimator.getJson("/api/v1/fees/mempool-blocks", &blocks)
+	return
+}
+
+// ProjectedBlocks packs a getrawmempool snapshot of the node into blocks by fee rate of each tx, without ancestors.
+func (estimator BitcoindFeeEstimator) ProjectedBlocks() (blocks []ProjectedBlock, err error) {
+	type resultMempoolEntry struct {
+		VSize float64 `json:"vsize"`
+		Fees  struct {
+			Base float64 `json:"base"` // BTC
+		} `json:"fees"`
+	}
+	entries := make(map[string]resultMempoolEntry)
+	err = callRpc(estimator.bitcoinCli(), "getrawmempool", []interface{}{true}, &entries)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('getrawmempool', true): %v", err)
+		return
+	}
+
+	type mempoolTx struct {
+		vSize float64
+		fee   Amount
+	}
+	txs := make([]mempoolTx, 0, len(entries))
+	for _, entry := range entries {
+		if entry.VSize <= 0 {
+			continue
+		}
+		fee, errA := AmountFromBTC(entry.Fees.Base)
+		if errA != nil {
+			err = fmt.Errorf("@AmountFromBTC(%f): %v", entry.Fees.Base, errA)
+			return
+		}
+		txs = append(txs, mempoolTx{vSize: entry.VSize, fee: fee})
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return float64(txs[i].fee)/txs[i].vSize > float64(txs[j].fee)/txs[j].vSize
+	})
+
+	blocks = make([]ProjectedBlock, 0)
+	blockTxs := make([]mempoolTx, 0)
+	flush := func() {
+		block := ProjectedBlock{NTx: len(blockTxs)}
+		for _, tx := range blockTxs {
+			block.BlockVSize += tx.vSize
+			block.TotalFees += int64(tx.fee)
+		}
+		// blockTxs are in descending fee rate, percentile 0 is the last one
+		block.FeeRange = make([]float64, len(feeRangePercentiles))
+		for i, percentile := range feeRangePercentiles {
+			vSizeAbove := (1.0 - percentile/100.0) * block.BlockVSize
+			tx := blockTxs[len(blockTxs)-1]
+			cumVSize := 0.0
+			for _, blockTx := range blockTxs {
+				cumVSize += blockTx.vSize
+				if cumVSize >= vSizeAbove {
+					tx = blockTx
+					break
+				}
+			}
+			block.FeeRange[i] = float64(tx.fee) / tx.vSize
+		}
+		block.MedianFee = block.FeeRange[3]
+		blocks = append(blocks, block)
+		blockTxs = make([]mempoolTx, 0)
+	}
+	vSize := 0.0
+	for _, tx := range txs {
+		if vSize+tx.vSize > maxBlockVSize && len(blockTxs) > 0 && len(blocks) < countProjectedBlocks-1 {
+			flush()
+			vSize = 0.0
+		}
+		blockTxs = append(blockTxs, tx)
+		vSize += tx.vSize
+	}
+	if len(blockTxs) > 0 {
+		flush()
+	}
+	return
+}
+
+type BlockFeeEstimate struct {
+	FeePerVByte float64 // Sats 1
+	Block       int     // 0: next block
+	VSizeAhead  float64 // vbytes of mempool paying more, in front of the tx
+	Source      string
+}
+
+// ProjectedBlockFeeEstimator places a tx at Percentile of a projected block of mempool.
+// As a FeeEstimator, the levels of RemoteFees are blocks 1, 3, 6, 144 and 1008.
+type ProjectedBlockFeeEstimator struct {
+	Blocks     ProjectedBlocksSource // e.g. MempoolSpaceFeeEstimator, BitcoindFeeEstimator
+	Percentile float64               // 0 ~ 100 in the block, 100 pays as the top of it. 0: 50
+}
+
+func (estimator ProjectedBlockFeeEstimator) source() string {
+	switch blocks := estimator.Blocks.(type) {
+	case MempoolSpaceFeeEstimator:
+		return "mempool-blocks:" + blocks.baseURL()
+	case BitcoindFeeEstimator:
+		return "bitcoind:getrawmempool"
+	}
+	return fmt.Sprintf("%T", estimator.Blocks)
+}
+
+// EstimateBlockFee returns the fee rate at Percentile of the block-th(0: next) projected block and where it lands.
+func (estimator ProjectedBlockFeeEstimator) EstimateBlockFee(block int) (estimate BlockFeeEstimate, err error) {
+	blocks, err := estimator.Blocks.ProjectedBlocks()
+	if err != nil {
+		err = fmt.Errorf("@estimator.Blocks.ProjectedBlocks(): %v", err)
+		return
+	}
+	estimate, err = estimator.blockFee(blocks, block)
+	return
+}
+
+func (estimator ProjectedBlockFeeEstimator) blockFee(blocks []ProjectedBlock, block int) (estimate BlockFeeEstimate, err error) {
+	if block < 0 {
+		err = fmt.Errorf("incorrect block[%d]", block)
+		return
+	}
+	percentile := estimator.Percentile
+	if percentile <= 0.0 {
+		percentile = defaultFeeRangePercentile
+	}
+	if percentile > 100.0 {
+		err = fmt.Errorf("incorrect percentile[%f]", percentile)
+		return
+	}
+	estimate.Block = block
+	estimate.Source = estimator.source()
+
+	// vbytes ahead of the tx, the last block of mempool.space may hold more than one block
+	last := len(blocks) - 1
+	start := 0.0
+	switch {
+	case block < last || (block == last && blocks[last].BlockVSize <= maxBlockVSize):
+		for i := 0; i < block; i++ {
+			start += blocks[i].BlockVSize
+		}
+		estimate.VSizeAhead = start + (1.0-percentile/100.0)*blocks[block].BlockVSize
+	case len(blocks) > 0:
+		for i := 0; i < last; i++ {
+			start += blocks[i].BlockVSize
+		}
+		estimate.VSizeAhead = start + (float64(block-last)+1.0-percentile/100.0)*maxBlockVSize
+	}
+
+	estimate.FeePerVByte = minRelayFeePerVByte
+	cumVSize := 0.0
+	for _, projectedBlock := range blocks {
+		if estimate.VSizeAhead < cumVSize+projectedBlock.BlockVSize && projectedBlock.BlockVSize > 0 {
+			fractionAhead := (estimate.VSizeAhead - cumVSize) / projectedBlock.BlockVSize
+			estimate.FeePerVByte = projectedBlock.feeAtPercentile(100.0 * (1.0 - fractionAhead))
+			break
+		}
+		cumVSize += projectedBlock.BlockVSize
+	}
+	if estimate.FeePerVByte < minRelayFeePerVByte {
+		estimate.FeePerVByte = minRelayFeePerVByte
+	}
+	return
+}
+
+func (estimator ProjectedBlockFeeEstimator) EstimateFeeForTarget(confTarget int) (feePerVByte float64, err error) {
+	estimate, err := estimator.EstimateBlockFee(confTarget - 1)
+	if err != nil {
+		return
+	}
+	feePerVByte = estimate.FeePerVByte
+	return
+}
+
+func (estimator ProjectedBlockFeeEstimator) EstimateFees() (remoteFees RemoteFees, err error) {
+	blocks, err := estimator.Blocks.ProjectedBlocks()
+	if err != nil {
+		err = fmt.Errorf("@estimator.Blocks.ProjectedBlocks(): %v", err)
+		return
+	}
+
+	for _, level := range remoteFees.levelTargets() {
+		estimate, errB := estimator.blockFee(blocks, level.confTarget-1)
+		if errB != nil {
+			err = errB
+			return
+		}
+		*level.fee = estimate.FeePerVByte
+	}
+	remoteFees.Source = estimator.source()
+	return
+}
@@ -0,0 +1,111 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testBlocksSource []ProjectedBlock
+
+func (source testBlocksSource) ProjectedBlocks() (blocks []ProjectedBlock, err error) {
+	blocks = source
+	return
+}
+
+func TestProjectedBlockFeeEstimator(t *testing.T) {
+	blocks := testBlocksSource{
+		{BlockVSize: 1000000, FeeRange: []float64{20, 22, 25, 30, 35, 40, 100}},
+		{BlockVSize: 1000000, FeeRange: []float64{10, 11, 12, 15, 17, 19, 20}},
+		{BlockVSize: 2500000, FeeRange: []float64{2, 3, 4, 5, 7, 9, 10}}, // rest of mempool
+	}
+	estimator := ProjectedBlockFeeEstimator{Blocks: blocks}
+
+	testCases := []struct {
+		block      int
+		fee        float64
+		vSizeAhead float64
+	}{
+		{0, 30, 500000},
+		{1, 15, 1500000},
+		{2, 7 + 2.0/3, 2500000}, // 20% of the rest bucket is ahead: percentile 80
+		{3, 4.6, 3500000},       // percentile 40
+		{4, 1, 4500000},         // end of mempool
+		{10, 1, 10500000},       // beyond the projection
+	}
+	for _, testCase := range testCases {
+		estimate, err := estimator.EstimateBlockFee(testCase.block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(estimate.FeePerVByte-testCase.fee) > 1e-9 || estimate.VSizeAhead != testCase.vSizeAhead || estimate.Block != testCase.block {
+			t.Errorf("block %d: %+v, expected fee %f, ahead %f", testCase.block, estimate, testCase.fee, testCase.vSizeAhead)
+		}
+	}
+
+	estimator.Percentile = 90
+	if estimate, _ := estimator.EstimateBlockFee(0); estimate.FeePerVByte != 40 || math.Abs(estimate.VSizeAhead-100000) > 1e-6 {
+		t.Errorf("percentile 90: %+v", estimate)
+	}
+
+	remoteFees, err := estimator.EstimateFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFees.FastestFee != 40 || remoteFees.MinimumFee != minRelayFeePerVByte {
+		t.Errorf("remoteFees: %+v", remoteFees)
+	}
+	fee, source, err := getFeePerVByte3(estimator, 1, 100, "", 2)
+	if err != nil || fee != 19 || source != "ProjectedBlockFeeEstimator(2 blocks)" {
+		t.Errorf("fee: %f, source: %s, err: %v", fee, source, err)
+	}
+}
+
+func TestMempoolSpaceProjectedBlocks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"blockSize":1500000,"blockVSize":997000.5,"nTx":3000,"totalFees":12000000,"medianFee":12.5,"feeRange":[10,11,12,12.5,14,20,300]}]`)
+	}))
+	defer server.Close()
+
+	estimator := ProjectedBlockFeeEstimator{Blocks: MempoolSpaceFeeEstimator{BaseURL: server.URL}}
+	estimate, err := estimator.EstimateBlockFee(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.FeePerVByte != 12.5 || estimate.Source != "mempool-blocks:"+server.URL {
+		t.Errorf("estimate: %+v", estimate)
+	}
+}
+
+func TestBitcoindProjectedBlocks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"getrawmempool"`) {
+			t.Errorf("body: %s", body)
+		}
+		entries := make([]string, 0)
+		for i := 0; i < 30; i++ {
+			// 100000 vbytes each, i+1 sats/vbyte
+			entries = append(entries, fmt.Sprintf(`"tx%d":{"vsize":100000,"fees":{"base":%.8f}}`, i, float64((i+1)*100000)/SatoshiPerBitcoin))
+		}
+		fmt.Fprintf(w, `{"result":{%s},"error":null,"id":"GoBitcoinOpReturn"}`, strings.Join(entries, ","))
+	}))
+	defer server.Close()
+
+	hostPort := strings.TrimPrefix(server.URL, "http://")
+	source := BitcoindFeeEstimator{
+		RpcConnect: hostPort[:strings.LastIndex(hostPort, ":")],
+		RpcPort:    hostPort[strings.LastIndex(hostPort, ":")+1:],
+	}
+	blocks, err := source.ProjectedBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || blocks[0].NTx != 10 || blocks[0].FeeRange[0] != 21 || blocks[0].FeeRange[6] != 30 || blocks[2].FeeRange[0] != 1 {
+		t.Errorf("blocks: %+v", blocks)
+	}
+}
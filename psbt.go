@@ -0,0 +1,852 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+// PSBT(BIP174) version 0, for signing by an external signer such as an air-gapped hardware wallet
+
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff} // "psbt" 0xff
+
+const (
+	psbtGlobalUnsignedTx = 0x00
+
+	psbtInNonWitnessUtxo     = 0x00
+	psbtInWitnessUtxo        = 0x01
+	psbtInPartialSig         = 0x02
+	psbtInBip32Derivation    = 0x06
+	psbtInFinalScriptSig     = 0x07
+	psbtInFinalScriptWitness = 0x08
+	psbtInTapKeySig          = 0x13
+	psbtInTapBip32Derivation = 0x16
+	psbtInTapInternalKey     = 0x17
+
+	psbtOutBip32Derivation    = 0x02
+	psbtOutTapInternalKey     = 0x05
+	psbtOutTapBip32Derivation = 0x07
+)
+
+type psbtInput struct {
+	NonWitnessUtxo     []byte            // serialized previous tx
+	WitnessUtxo        *TxOut            // previous output
+	PartialSigs        map[string][]byte // hex of public key: signature with the sighash type
+	TapKeySig          []byte            // key path signature of P2TR
+	FinalScriptSig     []byte
+	FinalScriptWitness [][]byte
+	psbtKeyOrigins
+}
+
+type psbtOutput struct {
+	psbtKeyOrigins
+}
+
+// psbtKeyOrigins are the BIP32 derivations of keys of an input or an output
+type psbtKeyOrigins struct {
+	Bip32Derivations    []KeyOrigin // of ECDSA keys
+	TapBip32Derivations []KeyOrigin // of x-only keys, of the key path
+	TapInternalKey      []byte
+}
+
+// psbt is the unsigned tx, and what signers need of each input and output.
+type psbt struct {
+	Tx      Tx
+	Inputs  []psbtInput
+	Outputs []psbtOutput
+}
+
+func writePsbtRecord(buffer *bytes.Buffer, key []byte, value []byte) {
+	writeVarBytes(buffer, key)
+	writeVarBytes(buffer, value)
+}
+
+// writeDerivationRecords writes records of keyType keyed by the public key of each origin, with no leaf hashes for taproot
+func writeDerivationRecords(buffer *bytes.Buffer, keyType byte, origins []KeyOrigin, isTaproot bool) (err error) {
+	for _, origin := range origins {
+		pubKey, errD := hex.DecodeString(origin.PubKey)
+		if errD != nil {
+			err = fmt.Errorf("@hex.DecodeString('%s'): %v", origin.PubKey, errD)
+			return
+		}
+		derivation, errD := origin.derivation()
+		if errD != nil {
+			err = fmt.Errorf("@origin.derivation(): %v", errD)
+			return
+		}
+		if isTaproot {
+			derivation = append([]byte{0x00}, derivation...)
+		}
+		writePsbtRecord(buffer, append([]byte{keyType}, pubKey...), derivation)
+	}
+	return
+}
+
+func serializeWitness(witness [][]byte) []byte {
+	buffer := new(bytes.Buffer)
+	writeCompactSize(buffer, uint64(len(witness)))
+	for _, item := range witness {
+		writeVarBytes(buffer, item)
+	}
+	return buffer.Bytes()
+}
+
+func serializeTxOut(output TxOut) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, int64(output.Value))
+	writeVarBytes(buffer, output.ScriptPubKey)
+	return buffer.Bytes()
+}
+
+// Base64 serializes the psbt
+func (p *psbt) Base64() (encoded string, err error) {
+	unsigned := p.Tx
+	unsigned.Inputs = make([]TxIn, len(p.Tx.Inputs))
+	for i, input := range p.Tx.Inputs {
+		unsigned.Inputs[i] = TxIn{PrevTxID: input.PrevTxID, Vout: input.Vout, Sequence: input.Sequence}
+	}
+	unsignedTx, err := unsigned.SerializeNoWitness()
+	if err != nil {
+		err = fmt.Errorf("@unsigned.SerializeNoWitness(): %v", err)
+		return
+	}
+
+	buffer := bytes.NewBuffer(append([]byte{}, psbtMagic...))
+	writePsbtRecord(buffer, []byte{psbtGlobalUnsignedTx}, unsignedTx)
+	buffer.WriteByte(0x00)
+	for _, input := range p.Inputs {
+		if input.NonWitnessUtxo != nil {
+			writePsbtRecord(buffer, []byte{psbtInNonWitnessUtxo}, input.NonWitnessUtxo)
+		}
+		if input.WitnessUtxo != nil {
+			writePsbtRecord(buffer, []byte{psbtInWitnessUtxo}, serializeTxOut(*input.WitnessUtxo))
+		}
+		pubKeys := make([]string, 0)
+		for pubKey := range input.PartialSigs {
+			pubKeys = append(pubKeys, pubKey)
+		}
+		sort.Strings(pubKeys)
+		for _, pubKey := range pubKeys {
+			key, errD := hex.DecodeString(pubKey)
+			if errD != nil {
+				err = fmt.Errorf("@hex.DecodeString('%s'): %v", pubKey, errD)
+				return
+			}
+			writePsbtRecord(buffer, append([]byte{psbtInPartialSig}, key...), input.PartialSigs[pubKey])
+		}
+		if err = writeDerivationRecords(buffer, psbtInBip32Derivation, input.Bip32Derivations, false); err != nil {
+			return
+		}
+		if input.FinalScriptSig != nil {
+			writePsbtRecord(buffer, []byte{psbtInFinalScriptSig}, input.FinalScriptSig)
+		}
+		if input.FinalScriptWitness != nil {
+			writePsbtRecord(buffer, []byte{psbtInFinalScriptWitness}, serializeWitness(input.FinalScriptWitness))
+		}
+		if input.TapKeySig != nil {
+			writePsbtRecord(buffer, []byte{psbtInTapKeySig}, input.TapKeySig)
+		}
+		if err = writeDerivationRecords(buffer, psbtInTapBip32Derivation, input.TapBip32Derivations, true); err != nil {
+			return
+		}
+		if input.TapInternalKey != nil {
+			writePsbtRecord(buffer, []byte{psbtInTapInternalKey}, input.TapInternalKey)
+		}
+		buffer.WriteByte(0x00)
+	}
+	for i := range p.Tx.Outputs {
+		if i < len(p.Outputs) {
+			output := p.Outputs[i]
+			if err = writeDerivationRecords(buffer, psbtOutBip32Derivation, output.Bip32Derivations, false); err != nil {
+				return
+			}
+			if output.TapInternalKey != nil {
+				writePsbtRecord(buffer, []byte{psbtOutTapInternalKey}, output.TapInternalKey)
+			}
+			if err = writeDerivationRecords(buffer, psbtOutTapBip32Derivation, output.TapBip32Derivations, true); err != nil {
+				return
+			}
+		}
+		buffer.WriteByte(0x00)
+	}
+	encoded = base64.StdEncoding.EncodeToString(buffer.Bytes())
+	return
+}
+
+// psbtReader reads records of key-value maps
+type psbtReader struct {
+	*bytes.Reader
+}
+
+func (reader psbtReader) readCompactSize() (n uint64, err error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	switch prefix {
+	case 0xfd:
+		var v uint16
+		err = binary.Read(reader, binary.LittleEndian, &v)
+		n = uint64(v)
+	case 0xfe:
+		var v uint32
+		err = binary.Read(reader, binary.LittleEndian, &v)
+		n = uint64(v)
+	case 0xff:
+		err = binary.Read(reader, binary.LittleEndian, &n)
+	default:
+		n = uint64(prefix)
+	}
+	return
+}
+
+func (reader psbtReader) readBytes() (data []byte, err error) {
+	n, err := reader.readCompactSize()
+	if err != nil {
+		return
+	}
+	if n > uint64(reader.Len()) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	data = make([]byte, n)
+	_, err = io.ReadFull(reader, data)
+	return
+}
+
+// readRecord reads a key-value record, nil key: the separator of a map
+func (reader psbtReader) readRecord() (key []byte, value []byte, err error) {
+	key, err = reader.readBytes()
+	if err != nil || len(key) == 0 {
+		key = nil
+		return
+	}
+	value, err = reader.readBytes()
+	return
+}
+
+func decodeTxOut(data []byte) (output TxOut, err error) {
+	if len(data) < 9 {
+		err = fmt.Errorf("incorrect length %d of txout", len(data))
+		return
+	}
+	output.Value = Amount(int64(binary.LittleEndian.Uint64(data[:8])))
+	reader := psbtReader{bytes.NewReader(data[8:])}
+	output.ScriptPubKey, err = reader.readBytes()
+	if err == nil && reader.Len() > 0 {
+		err = fmt.Errorf("unexpected %d bytes after txout", reader.Len())
+	}
+	return
+}
+
+// readKeyOrigin reads a BIP32 derivation record of keyType(0x06 or 0x02) or of taproot(0x16 or 0x07) into origins
+func (origins *psbtKeyOrigins) readKeyOrigin(key []byte, value []byte, isTaproot bool) (err error) {
+	derivation := value
+	if isTaproot {
+		reader := psbtReader{bytes.NewReader(value)}
+		countLeafHashes, errR := reader.readCompactSize()
+		if errR != nil || countLeafHashes*32 > uint64(reader.Len()) {
+			err = fmt.Errorf("incorrect leaf hashes of taproot derivation")
+			return
+		}
+		derivation = value[len(value)-reader.Len()+int(countLeafHashes)*32:]
+	}
+	origin, err := decodeKeyOrigin(key[1:], derivation)
+	if err != nil {
+		err = fmt.Errorf("@decodeKeyOrigin(%x): %v", key[1:], err)
+		return
+	}
+	if isTaproot {
+		origins.TapBip32Derivations = append(origins.TapBip32Derivations, origin)
+	} else {
+		origins.Bip32Derivations = append(origins.Bip32Derivations, origin)
+	}
+	return
+}
+
+func decodeWitness(data []byte) (witness [][]byte, err error) {
+	reader := psbtReader{bytes.NewReader(data)}
+	count, err := reader.readCompactSize()
+	if err != nil {
+		return
+	}
+	if count > uint64(len(data)) {
+		err = fmt.Errorf("incorrect count %d of witness items", count)
+		return
+	}
+	witness = make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		item, errR := reader.readBytes()
+		if errR != nil {
+			err = errR
+			return
+		}
+		witness = append(witness, item)
+	}
+	if reader.Len() > 0 {
+		err = fmt.Errorf("unexpected %d bytes after witness", reader.Len())
+	}
+	return
+}
+
+// decodePsbt decodes a base64 psbt of version 0
+func decodePsbt(encoded string) (p psbt, err error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		err = fmt.Errorf("@base64.StdEncoding.DecodeString(): %v", err)
+		return
+	}
+	if !bytes.HasPrefix(data, psbtMagic) {
+		err = fmt.Errorf("no magic bytes of psbt")
+		return
+	}
+	reader := psbtReader{bytes.NewReader(data[len(psbtMagic):])}
+
+	hasTx := false
+	for {
+		key, value, errR := reader.readRecord()
+		if errR != nil {
+			err = fmt.Errorf("global map: %v", errR)
+			return
+		}
+		if key == nil {
+			break
+		}
+		if len(key) == 1 && key[0] == psbtGlobalUnsignedTx {
+			p.Tx, err = DecodeTx(hex.EncodeToString(value))
+			if err != nil {
+				err = fmt.Errorf("@DecodeTx(unsigned tx): %v", err)
+				return
+			}
+			hasTx = true
+		}
+	}
+	if !hasTx {
+		err = fmt.Errorf("no unsigned tx of psbt")
+		return
+	}
+	if p.Tx.HasWitness() {
+		err = fmt.Errorf("unsigned tx of psbt with witnesses")
+		return
+	}
+	for _, input := range p.Tx.Inputs {
+		if len(input.ScriptSig) > 0 {
+			err = fmt.Errorf("unsigned tx of psbt with scriptSig")
+			return
+		}
+	}
+
+	p.Inputs = make([]psbtInput, len(p.Tx.Inputs))
+	for i := range p.Inputs {
+		input := &p.Inputs[i]
+		for {
+			key, value, errR := reader.readRecord()
+			if errR != nil {
+				err = fmt.Errorf("map of input %d: %v", i, errR)
+				return
+			}
+			if key == nil {
+				break
+			}
+			switch key[0] {
+			case psbtInNonWitnessUtxo:
+				input.NonWitnessUtxo = value
+			case psbtInWitnessUtxo:
+				output, errO := decodeTxOut(value)
+				if errO != nil {
+					err = fmt.Errorf("witness utxo of input %d: %v", i, errO)
+					return
+				}
+				input.WitnessUtxo = &output
+			case psbtInPartialSig:
+				if input.PartialSigs == nil {
+					input.PartialSigs = make(map[string][]byte)
+				}
+				input.PartialSigs[hex.EncodeToString(key[1:])] = value
+			case psbtInFinalScriptSig:
+				input.FinalScriptSig = value
+			case psbtInFinalScriptWitness:
+				input.FinalScriptWitness, err = decodeWitness(value)
+				if err != nil {
+					err = fmt.Errorf("final script witness of input %d: %v", i, err)
+					return
+				}
+			case psbtInTapKeySig:
+				input.TapKeySig = value
+			case psbtInBip32Derivation, psbtInTapBip32Derivation:
+				if err = input.readKeyOrigin(key, value, key[0] == psbtInTapBip32Derivation); err != nil {
+					err = fmt.Errorf("derivation of input %d: %v", i, err)
+					return
+				}
+			case psbtInTapInternalKey:
+				input.TapInternalKey = value
+			}
+		}
+	}
+	p.Outputs = make([]psbtOutput, len(p.Tx.Outputs))
+	for i := range p.Outputs {
+		output := &p.Outputs[i]
+		for {
+			key, value, errR := reader.readRecord()
+			if errR != nil {
+				err = fmt.Errorf("map of output %d: %v", i, errR)
+				return
+			}
+			if key == nil {
+				break
+			}
+			switch key[0] {
+			case psbtOutBip32Derivation, psbtOutTapBip32Derivation:
+				if err = output.readKeyOrigin(key, value, key[0] == psbtOutTapBip32Derivation); err != nil {
+					err = fmt.Errorf("derivation of output %d: %v", i, err)
+					return
+				}
+			case psbtOutTapInternalKey:
+				output.TapInternalKey = value
+			}
+		}
+	}
+	if reader.Len() > 0 {
+		err = fmt.Errorf("unexpected %d bytes after psbt", reader.Len())
+		return
+	}
+	return
+}
+
+// prevTxHex finds a previous tx in the node, or in its wallet without txindex
+func prevTxHex(bitcoinCli goBitcoinCli.BitcoinRpc, txID string) (txHex string, err error) {
+	err = callRpc(bitcoinCli, "getrawtransaction", []interface{}{txID, false}, &txHex)
+	if err == nil {
+		return
+	}
+	type resultTransaction struct {
+		Hex string `json:"hex"`
+	}
+	result := resultTransaction{}
+	if errW := callRpc(bitcoinCli, "gettransaction", []interface{}{txID}, &result); errW != nil {
+		err = fmt.Errorf("@callRpc('getrawtransaction', '%s'): %v, @callRpc('gettransaction'): %v", txID, err, errW)
+		return
+	}
+	txHex, err = result.Hex, nil
+	return
+}
+
+// newPsbt puts rawTx spending unspents(Address "": address) into a psbt, with the previous output of each input.
+// Legacy inputs carry their previous tx, also P2WPKH and P2SH inputs when the node finds it, as hardware wallets want.
+func newPsbt(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (p psbt, err error) {
+	p.Tx, err = DecodeTx(rawTx)
+	if err != nil {
+		err = fmt.Errorf("@DecodeTx(rawTx): %v", err)
+		return
+	}
+	scripts, amounts, err := prevOutScripts(&p.Tx, unspents, address)
+	if err != nil {
+		return
+	}
+	p.Inputs = make([]psbtInput, len(p.Tx.Inputs))
+	p.Outputs = make([]psbtOutput, len(p.Tx.Outputs))
+	for i, script := range scripts {
+		input := p.Tx.Inputs[i]
+		isLegacy := isP2PKHScript(script)
+		if !isLegacy {
+			p.Inputs[i].WitnessUtxo = &TxOut{Value: amounts[i], ScriptPubKey: script}
+		}
+		if isP2TRScript(script) {
+			continue // BIP341 commits to the amounts of every input
+		}
+		txHex, errP := prevTxHex(bitcoinCli, input.PrevTxID)
+		if errP != nil {
+			if isLegacy {
+				err = fmt.Errorf("@prevTxHex('%s'): %v", input.PrevTxID, errP)
+				return
+			}
+			continue
+		}
+		if p.Inputs[i].NonWitnessUtxo, err = hex.DecodeString(txHex); err != nil {
+			err = fmt.Errorf("@hex.DecodeString(previous tx '%s'): %v", input.PrevTxID, err)
+			return
+		}
+	}
+	return
+}
+
+// addKeyOrigins adds the BIP32 derivation of the key of each address of keyOrigins to the inputs spending it
+// and to the outputs paying it, such as the change.
+func (p *psbt) addKeyOrigins(keyOrigins map[string]KeyOrigin) (err error) {
+	addresses := make([]string, 0, len(keyOrigins))
+	for address := range keyOrigins {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		origin := keyOrigins[address]
+		script, errA := AddressScript(address)
+		if errA != nil {
+			err = fmt.Errorf("@AddressScript('%s'): %v", address, errA)
+			return
+		}
+		pubKey, isTaproot, errK := origin.keyOf(script)
+		if errK != nil {
+			err = fmt.Errorf("key origin of '%s': %v", address, errK)
+			return
+		}
+		if _, err = origin.derivation(); err != nil {
+			err = fmt.Errorf("key origin of '%s': %v", address, err)
+			return
+		}
+		add := func(origins *psbtKeyOrigins) {
+			if isTaproot {
+				origins.TapBip32Derivations = append(origins.TapBip32Derivations, origin)
+				origins.TapInternalKey = pubKey
+			} else {
+				origins.Bip32Derivations = append(origins.Bip32Derivations, origin)
+			}
+		}
+		for i := range p.Inputs {
+			output, errO := p.prevOut(i)
+			if errO != nil {
+				err = errO
+				return
+			}
+			if bytes.Equal(output.ScriptPubKey, script) {
+				add(&p.Inputs[i].psbtKeyOrigins)
+			}
+		}
+		for i, output := range p.Tx.Outputs {
+			if bytes.Equal(output.ScriptPubKey, script) {
+				add(&p.Outputs[i].psbtKeyOrigins)
+			}
+		}
+	}
+	return
+}
+
+// prevOut is the previous output of input i: witness utxo, or the output of the previous tx matching the txid
+func (p *psbt) prevOut(i int) (output TxOut, err error) {
+	input := p.Tx.Inputs[i]
+	if p.Inputs[i].NonWitnessUtxo != nil {
+		prevTx, errD := DecodeTx(hex.EncodeToString(p.Inputs[i].NonWitnessUtxo))
+		if errD != nil {
+			err = fmt.Errorf("@DecodeTx(non witness utxo of input %d): %v", i, errD)
+			return
+		}
+		txID, errT := prevTx.TxID()
+		if errT != nil || txID != input.PrevTxID {
+			err = fmt.Errorf("non witness utxo of input %d is not %s", i, input.PrevTxID)
+			return
+		}
+		if int(input.Vout) >= len(prevTx.Outputs) {
+			err = fmt.Errorf("no output %d of the previous tx of input %d", input.Vout, i)
+			return
+		}
+		output = prevTx.Outputs[input.Vout]
+		if witnessUtxo := p.Inputs[i].WitnessUtxo; witnessUtxo != nil &&
+			(witnessUtxo.Value != output.Value || !bytes.Equal(witnessUtxo.ScriptPubKey, output.ScriptPubKey)) {
+			err = fmt.Errorf("witness utxo of input %d differs from its previous tx", i)
+			return
+		}
+		return
+	}
+	if p.Inputs[i].WitnessUtxo == nil {
+		err = fmt.Errorf("no utxo of input %d", i)
+		return
+	}
+	output = *p.Inputs[i].WitnessUtxo
+	return
+}
+
+// verifyECDSA verifies signature with the sighash type of SIGHASH_ALL by pubKey(SEC1) for hash
+func verifyECDSA(pubKey []byte, signature []byte, hash []byte) (err error) {
+	if len(signature) < 1 || signature[len(signature)-1] != sigHashAll {
+		err = fmt.Errorf("signature is not of SIGHASH_ALL")
+		return
+	}
+	sig, err := ecdsa.ParseDERSignature(signature[:len(signature)-1])
+	if err != nil {
+		err = fmt.Errorf("@ecdsa.ParseDERSignature(): %v", err)
+		return
+	}
+	publicKey, err := btcec.ParsePubKey(pubKey)
+	if err != nil {
+		err = fmt.Errorf("@btcec.ParsePubKey(): %v", err)
+		return
+	}
+	if !sig.Verify(hash, publicKey) {
+		err = fmt.Errorf("signature does not verify")
+		return
+	}
+	return
+}
+
+// isP2WPKHRedeem reports whether scriptSig pushes a redeemScript of P2SH-P2WPKH
+func isP2WPKHRedeem(scriptSig []byte) bool {
+	pushes := pushedData(scriptSig)
+	return len(pushes) == 1 && len(pushes[0]) == 22 && isP2WPKHScript(pushes[0])
+}
+
+// keySignature finds the key of script(P2PKH, P2WPKH or P2SH-P2WPKH) and its signature, in the final scriptSig
+// and witness of a finalized input, otherwise in the partial signatures. nil: none
+func (input psbtInput) keySignature(script []byte) (pubKey []byte, signature []byte) {
+	if input.FinalScriptSig != nil || input.FinalScriptWitness != nil {
+		pushes := input.FinalScriptWitness
+		if len(pushes) == 0 {
+			pushes = pushedData(input.FinalScriptSig)
+		}
+		if len(pushes) != 2 || !isKeyOfScript(pushes[1], script) {
+			return
+		}
+		scriptSig, witness := keySpend(script, pushes[1], pushes[0])
+		if !bytes.Equal(scriptSig, input.FinalScriptSig) || !bytes.Equal(serializeWitness(witness), serializeWitness(input.FinalScriptWitness)) {
+			return
+		}
+		pubKey, signature = pushes[1], pushes[0]
+		return
+	}
+	for pubKeyHex, sig := range input.PartialSigs {
+		key, _ := hex.DecodeString(pubKeyHex)
+		if isKeyOfScript(key, script) {
+			pubKey, signature = key, sig
+		}
+	}
+	return
+}
+
+// finalize verifies the signature of each input for its previous output, and extracts the signed tx.
+// P2PKH, P2WPKH, P2SH-P2WPKH and P2TR(key path) inputs are verified, also when finalized by the signer.
+// Inputs of other scripts, e.g. multisig, must be finalized by the signer, and are taken as they are.
+func (p *psbt) finalize() (signedTx Tx, fee Amount, err error) {
+	scripts := make([][]byte, len(p.Inputs))
+	amounts := make([]Amount, len(p.Inputs))
+	for i := range p.Inputs {
+		output, errO := p.prevOut(i)
+		if errO != nil {
+			err = errO
+			return
+		}
+		scripts[i], amounts[i] = output.ScriptPubKey, output.Value
+		fee += output.Value
+	}
+	for _, output := range p.Tx.Outputs {
+		fee -= output.Value
+	}
+	if fee < 0 {
+		err = fmt.Errorf("outputs over inputs by %s", -fee)
+		return
+	}
+
+	signedTx = p.Tx
+	signedTx.Inputs = append([]TxIn{}, p.Tx.Inputs...)
+	for i, input := range p.Inputs {
+		script := scripts[i]
+		finalized := input.FinalScriptSig != nil || input.FinalScriptWitness != nil
+		switch {
+		case isP2TRScript(script):
+			signature := input.TapKeySig
+			if finalized {
+				if len(input.FinalScriptSig) > 0 || len(input.FinalScriptWitness) != 1 {
+					err = fmt.Errorf("input %d is not finalized by a key path signature", i)
+					return
+				}
+				signature = input.FinalScriptWitness[0]
+			}
+			if len(signature) != 64 {
+				err = fmt.Errorf("no key path signature of SIGHASH_DEFAULT for input %d", i)
+				return
+			}
+			hash, errH := signedTx.taprootSigHash(i, scripts, amounts, sigHashDefault)
+			if errH != nil {
+				err = errH
+				return
+			}
+			if !schnorrVerify(script[2:], hash, signature) {
+				err = fmt.Errorf("key path signature of input %d does not verify", i)
+				return
+			}
+			signedTx.Inputs[i].ScriptSig = nil
+			signedTx.Inputs[i].Witness = [][]byte{signature}
+		case isP2WPKHScript(script), isP2PKHScript(script), isP2SHScript(script):
+			pubKey, signature := input.keySignature(script)
+			if pubKey == nil {
+				if finalized && isP2SHScript(script) && !isP2WPKHRedeem(input.FinalScriptSig) {
+					// P2SH of another script
+					signedTx.Inputs[i].ScriptSig = input.FinalScriptSig
+					signedTx.Inputs[i].Witness = input.FinalScriptWitness
+					continue
+				}
+				if finalized {
+					err = fmt.Errorf("final scriptSig or witness of input %d is not of the key of script %x", i, script)
+					return
+				}
+				err = fmt.Errorf("no signature for the key of input %d", i)
+				return
+			}
+			var hash []byte
+			var errH error
+			if isP2PKHScript(script) {
+				hash, errH = signedTx.legacySigHash(i, script, sigHashAll)
+			} else {
+				hash, errH = signedTx.witnessV0SigHash(i, p2pkhScript(hash160(pubKey)), amounts[i], sigHashAll)
+			}
+			if errH != nil {
+				err = errH
+				return
+			}
+			if err = verifyECDSA(pubKey, signature, hash); err != nil {
+				err = fmt.Errorf("signature of input %d: %v", i, err)
+				return
+			}
+			signedTx.Inputs[i].ScriptSig, signedTx.Inputs[i].Witness = keySpend(script, pubKey, signature)
+		default:
+			if !finalized {
+				err = fmt.Errorf("input %d of script %x is not finalized by the signer", i, script)
+				return
+			}
+			signedTx.Inputs[i].ScriptSig = input.FinalScriptSig
+			signedTx.Inputs[i].Witness = input.FinalScriptWitness
+		}
+	}
+	return
+}
+
+// importPsbt finalizes a signed psbt of exportedRawTx("": any) into the signed tx, and its fee from the previous outputs
+func importPsbt(signedPsbt string, exportedRawTx string) (rawTx string, signedRawTx string, fee Amount, vSize int, err error) {
+	p, err := decodePsbt(signedPsbt)
+	if err != nil {
+		err = fmt.Errorf("@decodePsbt(): %v", err)
+		return
+	}
+	rawTx, err = p.Tx.Hex()
+	if err != nil {
+		return
+	}
+	if exportedRawTx != "" && rawTx != exportedRawTx {
+		err = fmt.Errorf("unsigned tx of psbt is not the exported one")
+		return
+	}
+	signedTx, fee, err := p.finalize()
+	if err != nil {
+		err = fmt.Errorf("@psbt.finalize(): %v", err)
+		return
+	}
+	signedRawTx, err = signedTx.Hex()
+	if err != nil {
+		return
+	}
+	weight, err := signedTx.Weight()
+	if err != nil {
+		return
+	}
+	vSize = vSizeOfWeight(weight)
+	return
+}
+
+// ExportPsbt lists unspents, selects them and creates the unsigned tx of Run as a base64 psbt for an external signer,
+// with the BIP32 derivations of KeyOrigins. It neither dumps keys nor signs. The psbt signed is sent by BroadcastPsbt.
+func (opReturn *OpReturn) ExportPsbt() (psbtBase64 string, err error) {
+	if err = opReturn.build(true); err != nil {
+		err = fmt.Errorf("@opReturn.build(): %v", err)
+		return
+	}
+
+	p, err := newPsbt(opReturn.bitcoinCli(), opReturn.RawTx, opReturn.Unspents, opReturn.Address)
+	if err != nil {
+		err = fmt.Errorf("@newPsbt(opReturn.RawTx): %v", err)
+		return
+	}
+	if err = p.addKeyOrigins(opReturn.KeyOrigins); err != nil {
+		err = fmt.Errorf("@psbt.addKeyOrigins(): %v", err)
+		return
+	}
+	psbtBase64, err = p.Base64()
+	return
+}
+
+// ImportPsbt finalizes signedPsbt of ExportPsbt into opReturn.SignedRawTx, without broadcasting.
+// opReturn.RawTx "": a psbt exported before a restart, taken as it is.
+func (opReturn *OpReturn) ImportPsbt(signedPsbt string) (err error) {
+	rawTx, signedRawTx, fee, vSize, err := importPsbt(signedPsbt, opReturn.RawTx)
+	if err != nil {
+		err = fmt.Errorf("@importPsbt(): %v", err)
+		return
+	}
+	opReturn.RawTx = rawTx
+	opReturn.SignedRawTx = signedRawTx
+	opReturn.Fee = fee
+	opReturn.VSize = vSize
+	opReturn.EffectiveFeePerVByte = float64(fee) / float64(vSize)
+	return
+}
+
+// BroadcastPsbt finalizes signedPsbt of ExportPsbt and sends it.
+func (opReturn *OpReturn) BroadcastPsbt(signedPsbt string) (err error) {
+	if err = opReturn.ImportPsbt(signedPsbt); err != nil {
+		err = fmt.Errorf("@opReturn.ImportPsbt(): %v", err)
+		return
+	}
+	if err = opReturn.Broadcast(); err != nil {
+		err = fmt.Errorf("@opReturn.Broadcast(): %v", err)
+		return
+	}
+	return
+}
+
+// ExportPsbt lists unspents, selects them and creates the unsigned tx of Run as a base64 psbt for an external signer,
+// with the BIP32 derivations of KeyOrigins. It neither dumps keys nor signs. The psbt signed is sent by BroadcastPsbt.
+func (payment *Payment) ExportPsbt() (psbtBase64 string, err error) {
+	if err = payment.build(); err != nil {
+		err = fmt.Errorf("@payment.build(): %v", err)
+		return
+	}
+
+	p, err := newPsbt(payment.bitcoinCli(), payment.RawTx, payment.Unspents, payment.Address)
+	if err != nil {
+		err = fmt.Errorf("@newPsbt(payment.RawTx): %v", err)
+		return
+	}
+	if err = p.addKeyOrigins(payment.KeyOrigins); err != nil {
+		err = fmt.Errorf("@psbt.addKeyOrigins(): %v", err)
+		return
+	}
+	psbtBase64, err = p.Base64()
+	return
+}
+
+// ImportPsbt finalizes signedPsbt of ExportPsbt into payment.SignedRawTx, without broadcasting.
+// payment.RawTx "": a psbt exported before a restart, taken as it is.
+func (payment *Payment) ImportPsbt(signedPsbt string) (err error) {
+	rawTx, signedRawTx, fee, _, err := importPsbt(signedPsbt, payment.RawTx)
+	if err != nil {
+		err = fmt.Errorf("@importPsbt(): %v", err)
+		return
+	}
+	payment.RawTx = rawTx
+	payment.SignedRawTx = signedRawTx
+	payment.Fee = fee
+	return
+}
+
+// BroadcastPsbt finalizes signedPsbt of ExportPsbt and sends it.
+func (payment *Payment) BroadcastPsbt(signedPsbt string) (err error) {
+	if err = payment.ImportPsbt(signedPsbt); err != nil {
+		err = fmt.Errorf("@payment.ImportPsbt(): %v", err)
+		return
+	}
+	payment.PaymentTxID, err = payment.bitcoinCli().SendRawTransaction(payment.SignedRawTx)
+	if err != nil {
+		err = fmt.Errorf("@bitcoinCli.SendRawTransaction(payment.SignedRawTx): %v", err)
+		return
+	}
+	if payment.PaymentTxID == "" {
+		err = fmt.Errorf("SendRawTransaction(payment.SignedRawTx): rejected, no txid")
+		return
+	}
+	return
+}
@@ -0,0 +1,395 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testFinalizePsbt finalizes every input of signedPsbt of testSignPsbt, as signers such as walletprocesspsbt do
+func testFinalizePsbt(t *testing.T, signedPsbt string) (finalizedPsbt string) {
+	p, err := decodePsbt(signedPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedTx, _, err := p.finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range signedTx.Inputs {
+		p.Inputs[i] = psbtInput{WitnessUtxo: p.Inputs[i].WitnessUtxo, NonWitnessUtxo: p.Inputs[i].NonWitnessUtxo,
+			FinalScriptSig: input.ScriptSig, FinalScriptWitness: input.Witness}
+	}
+	finalizedPsbt, err = p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// testSignPsbt is an external signer of testWIF, adding partial signatures without finalizing
+func testSignPsbt(t *testing.T, unsignedPsbt string) (signedPsbt string) {
+	p, err := decodePsbt(unsignedPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := make([][]byte, len(p.Inputs))
+	amounts := make([]Amount, len(p.Inputs))
+	for i := range p.Inputs {
+		output, err := p.prevOut(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts[i], amounts[i] = output.ScriptPubKey, output.Value
+	}
+	key, _ := decodeWIF(testWIF)
+	tx := p.Tx
+	tx.Inputs = append([]TxIn{}, p.Tx.Inputs...)
+	if err = tx.signInputs(scripts, amounts, key); err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(key.pubKey())
+	for i, input := range tx.Inputs {
+		switch {
+		case isP2TRScript(scripts[i]):
+			p.Inputs[i].TapKeySig = input.Witness[0]
+		case isP2WPKHScript(scripts[i]), isP2SHScript(scripts[i]):
+			p.Inputs[i].PartialSigs = map[string][]byte{pubKey: input.Witness[0]}
+		default:
+			p.Inputs[i].PartialSigs = map[string][]byte{pubKey: input.ScriptSig[1 : 1+input.ScriptSig[0]]}
+		}
+	}
+	signedPsbt, err = p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestOpReturnExportPsbt(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent":        testListUnspent,
+		"sendrawtransaction": `"newtxid"`,
+	})
+	defer bitcoind.Close()
+
+	opReturn := OpReturn{
+		Address:                 "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Message:                 "hello",
+		FeeEstimator:            StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeeSatsPerVByteMax: 100,
+	}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+
+	unsignedPsbt, err := opReturn.ExportPsbt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(unsignedPsbt, "cHNidP8") {
+		t.Errorf("psbt: %s", unsignedPsbt)
+	}
+	p, err := decodePsbt(unsignedPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txHex, _ := p.Tx.Hex(); txHex != opReturn.RawTx || len(p.Inputs) != 1 {
+		t.Errorf("unsigned tx: %s", txHex)
+	}
+	if utxo := p.Inputs[0].WitnessUtxo; utxo == nil || utxo.Value != 50000 || hex.EncodeToString(utxo.ScriptPubKey) != testScriptPubKey {
+		t.Errorf("witness utxo: %+v", utxo)
+	}
+	for _, method := range []string{"dumpprivkey", "signrawtransactionwithkey", "sendrawtransaction"} {
+		if bitcoind.called(method) {
+			t.Errorf("ExportPsbt() called '%s'", method)
+		}
+	}
+
+	// not signed
+	if err = opReturn.BroadcastPsbt(unsignedPsbt); err == nil {
+		t.Errorf("BroadcastPsbt() of the unsigned psbt: no error")
+	}
+
+	signedPsbt := testSignPsbt(t, unsignedPsbt)
+	if err = opReturn.BroadcastPsbt(signedPsbt); err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.OpRetrunTxID != "newtxid" || opReturn.VSize != 126 || opReturn.Fee != 1255 {
+		t.Errorf("opReturn: %+v", opReturn)
+	}
+	// the same tx as signed in process
+	expected, err := signRawTx(opReturn.RawTx, opReturn.Unspents, opReturn.Address, testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.SignedRawTx != expected {
+		t.Errorf("signedRawTx: %s\nwant: %s", opReturn.SignedRawTx, expected)
+	}
+	if bitcoind.called("dumpprivkey") {
+		t.Errorf("dumpprivkey called")
+	}
+
+	// a psbt of another tx
+	other := OpReturn{RawTx: strings.Replace(opReturn.RawTx, testTxIDA, testTxIDB, 1)}
+	if err = other.ImportPsbt(signedPsbt); err == nil || !strings.Contains(err.Error(), "not the exported one") {
+		t.Errorf("ImportPsbt() of another tx: %v", err)
+	}
+	// after a restart
+	restarted := OpReturn{}
+	if err = restarted.ImportPsbt(signedPsbt); err != nil || restarted.SignedRawTx != expected || restarted.RawTx != opReturn.RawTx {
+		t.Errorf("ImportPsbt() after a restart: %v", err)
+	}
+
+	// a signature of another tx
+	tampered, _ := decodePsbt(signedPsbt)
+	for pubKey, signature := range tampered.Inputs[0].PartialSigs {
+		signature = append([]byte{}, signature...)
+		signature[10] ^= 0x01
+		tampered.Inputs[0].PartialSigs[pubKey] = signature
+	}
+	tamperedPsbt, _ := tampered.Base64()
+	if err = restarted.ImportPsbt(tamperedPsbt); err == nil {
+		t.Errorf("ImportPsbt() of a tampered signature: no error")
+	}
+}
+
+func TestPaymentExportPsbtLegacy(t *testing.T) {
+	p2pkh := "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH" // of testWIF
+	script, _ := AddressScript(p2pkh)
+	prevTx := Tx{
+		Version:  2,
+		Inputs:   []TxIn{{PrevTxID: testTxIDA, Vout: 0, Sequence: 0xffffffff}},
+		Outputs:  []TxOut{{Value: 1000, ScriptPubKey: []byte{opReturn}}, {Value: 70000, ScriptPubKey: script}},
+		LockTime: 0,
+	}
+	prevTxID, _ := prevTx.TxID()
+	prevTxHex, _ := prevTx.Hex()
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent":        `[{"txid":"` + prevTxID + `","vout":1,"address":"` + p2pkh + `","amount":0.0007,"confirmations":10}]`,
+		"getrawtransaction":  `"` + prevTxHex + `"`,
+		"sendrawtransaction": `"paymenttxid"`,
+	})
+	defer bitcoind.Close()
+
+	recipient := "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3"
+	payment := Payment{
+		Address:             p2pkh,
+		PayInfos:            map[string]Amount{recipient: 20000},
+		FeeEstimator:        StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeePerVByteMax: 100,
+	}
+	payment.RpcConnect, payment.RpcPort = bitcoind.connectPort()
+
+	unsignedPsbt, err := payment.ExportPsbt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := decodePsbt(unsignedPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(p.Inputs[0].NonWitnessUtxo) != prevTxHex || p.Inputs[0].WitnessUtxo != nil {
+		t.Errorf("input: %+v", p.Inputs[0])
+	}
+
+	if err = payment.BroadcastPsbt(testSignPsbt(t, unsignedPsbt)); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeTx(payment.SignedRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.PaymentTxID != "paymenttxid" || tx.HasWitness() || len(tx.Inputs[0].ScriptSig) == 0 || payment.Fee != payment.SelectionReport.Fee {
+		t.Errorf("payment: %+v", payment)
+	}
+
+	// rejected without an error, no txid
+	bitcoind.results["sendrawtransaction"] = `""`
+	if err = payment.BroadcastPsbt(testSignPsbt(t, unsignedPsbt)); err == nil || !strings.Contains(err.Error(), "no txid") {
+		t.Errorf("expected an error of no txid: %v", err)
+	}
+	bitcoind.results["sendrawtransaction"] = `"paymenttxid"`
+
+	// the previous tx not of the input
+	p.Inputs[0].NonWitnessUtxo, _ = hex.DecodeString(testGenesisTx)
+	if _, _, err = p.finalize(); err == nil {
+		t.Errorf("finalize() with another previous tx: no error")
+	}
+}
+
+func TestPsbtTaproot(t *testing.T) {
+	address, _ := TaprootAddress(testWIF, "bc")
+	unspents := []Unspent{{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true}}
+	rawTx, err := createRawTx(unspents, map[string]Amount{address: 48000}, "68656c6c6f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no previous tx for P2TR
+	p, err := newPsbt((&OpReturn{}).bitcoinCli(), rawTx, unspents, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedPsbt, _ := p.Base64()
+	_, signedRawTx, fee, vSize, err := importPsbt(testSignPsbt(t, unsignedPsbt), rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := DecodeTx(signedRawTx)
+	if fee != 2000 || vSize != 127 || len(tx.Inputs[0].Witness) != 1 || len(tx.Inputs[0].Witness[0]) != 64 {
+		t.Errorf("fee: %s, vsize: %d, tx: %+v", fee, vSize, tx)
+	}
+}
+
+func TestPsbtKeyOrigins(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent": testListUnspent,
+	})
+	defer bitcoind.Close()
+
+	origin := KeyOrigin{Fingerprint: "d90c6a4f", Path: "m/84h/0h/0h/1/3", PubKey: testPubKey1}
+	opReturn := OpReturn{
+		Address:                 "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Message:                 "hello",
+		FeeEstimator:            StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeeSatsPerVByteMax: 100,
+	}
+	opReturn.KeyOrigins = map[string]KeyOrigin{opReturn.Address: origin}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+
+	unsignedPsbt, err := opReturn.ExportPsbt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := decodePsbt(unsignedPsbt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := KeyOrigin{Fingerprint: "d90c6a4f", Path: "m/84'/0'/0'/1/3", PubKey: testPubKey1}
+	if derivations := p.Inputs[0].Bip32Derivations; len(derivations) != 1 || derivations[0] != want {
+		t.Errorf("derivations of input: %+v", derivations)
+	}
+	// change, then OP_RETURN
+	if derivations := p.Outputs[0].Bip32Derivations; len(derivations) != 1 || derivations[0] != want {
+		t.Errorf("derivations of change: %+v", derivations)
+	}
+	if len(p.Outputs) != 2 || len(p.Outputs[1].Bip32Derivations) != 0 {
+		t.Errorf("outputs: %+v", p.Outputs)
+	}
+	if encoded, _ := p.Base64(); encoded != unsignedPsbt {
+		t.Errorf("encoded again: %s\nwant: %s", encoded, unsignedPsbt)
+	}
+	// kept by the signer
+	if err = opReturn.ImportPsbt(testSignPsbt(t, unsignedPsbt)); err != nil {
+		t.Fatal(err)
+	}
+
+	// x-only internal key of P2TR
+	address, _ := TaprootAddress(testWIF, "bc")
+	unspents := []Unspent{{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true}}
+	rawTx, _ := createRawTx(unspents, map[string]Amount{address: 48000}, "")
+	p, err = newPsbt((&OpReturn{}).bitcoinCli(), rawTx, unspents, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tapOrigin := KeyOrigin{Fingerprint: "d90c6a4f", Path: "m/86'/0'/0'/0/0", PubKey: testPubKey1[2:]}
+	if err = p.addKeyOrigins(map[string]KeyOrigin{address: tapOrigin}); err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := p.Base64()
+	if p, err = decodePsbt(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if derivations := p.Inputs[0].TapBip32Derivations; len(derivations) != 1 || derivations[0] != tapOrigin ||
+		hex.EncodeToString(p.Inputs[0].TapInternalKey) != tapOrigin.PubKey {
+		t.Errorf("taproot input: %+v", p.Inputs[0])
+	}
+	if derivations := p.Outputs[0].TapBip32Derivations; len(derivations) != 1 || derivations[0] != tapOrigin ||
+		hex.EncodeToString(p.Outputs[0].TapInternalKey) != tapOrigin.PubKey {
+		t.Errorf("taproot output: %+v", p.Outputs[0])
+	}
+
+	// keys not of the address
+	for _, wrong := range []KeyOrigin{
+		{Fingerprint: "d90c6a4f", Path: "m/86'/0'/0'/0/0", PubKey: testPubKey1},
+		{Fingerprint: "d90c6a4f", Path: "m/86'/0'/0'/0/0", PubKey: "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"},
+		{Fingerprint: "d90c6a", Path: "m/86'/0'/0'/0/0", PubKey: testPubKey1[2:]},
+		{Fingerprint: "d90c6a4f", Path: "m/86'/x", PubKey: testPubKey1[2:]},
+	} {
+		if err = p.addKeyOrigins(map[string]KeyOrigin{address: wrong}); err == nil {
+			t.Errorf("addKeyOrigins(%+v): no error", wrong)
+		}
+	}
+}
+
+func TestPsbtFinalizedVerified(t *testing.T) {
+	noRpc := (&OpReturn{}).bitcoinCli()
+	taprootAddress, _ := TaprootAddress(testWIF, "bc")
+	for _, address := range []string{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN", taprootAddress} {
+		unspents := []Unspent{{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true}}
+		rawTx, _ := createRawTx(unspents, map[string]Amount{address: 48000}, "")
+		p, err := newPsbt(noRpc, rawTx, unspents, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsignedPsbt, _ := p.Base64()
+		finalizedPsbt := testFinalizePsbt(t, testSignPsbt(t, unsignedPsbt))
+		_, signedRawTx, _, _, err := importPsbt(finalizedPsbt, rawTx)
+		if err != nil {
+			t.Fatalf("finalized input of '%s': %v", address, err)
+		}
+		// the signature of P2TR differs by aux randomness
+		expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+		if address == taprootAddress {
+			signedTx, _ := DecodeTx(signedRawTx)
+			signedTxID, _ := signedTx.TxID()
+			expected, _ = p.Tx.TxID()
+			signedRawTx = signedTxID
+		}
+		if signedRawTx != expected {
+			t.Errorf("finalized input of '%s': %s, want %s", address, signedRawTx, expected)
+		}
+
+		// a signature of another tx, and a witness of another key
+		tampered, _ := decodePsbt(finalizedPsbt)
+		witness := tampered.Inputs[0].FinalScriptWitness
+		signature := append([]byte{}, witness[0]...)
+		signature[10] ^= 0x01
+		tampered.Inputs[0].FinalScriptWitness = append([][]byte{signature}, witness[1:]...)
+		tamperedPsbt, _ := tampered.Base64()
+		if _, _, _, _, err = importPsbt(tamperedPsbt, rawTx); err == nil {
+			t.Errorf("finalized input of '%s' with a tampered signature: no error", address)
+		}
+		if len(witness) == 2 {
+			otherKey, _ := hex.DecodeString("02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5")
+			tampered.Inputs[0].FinalScriptWitness = [][]byte{witness[0], otherKey}
+			tamperedPsbt, _ = tampered.Base64()
+			if _, _, _, _, err = importPsbt(tamperedPsbt, rawTx); err == nil {
+				t.Errorf("finalized input of '%s' by another key: no error", address)
+			}
+		}
+	}
+}
+
+func TestPsbtFinalized(t *testing.T) {
+	// finalized by the signer: P2SH and P2SH-P2WSH multisig, test vector of BIP174
+	finalized := "cHNidP8BAJoCAAAAAljoeiG1ba8MI76OcHBFbDNvfLqlyHV5JPVFiHuyq911AAAAAAD/////g40EJ9DsZQpoqka7CwmK6kQiwHGyyng1Kgd5WdB86h0BAAAAAP////8CcKrwCAAAAAAWABTYXCtx0AYLCcmIauuBXlCZHdoSTQDh9QUAAAAAFgAUAK6pouXw+HaliN9VRuh0LR2HAI8AAAAAAAEAuwIAAAABqtc5MQGL0l+ErkALaISL4J23BurCrBgpi6vucatlb4sAAAAASEcwRAIgWPb8fGoz4bMVSNSByCbAFb0wE1qtQs1neQ2rZtKtJDsCIEoc7SYExnNbY5PltBaR3XiwDwxZQvufdRhW+qk4FX26Af7///8CgPD6AgAAAAAXqRQPuUY0IWlrgsgzryQceMF9295JNIfQ8gonAQAAABepFCnKdPigj4GZlCgYXJe12FLkBj9hh2UAAAABB9oARzBEAiB0AYrUGACXuHMyPAAVcgs2hMyBI4kQSOfbzZtVrWecmQIgc9Npt0Dj61Pc76M4I8gHBRTKVafdlUTxV8FnkTJhEYwBSDBFAiEA9hA4swjcHahlo0hSdG8BV3KTQgjG0kRUOTzZm98iF3cCIAVuZ1pnWm0KArhbFOXikHTYolqbV2C+ooFvZhkQoAbqAUdSIQKVg785rgpgl0etGZrd1jT6YQhVnWxc05tMIYPxq5bgfyEC2rYf9JoU22p9ArDNH7t4/EsYMStbTlTa5Nui+/71NtdSrgABASAAwusLAAAAABepFLf1+vQOPUClpFmx2zU18rcvqSHohwEHIyIAIIwjUxc3Q7WV37Sge3K6jkLjeX2nTof+fZ10l+OyAokDAQjaBABHMEQCIGLrelVhB6fHP0WsSrWh3d9vcHX7EnWWmn84Pv/3hLyyAiAMBdu3Rw2/LwhVfdNWxzJcHtMJE+mWzThAlF2xIijaXwFHMEQCIGX0W6WZi1mif/4ae+0BavHx+Q1Us6qPdFCqX1aiUQO9AiB/ckcDrR7blmgLKEtW1P/LiPf7dZ6rvgiqMPKbhROD0gFHUiEDCJ3BDHrG21T5EymvYXMz2ziM6tDCMfcjN50bmQMLAtwhAjrdkE89bc9Z3bkGsN7iNSm3/7ntUOXoYVGSaGAiHw5zUq4AIgIDqaTDf1mW06ol26xrVwrwZQOUSSlCRgs1R1Ptnuylh3EQ2QxqTwAAAIAAAACABAAAgAAiAgJ/Y5l1fS7/VaE2rQLGhLGDi2VW5fG2s0KCqUtrUAUQlhDZDGpPAAAAgAAAAIAFAACAAA=="
+	network := "0200000000010258e87a21b56daf0c23be8e7070456c336f7cbaa5c8757924f545887bb2abdd7500000000da00473044022074018ad4180097b873323c0015720b3684cc8123891048e7dbcd9b55ad679c99022073d369b740e3eb53dcefa33823c8070514ca55a7dd9544f157c167913261118c01483045022100f61038b308dc1da865a34852746f015772934208c6d24454393cd99bdf2217770220056e675a675a6d0a02b85b14e5e29074d8a25a9b5760bea2816f661910a006ea01475221029583bf39ae0a609747ad199addd634fa6108559d6c5cd39b4c2183f1ab96e07f2102dab61ff49a14db6a7d02b0cd1fbb78fc4b18312b5b4e54dae4dba2fbfef536d752aeffffffff838d0427d0ec650a68aa46bb0b098aea4422c071b2ca78352a077959d07cea1d01000000232200208c2353173743b595dfb4a07b72ba8e42e3797da74e87fe7d9d7497e3b2028903ffffffff0270aaf00800000000160014d85c2b71d0060b09c9886aeb815e50991dda124d00e1f5050000000016001400aea9a2e5f0f876a588df5546e8742d1d87008f000400473044022062eb7a556107a7c73f45ac4ab5a1dddf6f7075fb1275969a7f383efff784bcb202200c05dbb7470dbf2f08557dd356c7325c1ed30913e996cd3840945db12228da5f01473044022065f45ba5998b59a27ffe1a7bed016af1f1f90d54b3aa8f7450aa5f56a25103bd02207f724703ad1edb96680b284b56d4ffcb88f7fb759eabbe08aa30f29b851383d20147522103089dc10c7ac6db54f91329af617333db388cead0c231f723379d1b99030b02dc21023add904f3d6dcf59ddb906b0dee23529b7ffb9ed50e5e86151926860221f0e7352ae00000000"
+
+	_, signedRawTx, fee, _, err := importPsbt(finalized, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedRawTx != network || fee != 10000 {
+		t.Errorf("signedRawTx: %s, fee: %s", signedRawTx, fee)
+	}
+	p, _ := decodePsbt(finalized)
+	want := KeyOrigin{Fingerprint: "d90c6a4f", Path: "m/0'/0'/4'", PubKey: "03a9a4c37f5996d3aa25dbac6b570af0650394492942460b354753ed9eeca58771"}
+	if derivations := p.Outputs[0].Bip32Derivations; len(derivations) != 1 || derivations[0] != want {
+		t.Errorf("derivations of output 0: %+v", derivations)
+	}
+
+	for _, encoded := range []string{"", "cHNidP8=", "not base64", finalized[:len(finalized)-8]} {
+		if _, err = decodePsbt(encoded); err == nil {
+			t.Errorf("decodePsbt('%s'): no error", encoded)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+const (
+	defaultPublishQueueInterval = 10 * time.Minute
+	maxBatchChain               = 25 // unconfirmed ancestors bitcoind relays by default(-limitancestorcount)
+)
+
+// DeferredOpReturn is an OpReturn waiting in PublishQueue for its fee window.
+type DeferredOpReturn struct {
+	ID              string
+	Node            string        // name of the node in PublishQueue.Nodes
+	OpReturn        OpReturn      // without the rpc connection, Signer, FeeEstimator and CoinSelector
+	MaxFeePerVByte  float64       // Sats 1, publish once the estimate of its speed level is at or below it, 0: only MaxWait
+	MaxWait         time.Duration // publish at any fee after waiting it, 0: no limit
+	QueuedAt        time.Time
+	LastFeePerVByte float64 // Sats 1, the estimate of the last check
+	SignedRawTx     string  // persisted before broadcasting
+	TxID            string  // "": pending
+	PublishedAt     time.Time
+	Error           string // of the last check or publishing
+}
+
+func (item DeferredOpReturn) Published() bool {
+	return item.TxID != ""
+}
+
+// eligible reports whether item may be published at feePerVByte now
+func (item DeferredOpReturn) eligible(feePerVByte float64, now time.Time) bool {
+	if item.MaxFeePerVByte > 0 && feePerVByte <= item.MaxFeePerVByte {
+		return true
+	}
+	return item.MaxWait > 0 && !now.Before(item.QueuedAt.Add(item.MaxWait))
+}
+
+// batchKey groups items spending from the same address of the same node
+func (item DeferredOpReturn) batchKey() string {
+	return item.Node + " " + item.OpReturn.Address
+}
+
+type PublishQueueState struct {
+	Items     []DeferredOpReturn
+	UpdatedAt time.Time
+}
+
+// PublishQueue defers OP_RETURN writes until the fee estimate of their speed level drops to MaxFeePerVByte,
+// or MaxWait passes, keeping the queue at StatePath across restarts.
+//
+// When the window opens, the eligible items are published in one pass, priced from one fee snapshot,
+// and batched by the node and Address they spend from. bitcoind relays one OP_RETURN output per tx,
+// so a batch is a chain of txs on one selection: the first tx spends the unspents selected for the fees
+// and payments of the whole chain, and each next tx spends the balance output of the previous one.
+type PublishQueue struct {
+	StatePath    string                             // JSON file of PublishQueueState, with node names but no rpc connections, 0600
+	Nodes        map[string]goBitcoinCli.BitcoinRpc // name: rpc connection of the node, not persisted
+	FeeEstimator FeeEstimator                       // nil: DefaultFeeEstimator
+	CoinSelector CoinSelector                       // nil: LargestFirstSelector
+	Signer       Signer                             // nil: AutoSigner, dumpprivkey falling back to the wallet
+	Interval     time.Duration                      // of Run, 0: 10 min
+
+	mutex sync.Mutex
+	now   func() time.Time // nil: time.Now
+}
+
+func (queue *PublishQueue) timeNow() time.Time {
+	if queue.now != nil {
+		return queue.now()
+	}
+	return time.Now()
+}
+
+func (queue *PublishQueue) load() (state PublishQueueState, err error) {
+	if queue.StatePath == "" {
+		err = fmt.Errorf("no StatePath")
+		return
+	}
+	_, err = loadJSON(queue.StatePath, &state)
+	return
+}
+
+func (queue *PublishQueue) save(state *PublishQueueState) (err error) {
+	state.UpdatedAt = queue.timeNow()
+	err = saveJSON(queue.StatePath, state)
+	if err != nil {
+		err = fmt.Errorf("@saveJSON('%s'): %v", queue.StatePath, err)
+		return
+	}
+	return
+}
+
+func newQueueID() (id string, err error) {
+	random := make([]byte, 8)
+	if _, err = rand.Read(random); err != nil {
+		err = fmt.Errorf("@rand.Read(): %v", err)
+		return
+	}
+	id = hex.EncodeToString(random)
+	return
+}
+
+// Enqueue adds opReturn on node of Nodes, to be published at its SpeedLevelFee or ConfirmationTarget
+// once the estimate is at or below maxFeePerVByte, or after maxWait.
+// The rpc connection and Signer of opReturn are not persisted: node of Nodes is called,
+// and Signer of the queue signs when publishing.
+func (queue *PublishQueue) Enqueue(node string, opReturn OpReturn, maxFeePerVByte float64, maxWait time.Duration) (id string, err error) {
+	if maxFeePerVByte <= 0 && maxWait <= 0 {
+		err = fmt.Errorf("neither of maxFeePerVByte nor maxWait is set")
+		return
+	}
+	if _, ok := queue.Nodes[node]; !ok {
+		err = fmt.Errorf("no node '%s' in Nodes", node)
+		return
+	}
+	if opReturn.Address == "" {
+		err = fmt.Errorf("no Address")
+		return
+	}
+	if err = opReturn.SpeedLevelFee.Validate(); err != nil {
+		return
+	}
+	if opReturn.ConfirmationTarget != 0 {
+		if opReturn.SpeedLevelFee != "" {
+			err = fmt.Errorf("both of speed level['%s'] and confirmation target[%d] are set", string(opReturn.SpeedLevelFee), opReturn.ConfirmationTarget)
+			return
+		}
+		if err = validateConfirmationTarget(opReturn.ConfirmationTarget); err != nil {
+			return
+		}
+	}
+	if opReturn.MessageHex == "" {
+		opReturn.MessageHex = ConvertTextToHex(opReturn.Message)
+	}
+	opReturn.RpcUser, opReturn.RpcPW, opReturn.RpcConnect, opReturn.RpcPort, opReturn.RpcPath = "", "", "", "", ""
+	opReturn.Signer = nil
+	opReturn.FeeEstimator = nil
+	opReturn.CoinSelector = nil
+
+	id, err = newQueueID()
+	if err != nil {
+		return
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	state, err := queue.load()
+	if err != nil {
+		return
+	}
+	state.Items = append(state.Items, DeferredOpReturn{
+		ID:             id,
+		Node:           node,
+		OpReturn:       opReturn,
+		MaxFeePerVByte: maxFeePerVByte,
+		MaxWait:        maxWait,
+		QueuedAt:       queue.timeNow(),
+	})
+	err = queue.save(&state)
+	return
+}
+
+// Items returns the pending and the published items, in the order of queueing.
+func (queue *PublishQueue) Items() (items []DeferredOpReturn, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	state, err := queue.load()
+	if err != nil {
+		return
+	}
+	items = state.Items
+	return
+}
+
+// Remove drops the item of id, a pending one is not published.
+func (queue *PublishQueue) Remove(id string) (err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	state, err := queue.load()
+	if err != nil {
+		return
+	}
+	for i, item := range state.Items {
+		if item.ID == id {
+			state.Items = append(state.Items[:i], state.Items[i+1:]...)
+			err = queue.save(&state)
+			return
+		}
+	}
+	err = fmt.Errorf("no item '%s'", id)
+	return
+}
+
+// Step checks the fee of every pending item against one snapshot of FeeEstimator, and publishes the eligible ones.
+// An error of an item is kept in its Error, and the others go on.
+func (queue *PublishQueue) Step() (published []DeferredOpReturn, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	state, err := queue.load()
+	if err != nil {
+		return
+	}
+	pendings := make([]int, 0)
+	for i, item := range state.Items {
+		if !item.Published() {
+			pendings = append(pendings, i)
+		}
+	}
+	if len(pendings) == 0 {
+		return
+	}
+
+	remoteFees, err := feeEstimatorOrDefault(queue.FeeEstimator).EstimateFees()
+	if err != nil {
+		err = fmt.Errorf("@feeEstimator.EstimateFees(): %v", err)
+		return
+	}
+	snapshot := snapshotFeeEstimator(remoteFees)
+
+	// 1. check the fee of each item
+	now := queue.timeNow()
+	batches := make(map[string][]int)
+	batchKeys := make([]string, 0)
+	for _, i := range pendings {
+		item := &state.Items[i]
+		opReturn := item.OpReturn
+		feePerVByte, _, errF := getFeePerVByte3(snapshot, opReturn.LimitFeeSatsPerVByteMin, opReturn.LimitFeeSatsPerVByteMax, opReturn.SpeedLevelFee, opReturn.ConfirmationTarget)
+		if errF != nil {
+			item.Error = fmt.Sprintf("@getFeePerVByte3(): %v", errF)
+			continue
+		}
+		item.LastFeePerVByte = feePerVByte
+		item.Error = ""
+		if item.SignedRawTx == "" && !item.eligible(feePerVByte, now) {
+			continue
+		}
+		key := item.batchKey()
+		if _, ok := batches[key]; !ok {
+			batchKeys = append(batchKeys, key)
+		}
+		batches[key] = append(batches[key], i)
+	}
+	sort.Strings(batchKeys)
+
+	// 2. publish the batches, each tx after persisting it. A signed tx persisted by a stopped process
+	// is broadcast again instead of making another one.
+	for _, key := range batchKeys {
+		newItems := make([]*DeferredOpReturn, 0)
+		for _, i := range batches[key] {
+			item := &state.Items[i]
+			if item.SignedRawTx == "" {
+				newItems = append(newItems, item)
+				continue
+			}
+			opReturn, errO := queue.opReturnOf(item, snapshot)
+			if errO == nil {
+				errO = queue.broadcast(item, opReturn.bitcoinCli())
+			}
+			if errO != nil {
+				item.Error = errO.Error()
+				continue
+			}
+			published = append(published, *item)
+		}
+		published = append(published, queue.publishBatch(&state, newItems, snapshot)...)
+	}
+	err = queue.save(&state)
+	return
+}
+
+// opReturnOf is item.OpReturn on its node, priced at snapshot, selected by CoinSelector and signed by Signer of the queue
+func (queue *PublishQueue) opReturnOf(item *DeferredOpReturn, snapshot snapshotFeeEstimator) (opReturn OpReturn, err error) {
+	node, ok := queue.Nodes[item.Node]
+	if !ok {
+		err = fmt.Errorf("no node '%s' in Nodes", item.Node)
+		return
+	}
+	opReturn = item.OpReturn
+	opReturn.RpcUser, opReturn.RpcPW, opReturn.RpcConnect, opReturn.RpcPort, opReturn.RpcPath = node.RpcUser, node.RpcPW, node.RpcConnect, node.RpcPort, node.RpcPath
+	opReturn.FeeEstimator = snapshot
+	opReturn.CoinSelector = queue.CoinSelector
+	opReturn.Signer = queue.Signer
+	// copy, not to persist balance-pay-info
+	opReturn.PayInfos = make(map[string]Amount)
+	for address, amount := range item.OpReturn.PayInfos {
+		opReturn.PayInfos[address] = amount
+	}
+	return
+}
+
+// chainReserve is what the txs of items chained on a balance output spend: their payments,
+// and their fees at LastFeePerVByte, or Fee
+func chainReserve(items []*DeferredOpReturn) (reserve Amount) {
+	for _, item := range items {
+		opReturn := item.OpReturn
+		outputAddresses := []string{opReturn.Address}
+		for address, amount := range opReturn.PayInfos {
+			reserve += amount
+			outputAddresses = append(outputAddresses, address)
+		}
+		if opReturn.Fee > 0 {
+			reserve += opReturn.Fee
+			continue
+		}
+		vBytes := estimateVBytes([]string{opReturn.Address}, outputAddresses, []int{len(opReturn.MessageHex) / 2})
+		reserve += Amount(math.Ceil(vBytes * item.LastFeePerVByte))
+	}
+	return
+}
+
+// balanceUnspent is the balance output of the signed tx of opReturn, to be spent by the next tx of a chain. nil: no balance
+func balanceUnspent(opReturn OpReturn) (unspent *Unspent, err error) {
+	if opReturn.AmountBalanceUsedUnspends <= 0 {
+		return
+	}
+	tx, err := DecodeTx(opReturn.SignedRawTx)
+	if err != nil {
+		err = fmt.Errorf("@DecodeTx(opReturn.SignedRawTx): %v", err)
+		return
+	}
+	txID, err := tx.TxID()
+	if err != nil {
+		return
+	}
+	script, err := AddressScript(opReturn.Address)
+	if err != nil {
+		err = fmt.Errorf("@AddressScript('%s'): %v", opReturn.Address, err)
+		return
+	}
+	for vout, output := range tx.Outputs {
+		if bytes.Equal(output.ScriptPubKey, script) {
+			unspent = &Unspent{TxID: txID, Vout: vout, Amount: output.Value}
+			return
+		}
+	}
+	err = fmt.Errorf("no balance output of '%s' in tx '%s'", opReturn.Address, txID)
+	return
+}
+
+// publishBatch publishes new items of one node and Address as chains of up to maxBatchChain txs on one selection.
+// A failure stops the chain, and the rest waits for the next Step.
+func (queue *PublishQueue) publishBatch(state *PublishQueueState, items []*DeferredOpReturn, snapshot snapshotFeeEstimator) (published []DeferredOpReturn) {
+	var balance *Unspent // of the last tx of the chain, nil: the next tx selects from listunspent
+	for k, item := range items {
+		if k%maxBatchChain == 0 {
+			balance = nil
+		}
+		chainEnd := (k/maxBatchChain + 1) * maxBatchChain
+		if chainEnd > len(items) {
+			chainEnd = len(items)
+		}
+
+		opReturn, err := queue.opReturnOf(item, snapshot)
+		if err != nil {
+			item.Error = err.Error()
+			return
+		}
+		opReturn.chainReserve = chainReserve(items[k+1 : chainEnd])
+		if balance == nil {
+			err = opReturn.prepare(true)
+		} else {
+			opReturn.Unspents = []Unspent{*balance}
+			opReturn.Confirmations = 0
+			err = opReturn.prepare(false)
+		}
+		if err != nil {
+			item.Error = fmt.Sprintf("@opReturn.prepare(): %v", err)
+			return
+		}
+		item.SignedRawTx = opReturn.SignedRawTx
+		if err = queue.save(state); err != nil {
+			item.SignedRawTx = ""
+			item.Error = err.Error()
+			return
+		}
+		if err = queue.broadcast(item, opReturn.bitcoinCli()); err != nil {
+			item.Error = err.Error()
+			return
+		}
+		published = append(published, *item)
+
+		if balance, err = balanceUnspent(opReturn); err != nil {
+			balance = nil // the next tx starts another chain
+		}
+	}
+	return
+}
+
+// broadcast sends the signed tx of item, persisted before
+func (queue *PublishQueue) broadcast(item *DeferredOpReturn, bitcoinCli goBitcoinCli.BitcoinRpc) (err error) {
+	txID, err := sendSignedRawTx(bitcoinCli, item.SignedRawTx)
+	if err != nil {
+		return
+	}
+	confirmations, inMempool, err := txStatus(bitcoinCli, txID)
+	if err != nil {
+		return
+	}
+	if confirmations == 0 && !inMempool {
+		// rejected, its inputs are spent or its fee is too low: made again at the next check
+		item.SignedRawTx = ""
+		err = fmt.Errorf("tx '%s' is not accepted by the node", txID)
+		return
+	}
+	item.TxID = txID
+	item.PublishedAt = queue.timeNow()
+	return
+}
+
+// Run steps every Interval until ctx is done. Errors of a step are given to onError, and Run goes on.
+func (queue *PublishQueue) Run(ctx context.Context, onError func(err error)) (err error) {
+	interval := queue.Interval
+	if interval <= 0 {
+		interval = defaultPublishQueueInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, errS := queue.Step(); errS != nil && onError != nil {
+			onError(errS)
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
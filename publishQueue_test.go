@@ -0,0 +1,199 @@
+package gobitcoinopreturn
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+func newTestQueueBitcoind() *testBitcoind {
+	return newTestBitcoind(map[string]string{
+		"listunspent":        testListUnspent,
+		"dumpprivkey":        `"` + testWIF + `"`,
+		"sendrawtransaction": `"newtxid"`,
+		"getmempoolentry":    `{"vsize":126}`,
+	})
+}
+
+const testQueueRpcPW = "queue-rpc-password"
+
+// testQueueNodes is the node "node" of bitcoind
+func testQueueNodes(bitcoind *testBitcoind) map[string]goBitcoinCli.BitcoinRpc {
+	node := goBitcoinCli.BitcoinRpc{RpcUser: "user", RpcPW: testQueueRpcPW}
+	node.RpcConnect, node.RpcPort = bitcoind.connectPort()
+	return map[string]goBitcoinCli.BitcoinRpc{"node": node}
+}
+
+func testQueuedOpReturn(bitcoind *testBitcoind, message string) (opReturn OpReturn) {
+	opReturn = OpReturn{
+		Address:                 "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Signer:                  testWIFSigner(),
+		Message:                 message,
+		SpeedLevelFee:           SpeedLevel2,
+		LimitFeeSatsPerVByteMin: 1,
+		LimitFeeSatsPerVByteMax: 100,
+	}
+	opReturn.RpcUser, opReturn.RpcPW = "user", testQueueRpcPW
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	return
+}
+
+func TestPublishQueueStep(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+
+	now := time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)
+	statePath := filepath.Join(t.TempDir(), "queue.json")
+	queue := &PublishQueue{
+		StatePath:    statePath,
+		Nodes:        testQueueNodes(bitcoind),
+		FeeEstimator: testFeeEstimator{FastestFee: 40, HalfHourFee: 30, HourFee: 20, EconomyFee: 10, MinimumFee: 2},
+		now:          func() time.Time { return now },
+	}
+
+	waiting, err := queue.Enqueue("node", testQueuedOpReturn(bitcoind, "waiting"), 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheap, err := queue.Enqueue("node", testQueuedOpReturn(bitcoind, "cheap"), 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdue, err := queue.Enqueue("node", testQueuedOpReturn(bitcoind, "overdue"), 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = queue.Enqueue("node", testQueuedOpReturn(bitcoind, "never"), 0, 0); err == nil {
+		t.Errorf("Enqueue() without maxFeePerVByte nor maxWait: no error")
+	}
+	if _, err = queue.Enqueue("other", testQueuedOpReturn(bitcoind, "other"), 5, 0); err == nil {
+		t.Errorf("Enqueue() on an unknown node: no error")
+	}
+
+	now = now.Add(2 * time.Hour)
+	published, err := queue.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 || published[0].ID != cheap || published[1].ID != overdue {
+		t.Fatalf("published: %+v", published)
+	}
+
+	data, _ := os.ReadFile(statePath)
+	_, rpcPort := bitcoind.connectPort()
+	for _, secret := range []string{testWIF, testQueueRpcPW, rpcPort} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("'%s' in the state file", secret)
+		}
+	}
+
+	// one batch on one selection: overdue spends the balance output of cheap
+	listed := 0
+	for _, method := range bitcoind.methods {
+		if method == "listunspent" {
+			listed++
+		}
+	}
+	first, _ := DecodeTx(published[0].SignedRawTx)
+	second, _ := DecodeTx(published[1].SignedRawTx)
+	firstTxID, _ := first.TxID()
+	if listed != 1 || len(second.Inputs) != 1 || second.Inputs[0].PrevTxID != firstTxID || second.Inputs[0].Vout != 0 {
+		t.Errorf("listunspent %d times, second inputs: %+v, first: %s", listed, second.Inputs, firstTxID)
+	}
+	// the balance of the first keeps the fee of the second
+	if len(second.Outputs) != 2 || second.Outputs[0].Value >= first.Outputs[0].Value || first.Outputs[0].Value-second.Outputs[0].Value < 126*10 {
+		t.Errorf("first outputs: %+v, second outputs: %+v", first.Outputs, second.Outputs)
+	}
+
+	// from the file, as after a restart
+	items, err := (&PublishQueue{StatePath: statePath}).Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("items: %+v", items)
+	}
+	for _, item := range items {
+		if item.OpReturn.Signer != nil {
+			t.Errorf("Signer of '%s' is persisted", item.ID)
+		}
+		switch item.ID {
+		case waiting:
+			if item.Published() || item.LastFeePerVByte != 10 || item.SignedRawTx != "" {
+				t.Errorf("waiting: %+v", item)
+			}
+		default:
+			if item.TxID != "newtxid" || !item.PublishedAt.Equal(now) || item.SignedRawTx == "" {
+				t.Errorf("published: %+v", item)
+			}
+		}
+	}
+
+	if err = queue.Remove(waiting); err != nil {
+		t.Fatal(err)
+	}
+	if err = queue.Remove(waiting); err == nil {
+		t.Errorf("Remove() of a removed item: no error")
+	}
+}
+
+func TestPublishQueueStepResumesSignedTx(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+
+	queue := &PublishQueue{
+		StatePath:    filepath.Join(t.TempDir(), "queue.json"),
+		Nodes:        testQueueNodes(bitcoind),
+		FeeEstimator: StaticFeeEstimator{FeePerVByte: 50},
+	}
+	// signed and persisted, then stopped before broadcasting: not eligible at 50 any more
+	state := PublishQueueState{Items: []DeferredOpReturn{
+		{ID: "signed", Node: "node", OpReturn: OpReturn{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, MaxFeePerVByte: 10, SignedRawTx: testSignedRawTx},
+	}}
+	if err := queue.save(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := queue.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 || published[0].TxID != "newtxid" {
+		t.Errorf("published: %+v", published)
+	}
+	if bitcoind.called("listunspent") || bitcoind.called("dumpprivkey") {
+		t.Errorf("made again: %v", bitcoind.methods)
+	}
+}
+
+func TestPublishQueueStepRejected(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+	delete(bitcoind.results, "getmempoolentry")
+
+	queue := &PublishQueue{
+		StatePath:    filepath.Join(t.TempDir(), "queue.json"),
+		Nodes:        testQueueNodes(bitcoind),
+		FeeEstimator: StaticFeeEstimator{FeePerVByte: 5},
+	}
+	id, err := queue.Enqueue("node", testQueuedOpReturn(bitcoind, "rejected"), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := queue.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := queue.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 0 || items[0].ID != id || items[0].Published() || items[0].SignedRawTx != "" || items[0].Error == "" {
+		t.Errorf("published: %+v, items: %+v", published, items)
+	}
+}
@@ -0,0 +1,169 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quote is what a Run would send, before dumping keys, signing and broadcasting.
+type Quote struct {
+	Inputs          []Unspent
+	Outputs         map[string]Amount // pay-infos with balance-pay-info
+	MessageHex      string            // OP_RETURN data, "": Payment
+	Change          Amount
+	Fee             Amount
+	FeePerVByte     float64 // Sats 1
+	EstimatedVSize  int     // vbytes of the tx once signed
+	RawTx           string  // unsigned
+	SelectionReport SelectionReport
+}
+
+func newQuote(report SelectionReport, payInfos map[string]Amount, opReturnSizes []int, address string, rawTx string) (quote Quote) {
+	quote.Inputs = report.Inputs
+	quote.Outputs = payInfos
+	quote.Change = report.Change
+	quote.Fee = report.Fee
+	quote.FeePerVByte = report.FeePerVByte
+	quote.RawTx = rawTx
+	quote.SelectionReport = report
+
+	params := SelectionParams{Address: address}
+	inputAddresses := make([]string, 0)
+	for _, unspent := range report.Inputs {
+		inputAddresses = append(inputAddresses, params.inputAddress(unspent))
+	}
+	outputAddresses := make([]string, 0)
+	for tAddress := range payInfos {
+		outputAddresses = append(outputAddresses, tAddress)
+	}
+	quote.EstimatedVSize = int(math.Ceil(estimateVBytes(inputAddresses, outputAddresses, opReturnSizes)))
+	return
+}
+
+// Quote lists unspents, selects them, estimates the fee and creates the unsigned tx of Run, on a copy of opReturn.
+func (opReturn *OpReturn) Quote() (quote Quote, err error) {
+	quoted := *opReturn
+
+	// copy, selection adds balance-pay-info
+	quoted.PayInfos = make(map[string]Amount)
+	for address, amount := range opReturn.PayInfos {
+		quoted.PayInfos[address] = amount
+	}
+
+	if err = quoted.build(true); err != nil {
+		err = fmt.Errorf("@opReturn.build(): %v", err)
+		return
+	}
+
+	quote = newQuote(quoted.SelectionReport, quoted.PayInfos, []int{len(quoted.MessageHex) / 2}, quoted.Address, quoted.RawTx)
+	quote.MessageHex = quoted.MessageHex
+	return
+}
+
+// Quote lists unspents, selects them, estimates the fee and creates the unsigned tx of Run, on a copy of payment.
+func (payment *Payment) Quote() (quote Quote, err error) {
+	quoted := *payment
+
+	// copy, selection adds balance-pay-info and fills the amount of -1
+	quoted.PayInfos = make(map[string]Amount)
+	for address, amount := range payment.PayInfos {
+		quoted.PayInfos[address] = amount
+	}
+
+	if err = quoted.build(); err != nil {
+		err = fmt.Errorf("@payment.build(): %v", err)
+		return
+	}
+
+	quote = newQuote(quoted.SelectionReport, quoted.PayInfos, nil, quoted.Address, quoted.RawTx)
+	return
+}
+
+type SpeedLevelQuote struct {
+	SpeedLevel          SpeedLevel
+	ConfirmWithinBlocks int // blocks of the slower level of RemoteFees which SpeedLevel stands on
+	HasChange           bool
+	Quote                      // without RawTx
+	Error               string // e.g. not sufficient unspents at this level
+}
+
+var speedLevels = []SpeedLevel{SpeedLevel1, SpeedLevel2, SpeedLevel3, SpeedLevel4, SpeedLevel5, SpeedLevel6, SpeedLevel7, SpeedLevel8}
+
+func (speedLevel SpeedLevel) confirmWithinBlocks() (blocks int) {
+	switch speedLevel {
+	case SpeedLevel1:
+		blocks = confTargetMinimumFee
+	case SpeedLevel2, SpeedLevel3:
+		blocks = confTargetEconomyFee
+	case SpeedLevel4, SpeedLevel5:
+		blocks = confTargetHourFee
+	case SpeedLevel6, SpeedLevel7, "":
+		blocks = confTargetHalfHourFee
+	case SpeedLevel8:
+		blocks = confTargetFastestFee
+	}
+	return
+}
+
+// QuoteSpeedLevels selects unspents at every speed level against one fee snapshot of FeeEstimator and one list of unspents,
+// opReturn.Unspents when given, without creating any tx.
+func (opReturn *OpReturn) QuoteSpeedLevels() (quotes []SpeedLevelQuote, err error) {
+	quoted := *opReturn
+	if len(quoted.Unspents) == 0 {
+		if err = quoted.listUnspentsForSend(); err != nil {
+			err = fmt.Errorf("@opReturn.listUnspentsForSend(): %v", err)
+			return
+		}
+	}
+	if quoted.Confirmations <= 0 {
+		quoted.Confirmations = 3
+	}
+	if quoted.MessageHex == "" {
+		quoted.MessageHex = ConvertTextToHex(quoted.Message)
+	}
+	unspents := quoted.Unspents
+
+	remoteFees, err := feeEstimatorOrDefault(opReturn.FeeEstimator).EstimateFees()
+	if err != nil {
+		err = fmt.Errorf("@feeEstimator.EstimateFees(): %v", err)
+		return
+	}
+
+	quotes = make([]SpeedLevelQuote, 0, len(speedLevels))
+	for _, speedLevel := range speedLevels {
+		levelQuote := SpeedLevelQuote{SpeedLevel: speedLevel, ConfirmWithinBlocks: speedLevel.confirmWithinBlocks()}
+
+		// copy for each level, selection marks Expected
+		levelOpReturn := quoted
+		levelOpReturn.Unspents = append([]Unspent{}, unspents...)
+		levelOpReturn.PayInfos = make(map[string]Amount)
+		for address, amount := range opReturn.PayInfos {
+			levelOpReturn.PayInfos[address] = amount
+		}
+
+		feePerVByte, errF := remoteFees.FeePerVByte(opReturn.LimitFeeSatsPerVByteMin, opReturn.LimitFeeSatsPerVByteMax, speedLevel)
+		if errF == nil {
+			errF = levelOpReturn.SelectUnspents(feePerVByte)
+		}
+		if errF != nil {
+			levelQuote.FeePerVByte = feePerVByte
+			levelQuote.Error = errF.Error()
+			quotes = append(quotes, levelQuote)
+			continue
+		}
+		levelOpReturn.SelectionReport.FeeSource = remoteFees.Source
+
+		outputs := make(map[string]Amount)
+		for address, amount := range levelOpReturn.PayInfos {
+			outputs[address] = amount
+		}
+		if levelOpReturn.AmountBalanceUsedUnspends > 0 {
+			outputs[levelOpReturn.Address] = levelOpReturn.AmountBalanceUsedUnspends
+		}
+		levelQuote.Quote = newQuote(levelOpReturn.SelectionReport, outputs, []int{len(levelOpReturn.MessageHex) / 2}, levelOpReturn.Address, "")
+		levelQuote.MessageHex = levelOpReturn.MessageHex
+		levelQuote.HasChange = levelOpReturn.AmountBalanceUsedUnspends > 0
+		quotes = append(quotes, levelQuote)
+	}
+	return
+}
@@ -0,0 +1,270 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBitcoind answers json-rpc methods with results or rpcErrors, and records the called methods
+type testBitcoind struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	results   map[string]string
+	rpcErrors map[string]RpcError // set before calls
+	methods   []string
+	params    map[string]json.RawMessage
+}
+
+func newTestBitcoind(results map[string]string) (bitcoind *testBitcoind) {
+	bitcoind = &testBitcoind{results: results, params: make(map[string]json.RawMessage)}
+	bitcoind.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}{}
+		json.Unmarshal(body, &request)
+
+		bitcoind.mutex.Lock()
+		bitcoind.methods = append(bitcoind.methods, request.Method)
+		bitcoind.params[request.Method] = request.Params
+		result, ok := bitcoind.results[request.Method]
+		rpcError, isError := bitcoind.rpcErrors[request.Method]
+		bitcoind.mutex.Unlock()
+		if isError {
+			errorJson, _ := json.Marshal(rpcError)
+			fmt.Fprintf(w, `{"result":null,"error":%s,"id":"test"}`, errorJson)
+			return
+		}
+		if !ok {
+			switch request.Method {
+			case "getmempoolentry", "getrawtransaction", "gettransaction":
+				// a tx not found, like bitcoind
+				fmt.Fprintf(w, `{"result":null,"error":{"code":-5,"message":"No such mempool or blockchain transaction"},"id":"test"}`)
+			default:
+				fmt.Fprintf(w, `{"result":null,"error":{"code":-32601,"message":"Method not found"},"id":"test"}`)
+			}
+			return
+		}
+		fmt.Fprintf(w, `{"result":%s,"error":null,"id":"test"}`, result)
+	}))
+	return
+}
+
+func (bitcoind *testBitcoind) connectPort() (rpcConnect string, rpcPort string) {
+	hostPort := strings.TrimPrefix(bitcoind.URL, "http://")
+	rpcConnect = hostPort[:strings.LastIndex(hostPort, ":")]
+	rpcPort = hostPort[strings.LastIndex(hostPort, ":")+1:]
+	return
+}
+
+// signedRawTx decodes the signed tx given to sendrawtransaction
+func (bitcoind *testBitcoind) signedRawTx(t *testing.T) (tx Tx) {
+	params := make([]json.RawMessage, 0)
+	rawTx := ""
+	if err := json.Unmarshal(bitcoind.params["sendrawtransaction"], &params); err != nil || len(params) == 0 {
+		t.Fatalf("sendrawtransaction: %s", bitcoind.params["sendrawtransaction"])
+	}
+	if err := json.Unmarshal(params[0], &rawTx); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeTx(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func (bitcoind *testBitcoind) called(method string) bool {
+	bitcoind.mutex.Lock()
+	defer bitcoind.mutex.Unlock()
+	for _, tMethod := range bitcoind.methods {
+		if tMethod == method {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	testTxIDA        = strings.Repeat("aa", 32)
+	testTxIDB        = strings.Repeat("bb", 32)
+	testScriptPubKey = "0014751e76e8199196d454941c45d1b3a323f1433bd6"         // of bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4
+	testWIF          = "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn" // private key 1, of bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4
+	testListUnspent  = `[
+	{"txid":"` + testTxIDA + `","vout":0,"address":"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4","amount":0.0005,"confirmations":10},
+	{"txid":"` + testTxIDB + `","vout":1,"address":"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4","amount":0.0002,"confirmations":10}
+]`
+)
+
+func TestOpReturnQuote(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent": testListUnspent,
+	})
+	defer bitcoind.Close()
+
+	opReturn := OpReturn{
+		Address:                 "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Message:                 "hello",
+		FeeEstimator:            StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeeSatsPerVByteMax: 100,
+	}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+
+	quote, err := opReturn.Quote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 P2WPKH input, balance & OP_RETURN(5 bytes) outputs: 10.5 + 68 + 31 + 16 = 125.5
+	if quote.EstimatedVSize != 126 || quote.Fee != 1255 || quote.FeePerVByte != 10 {
+		t.Errorf("quote: %+v", quote)
+	}
+	if len(quote.Inputs) != 1 || quote.Inputs[0].TxID != testTxIDA || quote.Change != 50000-1255 || quote.Outputs[opReturn.Address] != quote.Change {
+		t.Errorf("quote: %+v", quote)
+	}
+	if quote.MessageHex != ConvertTextToHex("hello") || quote.SelectionReport.FeeSource != "static" {
+		t.Errorf("quote: %+v", quote)
+	}
+	// balance, then OP_RETURN
+	tx, err := DecodeTx(quote.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 1 || tx.Inputs[0].PrevTxID != testTxIDA || tx.Inputs[0].Sequence != sequenceReplaceable || len(tx.Outputs) != 2 ||
+		tx.Outputs[0].Value != quote.Change || hex.EncodeToString(tx.Outputs[1].ScriptPubKey) != "6a0568656c6c6f" {
+		t.Errorf("raw tx: %+v", tx)
+	}
+	for _, method := range []string{"dumpprivkey", "signrawtransactionwithkey", "sendrawtransaction"} {
+		if bitcoind.called(method) {
+			t.Errorf("Quote() called '%s'", method)
+		}
+	}
+	if len(opReturn.PayInfos) != 0 || opReturn.RawTx != "" || opReturn.Fee != 0 {
+		t.Errorf("Quote() changed opReturn: %+v", opReturn)
+	}
+}
+
+func TestOpReturnQuoteWithoutMaxLimit(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent": testListUnspent,
+	})
+	defer bitcoind.Close()
+
+	// LimitFeeSatsPerVByteMax 0: no upper limit, not a fee of 0
+	opReturn := OpReturn{
+		Address:      "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Message:      "hello",
+		FeeEstimator: StaticFeeEstimator{FeePerVByte: 10},
+	}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	quote, err := opReturn.Quote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.FeePerVByte != 10 || quote.Fee != 1255 {
+		t.Errorf("quote: %+v", quote)
+	}
+	quotes, err := opReturn.QuoteSpeedLevels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quote := range quotes {
+		if quote.Fee <= 0 || quote.Error != "" {
+			t.Errorf("%s: %+v", quote.SpeedLevel, quote)
+		}
+	}
+
+	opReturn.Unspents = []Unspent{{TxID: testTxIDA, Amount: 100000, Confirmations: 10}}
+	if err = opReturn.SelectUnspents(0); err == nil || opReturn.Fee > 0 {
+		t.Errorf("SelectUnspents(0): fee[%s], %v", opReturn.Fee, err)
+	}
+	payment := Payment{Address: opReturn.Address, PayInfos: map[string]Amount{"1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT": -1}}
+	payment.Unspents = opReturn.Unspents
+	if err = payment.SelectUnspents(0); err == nil {
+		t.Errorf("sweep SelectUnspents(0): fee[%s]", payment.Fee)
+	}
+}
+
+func TestPaymentQuoteSweep(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent": testListUnspent,
+	})
+	defer bitcoind.Close()
+
+	payment := Payment{
+		Address:             "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		PayInfos:            map[string]Amount{"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3": -1},
+		FeeEstimator:        StaticFeeEstimator{FeePerVByte: 10},
+		LimitFeePerVByteMax: 100,
+	}
+	payment.RpcConnect, payment.RpcPort = bitcoind.connectPort()
+
+	quote, err := payment.Quote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 P2WPKH inputs, 1 P2WSH output: 10.5 + 136 + 43 = 189.5
+	if quote.EstimatedVSize != 190 || quote.Fee != 1895 || len(quote.Inputs) != 2 || quote.Change != 0 {
+		t.Errorf("quote: %+v", quote)
+	}
+	if quote.Outputs["bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3"] != 70000-1895 {
+		t.Errorf("outputs: %+v", quote.Outputs)
+	}
+	if payment.PayInfos["bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3"] != -1 {
+		t.Errorf("Quote() changed payment.PayInfos: %+v", payment.PayInfos)
+	}
+}
+
+func TestOpReturnQuoteSpeedLevels(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"listunspent": testListUnspent,
+	})
+	defer bitcoind.Close()
+
+	opReturn := OpReturn{
+		Address:                 "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Message:                 "hello",
+		FeeEstimator:            testFeeEstimator{FastestFee: 400, HalfHourFee: 300, HourFee: 20, EconomyFee: 10, MinimumFee: 2, Source: "test"},
+		LimitFeeSatsPerVByteMax: 1000,
+	}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+
+	quotes, err := opReturn.QuoteSpeedLevels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 8 {
+		t.Fatalf("quotes: %+v", quotes)
+	}
+
+	level1 := quotes[0]
+	if level1.SpeedLevel != SpeedLevel1 || level1.ConfirmWithinBlocks != 1008 || level1.FeePerVByte != 2 || level1.Fee != 251 || len(level1.Inputs) != 1 || !level1.HasChange || level1.Error != "" {
+		t.Errorf("Level1: %+v", level1)
+	}
+	// 300 sats/vbyte: 0.0005 alone pays 37650 with change, 0.0007 is not sufficient at 400
+	level6 := quotes[5]
+	if level6.FeePerVByte != 300 || level6.Fee != 37650 || len(level6.Inputs) != 1 || level6.SelectionReport.FeeSource != "test" {
+		t.Errorf("Level6: %+v", level6)
+	}
+	level8 := quotes[7]
+	if level8.FeePerVByte != 400 || len(level8.Inputs) != 0 || !strings.Contains(level8.Error, "not sufficient") {
+		t.Errorf("Level8: %+v", level8)
+	}
+
+	count := 0
+	for _, method := range bitcoind.methods {
+		if method == "listunspent" {
+			count += 1
+		}
+	}
+	if count != 1 || bitcoind.called("createrawtransaction") {
+		t.Errorf("methods: %v", bitcoind.methods)
+	}
+}
@@ -0,0 +1,419 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+const (
+	sequenceReplaceable         = 0xfffffffd // BIP125
+	incrementalRelayFeePerVByte = 1.0        // Sats 1, -incrementalrelayfee of bitcoind
+)
+
+// replacement rebuilds a tx of the same inputs and outputs at a higher fee rate, under BIP125:
+// the replacement pays at least the fees of the replaced tx and its descendants
+// plus the incremental relay fee for its own size, and a higher fee rate.
+type replacement struct {
+	bitcoinCli    goBitcoinCli.BitcoinRpc
+	address       string // balance(change) and key
+	signer        Signer
+	confirmations int
+
+	inputs       []Unspent         // of the replaced tx, all kept
+	payInfos     map[string]Amount // without balance-pay-info
+	messageHex   string
+	sweepAddress string // of payInfos, reduced instead of the balance. "": none
+	limitFee     Amount // 0: no limit
+
+	replacedTxID string
+	feePerVByte  float64
+
+	// result
+	unspents    []Unspent // selected, Expected
+	fee         Amount
+	change      Amount
+	rawTx       string
+	signedRawTx string
+	vSize       int
+	txID        string
+}
+
+func (r *replacement) opReturnSizes() []int {
+	if r.messageHex == "" {
+		return nil
+	}
+	return []int{len(r.messageHex) / 2}
+}
+
+// replacedFees checks the replaced tx in mempool, and returns the fees BIP125 asks to beat.
+func (r *replacement) replacedFees() (replacedFee Amount, replacedFeePerVByte float64, err error) {
+	entry, err := getMempoolEntry(r.bitcoinCli, r.replacedTxID)
+	if err != nil {
+		err = fmt.Errorf("tx '%s' is not in mempool, confirmed or evicted: %v", r.replacedTxID, err)
+		return
+	}
+	if !entry.Replaceable {
+		err = fmt.Errorf("tx '%s' does not signal BIP125 replaceability", r.replacedTxID)
+		return
+	}
+	replacedFee, err = AmountFromBTC(entry.Fees.Descendant)
+	if err != nil {
+		err = fmt.Errorf("@AmountFromBTC(%f): %v", entry.Fees.Descendant, err)
+		return
+	}
+	baseFee, err := AmountFromBTC(entry.Fees.Base)
+	if err != nil {
+		err = fmt.Errorf("@AmountFromBTC(%f): %v", entry.Fees.Base, err)
+		return
+	}
+	replacedFeePerVByte = float64(baseFee) / float64(entry.VSize)
+	return
+}
+
+// minFee of the replacement for vBytes: fees of the replaced txs + incremental relay fee (BIP125 rule 3, 4)
+func minReplacementFee(replacedFee Amount, vBytes float64) Amount {
+	return replacedFee + Amount(math.Ceil(incrementalRelayFeePerVByte*vBytes))
+}
+
+// hasPayment reports whether the tx has an output other than the balance, to leave the balance out.
+func (r *replacement) hasPayment() bool {
+	return len(r.payInfos) > 0 || r.messageHex != ""
+}
+
+// selectInputs keeps the inputs of the replaced tx, reduces the balance first,
+// then adds confirmed unspents of candidates, largest first.
+func (r *replacement) selectInputs(candidates []Unspent, replacedFee Amount) (err error) {
+	params := SelectionParams{Address: r.address}
+	target := Amount(0)
+	payAddresses := make([]string, 0)
+	for address, amount := range r.payInfos {
+		target += amount
+		payAddresses = append(payAddresses, address)
+	}
+	if r.sweepAddress != "" {
+		err = r.reduceSweep(target, payAddresses, replacedFee)
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, input := range r.inputs {
+		used[fmt.Sprintf("%s:%d", input.TxID, input.Vout)] = true
+	}
+	extra := make([]Unspent, 0)
+	for _, candidate := range candidates {
+		// BIP125 rule 2: no new unconfirmed inputs
+		if used[fmt.Sprintf("%s:%d", candidate.TxID, candidate.Vout)] || candidate.Confirmations < r.confirmations || candidate.Confirmations <= 0 {
+			continue
+		}
+		extra = append(extra, candidate)
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		return extra[i].Amount > extra[j].Amount
+	})
+
+	selected := append([]Unspent{}, r.inputs...)
+	for {
+		sum := Amount(0)
+		inputAddresses := make([]string, 0)
+		for _, unspent := range selected {
+			sum += unspent.Amount
+			inputAddresses = append(inputAddresses, params.inputAddress(unspent))
+		}
+		requiredFee := func(hasChange bool) Amount {
+			outputAddresses := payAddresses
+			if hasChange {
+				outputAddresses = append(append([]string{}, payAddresses...), r.address)
+			}
+			vBytes := estimateVBytes(inputAddresses, outputAddresses, r.opReturnSizes())
+			fee := Amount(math.Ceil(vBytes * r.feePerVByte))
+			if minFee := minReplacementFee(replacedFee, vBytes); fee < minFee {
+				fee = minFee
+			}
+			return fee
+		}
+
+		feeWithChange := requiredFee(true)
+		feeWithoutChange := requiredFee(false)
+		switch {
+		case sum-target-feeWithChange >= dustThreshold:
+			r.fee = feeWithChange
+			r.change = sum - target - feeWithChange
+		case sum-target-feeWithoutChange >= 0 && r.hasPayment():
+			r.fee = sum - target // the balance under dust goes to fee
+			r.change = 0
+		case len(extra) > 0:
+			selected = append(selected, extra[0])
+			extra = extra[1:]
+			continue
+		default:
+			err = fmt.Errorf("not sufficient for the replacement: sum of inputs[%s] < fee[%s] + target[%s]", sum, feeWithoutChange, target)
+			return
+		}
+		if r.limitFee > 0 && r.fee > r.limitFee {
+			err = fmt.Errorf("fee[%s] of the replacement is over the limit[%s]", r.fee, r.limitFee)
+			return
+		}
+		r.unspents = selected
+		return
+	}
+}
+
+// reduceSweep keeps the inputs of the replaced sweep, and takes the fee out of the output to sweepAddress.
+// The sweep took all of confirmed unspents, so no more inputs are added.
+func (r *replacement) reduceSweep(target Amount, payAddresses []string, replacedFee Amount) (err error) {
+	params := SelectionParams{Address: r.address}
+	sum := Amount(0)
+	inputAddresses := make([]string, 0)
+	for _, unspent := range r.inputs {
+		sum += unspent.Amount
+		inputAddresses = append(inputAddresses, params.inputAddress(unspent))
+	}
+	vBytes := estimateVBytes(inputAddresses, payAddresses, r.opReturnSizes())
+	r.fee = Amount(math.Ceil(vBytes * r.feePerVByte))
+	if minFee := minReplacementFee(replacedFee, vBytes); r.fee < minFee {
+		r.fee = minFee
+	}
+	sweepAmount := sum - (target - r.payInfos[r.sweepAddress]) - r.fee
+	if sweepAmount < dustThreshold {
+		err = fmt.Errorf("sweep output[%s] to '%s' of the replacement is under dust[%s]", sweepAmount, r.sweepAddress, dustThreshold)
+		return
+	}
+	if r.limitFee > 0 && r.fee > r.limitFee {
+		err = fmt.Errorf("fee[%s] of the replacement is over the limit[%s]", r.fee, r.limitFee)
+		return
+	}
+	r.payInfos[r.sweepAddress] = sweepAmount
+	r.change = 0
+	r.unspents = append([]Unspent{}, r.inputs...)
+	return
+}
+
+// send creates, signs and broadcasts the replacement
+func (r *replacement) send(replacedFee Amount) (err error) {
+	payInfos := make(map[string]Amount)
+	for address, amount := range r.payInfos {
+		payInfos[address] = amount
+	}
+	if r.change > 0 {
+		payInfos[r.address] += r.change
+	}
+	r.rawTx, err = createRawTx(r.unspents, payInfos, r.messageHex)
+	if err != nil {
+		err = fmt.Errorf("@createRawTx(): %v", err)
+		return
+	}
+
+	r.signedRawTx, err = signerOrDefault(r.signer).SignRawTx(r.bitcoinCli, r.rawTx, r.unspents, r.address)
+	if err != nil {
+		err = fmt.Errorf("@signer.SignRawTx(): %v", err)
+		return
+	}
+	signedTx, err := DecodeTx(r.signedRawTx)
+	if err != nil {
+		err = fmt.Errorf("@DecodeTx(r.signedRawTx): %v", err)
+		return
+	}
+	weight, err := signedTx.Weight()
+	if err != nil {
+		err = fmt.Errorf("@signedTx.Weight(): %v", err)
+		return
+	}
+	r.vSize = vSizeOfWeight(weight)
+	if minFee := minReplacementFee(replacedFee, float64(r.vSize)); r.fee < minFee {
+		err = fmt.Errorf("fee[%s] of the signed replacement is under BIP125 minimum[%s] for %d vbytes", r.fee, minFee, r.vSize)
+		return
+	}
+
+	err = callRpc(r.bitcoinCli, "sendrawtransaction", []interface{}{r.signedRawTx}, &r.txID)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('sendrawtransaction'): %v", err)
+		return
+	}
+	return
+}
+
+// run replaces r.replacedTxID at r.feePerVByte(0: the lowest BIP125 allows), with candidates for more inputs
+func (r *replacement) run(candidates []Unspent) (err error) {
+	replacedFee, replacedFeePerVByte, err := r.replacedFees()
+	if err != nil {
+		return
+	}
+	if r.feePerVByte <= 0 {
+		r.feePerVByte = replacedFeePerVByte + incrementalRelayFeePerVByte
+	}
+	if r.feePerVByte <= replacedFeePerVByte {
+		err = fmt.Errorf("fee rate[%f] must be higher than the replaced[%f]", r.feePerVByte, replacedFeePerVByte)
+		return
+	}
+	if err = r.selectInputs(candidates, replacedFee); err != nil {
+		return
+	}
+	err = r.send(replacedFee)
+	return
+}
+
+// payInfosWithoutBalance removes balance-pay-info of a sent job from payInfos
+func payInfosWithoutBalance(payInfos map[string]Amount, address string, balance Amount) (tPayInfos map[string]Amount) {
+	tPayInfos = make(map[string]Amount)
+	for tAddress, amount := range payInfos {
+		if tAddress == address {
+			amount -= balance
+			if amount <= 0 {
+				continue
+			}
+		}
+		tPayInfos[tAddress] = amount
+	}
+	return
+}
+
+func expectedUnspents(unspents []Unspent) (expected []Unspent) {
+	expected = make([]Unspent, 0)
+	for _, unspent := range unspents {
+		if unspent.Expected {
+			expected = append(expected, unspent)
+		}
+	}
+	return
+}
+
+// LoadPending reads an unconfirmed OP_RETURN tx back from the node, for BumpFee of a txid.
+// An output to opReturn.Address is taken as balance-pay-info.
+func (opReturn *OpReturn) LoadPending(txID string) (err error) {
+	bitcoinCli := opReturn.bitcoinCli()
+	pending, err := loadPendingTx(bitcoinCli, txID)
+	if err != nil {
+		err = fmt.Errorf("@loadPendingTx('%s'): %v", txID, err)
+		return
+	}
+	opReturn.Unspents = pending.Inputs
+	opReturn.PayInfos = pending.Outputs
+	opReturn.AmountBalanceUsedUnspends = pending.Outputs[opReturn.Address]
+	opReturn.MessageHex = pending.MessageHex
+	opReturn.Fee = pending.Fee
+	opReturn.SignedRawTx = pending.Hex
+	opReturn.VSize = pending.VSize
+	opReturn.EffectiveFeePerVByte = float64(pending.Fee) / float64(pending.VSize)
+	opReturn.OpRetrunTxID = pending.TxID
+	return
+}
+
+// BumpFee replaces opReturn.OpRetrunTxID, sent by Run or read by LoadPending, with the same inputs and OP_RETURN
+// at feePerVByte. The balance is reduced first, then confirmed unspents of Address are added.
+func (opReturn *OpReturn) BumpFee(feePerVByte float64) (err error) {
+	if opReturn.OpRetrunTxID == "" {
+		err = fmt.Errorf("no OpRetrunTxID to bump")
+		return
+	}
+	bitcoinCli := opReturn.bitcoinCli()
+	confirmations := opReturn.Confirmations
+	if confirmations <= 0 {
+		confirmations = 1
+	}
+
+	r := replacement{
+		bitcoinCli:    bitcoinCli,
+		address:       opReturn.Address,
+		signer:        opReturn.Signer,
+		confirmations: confirmations,
+		inputs:        expectedUnspents(opReturn.Unspents),
+		payInfos:      payInfosWithoutBalance(opReturn.PayInfos, opReturn.Address, opReturn.AmountBalanceUsedUnspends),
+		messageHex:    opReturn.MessageHex,
+		limitFee:      opReturn.LimitFeeSats,
+		replacedTxID:  opReturn.OpRetrunTxID,
+		feePerVByte:   feePerVByte,
+	}
+	candidates, err := listUnspents(bitcoinCli, opReturn.Address)
+	if err != nil {
+		err = fmt.Errorf("@listUnspents('%s'): %v", opReturn.Address, err)
+		return
+	}
+	if err = r.run(candidates); err != nil {
+		err = fmt.Errorf("@replacement.run(): %v", err)
+		return
+	}
+
+	opReturn.Unspents = r.unspents
+	opReturn.PayInfos = r.payInfos
+	if r.change > 0 {
+		opReturn.PayInfos[opReturn.Address] += r.change
+	}
+	opReturn.Fee = r.fee
+	opReturn.AmountBalanceUsedUnspends = r.change
+	opReturn.RawTx = r.rawTx
+	opReturn.SignedRawTx = r.signedRawTx
+	opReturn.VSize = r.vSize
+	opReturn.EffectiveFeePerVByte = float64(r.fee) / float64(r.vSize)
+	opReturn.ReplacedTxIDs = append(opReturn.ReplacedTxIDs, opReturn.OpRetrunTxID)
+	opReturn.OpRetrunTxID = r.txID
+	return
+}
+
+// LoadPending reads an unconfirmed payment tx back from the node, for BumpFee of a txid.
+// An output to payment.Address is taken as balance-pay-info.
+func (payment *Payment) LoadPending(txID string) (err error) {
+	pending, err := loadPendingTx(payment.bitcoinCli(), txID)
+	if err != nil {
+		err = fmt.Errorf("@loadPendingTx('%s'): %v", txID, err)
+		return
+	}
+	payment.Unspents = pending.Inputs
+	payment.PayInfos = pending.Outputs
+	payment.AmountBalanceUsedUnspends = pending.Outputs[payment.Address]
+	payment.Fee = pending.Fee
+	payment.SignedRawTx = pending.Hex
+	payment.PaymentTxID = pending.TxID
+	return
+}
+
+// BumpFee replaces payment.PaymentTxID, sent by Run or read by LoadPending, with the same inputs and outputs
+// at feePerVByte. The balance is reduced first, then confirmed unspents of Address are added.
+// The output to SweepAddress of a sweep is reduced instead, set it after LoadPending.
+func (payment *Payment) BumpFee(feePerVByte float64) (err error) {
+	if payment.PaymentTxID == "" {
+		err = fmt.Errorf("no PaymentTxID to bump")
+		return
+	}
+	bitcoinCli := payment.bitcoinCli()
+	confirmations := payment.Confirmations
+	if confirmations <= 0 {
+		confirmations = 1
+	}
+
+	r := replacement{
+		bitcoinCli:    bitcoinCli,
+		address:       payment.Address,
+		signer:        payment.Signer,
+		confirmations: confirmations,
+		inputs:        expectedUnspents(payment.Unspents),
+		payInfos:      payInfosWithoutBalance(payment.PayInfos, payment.Address, payment.AmountBalanceUsedUnspends),
+		sweepAddress:  payment.SweepAddress,
+		replacedTxID:  payment.PaymentTxID,
+		feePerVByte:   feePerVByte,
+	}
+	candidates, err := listUnspents(bitcoinCli, payment.Address)
+	if err != nil {
+		err = fmt.Errorf("@listUnspents('%s'): %v", payment.Address, err)
+		return
+	}
+	if err = r.run(candidates); err != nil {
+		err = fmt.Errorf("@replacement.run(): %v", err)
+		return
+	}
+
+	payment.Unspents = r.unspents
+	payment.PayInfos = r.payInfos
+	if r.change > 0 {
+		payment.PayInfos[payment.Address] += r.change
+	}
+	payment.Fee = r.fee
+	payment.AmountBalanceUsedUnspends = r.change
+	payment.RawTx = r.rawTx
+	payment.SignedRawTx = r.signedRawTx
+	payment.ReplacedTxIDs = append(payment.ReplacedTxIDs, payment.PaymentTxID)
+	payment.PaymentTxID = r.txID
+	return
+}
@@ -0,0 +1,198 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// signed tx of 1 P2WPKH input, outputs of P2WPKH and OP_RETURN "hello": 126 vbytes
+var testSignedRawTx = "02000000" + "0001" +
+	"01" + strings.Repeat("00", 36) + "00" + "fdffffff" +
+	"02" + "e803000000000000" + "16" + "0014" + strings.Repeat("11", 20) +
+	"0000000000000000" + "07" + "6a05" + "68656c6c6f" +
+	"02" + "48" + strings.Repeat("22", 72) + "21" + strings.Repeat("33", 33) +
+	"00000000"
+
+func newTestRbfBitcoind(replaceable string) *testBitcoind {
+	return newTestBitcoind(map[string]string{
+		"listunspent":        testListUnspent,
+		"getmempoolentry":    `{"vsize":126,"bip125-replaceable":` + replaceable + `,"fees":{"base":0.00001255,"descendant":0.00001255}}`,
+		"dumpprivkey":        `"` + testWIF + `"`,
+		"sendrawtransaction": `"newtxid"`,
+	})
+}
+
+func testSentOpReturn(bitcoind *testBitcoind, input Unspent) (opReturn OpReturn) {
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	input.Expected = true
+	opReturn = OpReturn{
+		Address:                   address,
+		MessageHex:                "68656c6c6f",
+		Unspents:                  []Unspent{input},
+		PayInfos:                  map[string]Amount{address: input.Amount - 1255},
+		AmountBalanceUsedUnspends: input.Amount - 1255,
+		Fee:                       1255,
+		OpRetrunTxID:              "oldtxid",
+	}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	return
+}
+
+func TestOpReturnBumpFee(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	if err := opReturn.BumpFee(20); err != nil {
+		t.Fatal(err)
+	}
+	// same 1 input, the balance reduced: 125.5 vbytes x 20
+	if opReturn.OpRetrunTxID != "newtxid" || len(opReturn.ReplacedTxIDs) != 1 || opReturn.ReplacedTxIDs[0] != "oldtxid" {
+		t.Errorf("txids: %s, %v", opReturn.OpRetrunTxID, opReturn.ReplacedTxIDs)
+	}
+	if opReturn.Fee != 2510 || opReturn.AmountBalanceUsedUnspends != 47490 || opReturn.PayInfos[opReturn.Address] != 47490 || len(opReturn.Unspents) != 1 {
+		t.Errorf("opReturn: %+v", opReturn)
+	}
+	if opReturn.VSize != 126 {
+		t.Errorf("vsize: %d", opReturn.VSize)
+	}
+	tx := bitcoind.signedRawTx(t)
+	if len(tx.Inputs) != 1 || tx.Inputs[0].Sequence != sequenceReplaceable || len(tx.Outputs) != 2 || hex.EncodeToString(tx.Outputs[1].ScriptPubKey) != "6a0568656c6c6f" {
+		t.Errorf("raw tx: %+v", tx)
+	}
+}
+
+func TestOpReturnBumpFeeAddsInputs(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDB, Vout: 1, Amount: 20000, Confirmations: 10})
+	if err := opReturn.BumpFee(250); err != nil {
+		t.Fatal(err)
+	}
+	// 2 inputs: 193.5 vbytes x 250
+	if len(opReturn.Unspents) != 2 || opReturn.Unspents[1].TxID != testTxIDA || opReturn.Fee != 48375 || opReturn.AmountBalanceUsedUnspends != 70000-48375 {
+		t.Errorf("opReturn: %+v", opReturn)
+	}
+}
+
+func TestOpReturnBumpFeeChangeless(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	// OP_RETURN only: the balance 490 at 20 sats/vbyte is under dust and goes to fee, no more inputs
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 3000, Confirmations: 10})
+	if err := opReturn.BumpFee(20); err != nil {
+		t.Fatal(err)
+	}
+	if len(opReturn.Unspents) != 1 || opReturn.Fee != 3000 || opReturn.AmountBalanceUsedUnspends != 0 || len(opReturn.PayInfos) != 0 {
+		t.Errorf("opReturn: %+v", opReturn)
+	}
+	tx := bitcoind.signedRawTx(t)
+	if len(tx.Inputs) != 1 || len(tx.Outputs) != 1 || hex.EncodeToString(tx.Outputs[0].ScriptPubKey) != "6a0568656c6c6f" {
+		t.Errorf("raw tx: %+v", tx)
+	}
+}
+
+func TestPaymentBumpFeeSweep(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	to := "1EfzPvwXiTH9UeRDUeMCSBHFWhSejKQbWT"
+	payment := Payment{
+		Address:      "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		Unspents:     []Unspent{{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10, Expected: true}},
+		PayInfos:     map[string]Amount{to: 48745},
+		SweepAddress: to,
+		Fee:          1255,
+		PaymentTxID:  "oldtxid",
+	}
+	payment.RpcConnect, payment.RpcPort = bitcoind.connectPort()
+	if err := payment.BumpFee(20); err != nil {
+		t.Fatal(err)
+	}
+	// the sweep output is reduced, without a balance output nor more inputs
+	if len(payment.Unspents) != 1 || payment.AmountBalanceUsedUnspends != 0 || len(payment.PayInfos) != 1 || payment.PayInfos[to] != 50000-payment.Fee || payment.Fee <= 1255 {
+		t.Errorf("payment: %+v", payment)
+	}
+	tx := bitcoind.signedRawTx(t)
+	if len(tx.Inputs) != 1 || len(tx.Outputs) != 1 || tx.Outputs[0].Value != payment.PayInfos[to] {
+		t.Errorf("raw tx: %+v", tx)
+	}
+
+	// not enough left for the sweep output
+	payment.PaymentTxID = "oldtxid"
+	if err := payment.BumpFee(500); err == nil || !strings.Contains(err.Error(), "sweep output") {
+		t.Errorf("expected an error of the sweep output: %v", err)
+	}
+}
+
+func TestOpReturnBumpFeeRules(t *testing.T) {
+	bitcoind := newTestRbfBitcoind("true")
+	defer bitcoind.Close()
+
+	// not higher than 1255 / 126
+	opReturn := testSentOpReturn(bitcoind, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	if err := opReturn.BumpFee(9.9); err == nil || !strings.Contains(err.Error(), "must be higher") {
+		t.Errorf("expected an error of fee rate: %v", err)
+	}
+	if opReturn.OpRetrunTxID != "oldtxid" {
+		t.Errorf("OpRetrunTxID: %s", opReturn.OpRetrunTxID)
+	}
+
+	// BIP125 rule 4: 10.5 sats/vbyte pays 1318 < 1255 + 126
+	if err := opReturn.BumpFee(10.5); err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.Fee != 1255+126 {
+		t.Errorf("fee: %s, expected BIP125 minimum 1381", opReturn.Fee)
+	}
+
+	notReplaceable := newTestRbfBitcoind("false")
+	defer notReplaceable.Close()
+	opReturn = testSentOpReturn(notReplaceable, Unspent{TxID: testTxIDA, Vout: 0, Amount: 50000, Confirmations: 10})
+	if err := opReturn.BumpFee(20); err == nil || !strings.Contains(err.Error(), "replaceability") {
+		t.Errorf("expected an error of replaceability: %v", err)
+	}
+}
+
+func TestOpReturnDataHex(t *testing.T) {
+	testCases := map[string]string{
+		"6a0568656c6c6f":          "68656c6c6f",
+		"6a4c05" + "68656c6c6f":   "68656c6c6f",
+		"6a4d0500" + "68656c6c6f": "68656c6c6f",
+		"6a":                      "",
+	}
+	for scriptHex, dataHex := range testCases {
+		if tDataHex, err := opReturnDataHex(scriptHex); err != nil || tDataHex != dataHex {
+			t.Errorf("'%s': '%s', %v", scriptHex, tDataHex, err)
+		}
+	}
+	if _, err := opReturnDataHex("0014" + strings.Repeat("11", 20)); err == nil {
+		t.Errorf("expected an error for P2WPKH script")
+	}
+}
+
+func TestOpReturnLoadPending(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"getrawtransaction": `{"txid":"oldtxid","hex":"00","vsize":126,"confirmations":0,
+			"vin":[{"txid":"aa","vout":0,"sequence":4294967293}],
+			"vout":[{"value":0.00048745,"n":0,"scriptPubKey":{"hex":"0014","type":"witness_v0_keyhash","address":"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}},
+				{"value":0,"n":1,"scriptPubKey":{"hex":"6a0568656c6c6f","type":"nulldata"}}]}`,
+		"gettxout": `{"value":0.0005,"confirmations":10,"scriptPubKey":{"address":"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}}`,
+	})
+	defer bitcoind.Close()
+
+	opReturn := OpReturn{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	if err := opReturn.LoadPending("oldtxid"); err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.Fee != 1255 || opReturn.MessageHex != "68656c6c6f" || opReturn.AmountBalanceUsedUnspends != 48745 || opReturn.VSize != 126 {
+		t.Errorf("opReturn: %+v", opReturn)
+	}
+	if len(opReturn.Unspents) != 1 || !opReturn.Unspents[0].Expected || opReturn.Unspents[0].Amount != 50000 || opReturn.OpRetrunTxID != "oldtxid" {
+		t.Errorf("unspents: %+v", opReturn.Unspents)
+	}
+}
@@ -0,0 +1,84 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+const (
+	rpcMethodNotFound       = -32601
+	rpcWalletError          = -4
+	rpcInvalidAddressOrKey  = -5 // also of a tx not found
+	rpcVerifyAlreadyInChain = -27
+)
+
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (rpcError *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", rpcError.Code, rpcError.Message)
+}
+
+// callRpc calls bitcoind methods which goBitcoinCli does not provide, and reports rpc errors.
+func callRpc(bitcoinCli goBitcoinCli.BitcoinRpc, method string, params []interface{}, result interface{}) (err error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	jsonRpcBytes, err := json.Marshal(JsonRpc{JsonRpc: "1.0", ID: "GoBitcoinOpReturn", Method: method, Params: params})
+	if err != nil {
+		err = fmt.Errorf("@json.Marshal(JsonRpc): %v", err)
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%s/%s", bitcoinCli.RpcConnect, bitcoinCli.RpcPort, bitcoinCli.RpcPath), bytes.NewBuffer(jsonRpcBytes))
+	if err != nil {
+		err = fmt.Errorf("@http.NewRequest('POST', ...): %v", err)
+		return
+	}
+	request.Header.Set("content-type", "text/plain;")
+	request.SetBasicAuth(bitcoinCli.RpcUser, bitcoinCli.RpcPW)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		err = fmt.Errorf("@http.DefaultClient.Do(request): %v", err)
+		return
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		err = fmt.Errorf("@io.ReadAll(response.Body): %v", err)
+		return
+	}
+
+	type resultRpc struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RpcError       `json:"error"`
+	}
+	tResult := resultRpc{}
+	err = json.Unmarshal(body, &tResult)
+	if err != nil {
+		err = fmt.Errorf("@json.Unmarshal(body, &tResult): %s: %v", method, err)
+		return
+	}
+	if tResult.Error != nil {
+		err = tResult.Error
+		return
+	}
+	if result == nil {
+		return
+	}
+	err = json.Unmarshal(tResult.Result, result)
+	if err != nil {
+		err = fmt.Errorf("@json.Unmarshal(tResult.Result, result): %s: %v", method, err)
+		return
+	}
+	return
+}
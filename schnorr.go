@@ -0,0 +1,123 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// BIP340 Schnorr signatures of btcec, and the BIP341 key tweak, for key path spending of P2TR
+
+// taggedHash is sha256(sha256(tag) || sha256(tag) || data...)
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// schnorrSign signs msg by privKey with auxRand of 32 bytes under BIP340
+func schnorrSign(privKey *btcec.PrivateKey, msg []byte, auxRand []byte) (signature []byte, err error) {
+	if len(auxRand) != 32 {
+		err = fmt.Errorf("incorrect length %d of auxRand", len(auxRand))
+		return
+	}
+	var aux [32]byte
+	copy(aux[:], auxRand)
+	sig, err := schnorr.Sign(privKey, msg, schnorr.CustomNonce(aux))
+	if err != nil {
+		err = fmt.Errorf("@schnorr.Sign(): %v", err)
+		return
+	}
+	signature = sig.Serialize()
+	return
+}
+
+// schnorrVerify verifies signature of msg by x-only public key under BIP340
+func schnorrVerify(publicX []byte, msg []byte, signature []byte) bool {
+	publicKey, err := schnorr.ParsePubKey(publicX)
+	if err != nil {
+		return false
+	}
+	sig, err := schnorr.ParseSignature(signature)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(msg, publicKey)
+}
+
+// tapTweak is the scalar tweaking x-only internalKey without a script tree
+func tapTweak(internalKey []byte) (tweak btcec.ModNScalar, err error) {
+	if overflow := tweak.SetByteSlice(taggedHash("TapTweak", internalKey)); overflow {
+		err = fmt.Errorf("tweak out of range")
+		return
+	}
+	return
+}
+
+// taprootTweak tweaks privKey for key path spending without a script tree (BIP341, BIP86),
+// returning the tweaked private key and the x-only output key of the P2TR scriptPubKey.
+func taprootTweak(privKey *btcec.PrivateKey) (tweakedPrivKey *btcec.PrivateKey, outputKey []byte, err error) {
+	internalKey := schnorr.SerializePubKey(privKey.PubKey())
+	tweak, err := tapTweak(internalKey)
+	if err != nil {
+		return
+	}
+	var d btcec.ModNScalar
+	d.Set(&privKey.Key)
+	if privKey.PubKey().SerializeCompressed()[0] == 0x03 { // odd y
+		d.Negate()
+	}
+	d.Add(&tweak)
+	if d.IsZero() {
+		err = fmt.Errorf("tweaked private key is zero")
+		return
+	}
+	tweakedPrivKey = btcec.PrivKeyFromScalar(&d)
+	outputKey = schnorr.SerializePubKey(tweakedPrivKey.PubKey())
+	return
+}
+
+// taprootOutputKey tweaks x-only internalKey without a script tree
+func taprootOutputKey(internalKey []byte) (outputKey []byte, err error) {
+	internalPubKey, err := schnorr.ParsePubKey(internalKey)
+	if err != nil {
+		err = fmt.Errorf("@schnorr.ParsePubKey(): %v", err)
+		return
+	}
+	tweak, err := tapTweak(internalKey)
+	if err != nil {
+		return
+	}
+	var internalPoint, tweakPoint, outputPoint btcec.JacobianPoint
+	internalPubKey.AsJacobian(&internalPoint)
+	btcec.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	btcec.AddNonConst(&internalPoint, &tweakPoint, &outputPoint)
+	if (outputPoint.X.IsZero() && outputPoint.Y.IsZero()) || outputPoint.Z.IsZero() {
+		err = fmt.Errorf("output key is infinity")
+		return
+	}
+	outputPoint.ToAffine()
+	outputKey = schnorr.SerializePubKey(btcec.NewPublicKey(&outputPoint.X, &outputPoint.Y))
+	return
+}
+
+// p2trScript is OP_1 <outputKey>
+func p2trScript(outputKey []byte) []byte {
+	return append([]byte{op1, byte(len(outputKey))}, outputKey...)
+}
+
+func isP2TRScript(script []byte) bool {
+	return len(script) == 34 && script[0] == op1 && script[1] == 32
+}
+
+func matchesP2TR(script []byte, outputKey []byte) bool {
+	return isP2TRScript(script) && bytes.Equal(script[2:], outputKey)
+}
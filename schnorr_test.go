@@ -0,0 +1,231 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+func testPrivKey(privKeyHex string) *btcec.PrivateKey {
+	privKeyBytes, _ := hex.DecodeString(privKeyHex)
+	privKey, _ := btcec.PrivKeyFromBytes(privKeyBytes)
+	return privKey
+}
+
+func TestSchnorrSign(t *testing.T) {
+	// test vectors of BIP340
+	tests := []struct {
+		privKey, publicKey, auxRand, msg, signature string
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000003", "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			"0000000000000000000000000000000000000000000000000000000000000000", "0000000000000000000000000000000000000000000000000000000000000000",
+			"e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0"},
+		{"b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef", "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			"0000000000000000000000000000000000000000000000000000000000000001", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a"},
+		{"c90fdaa22168c234c4c6628b80dc1cd129024e088a67cc74020bbea63b14e5c9", "dd308afec5777e13121fa72b9cc1b7cc0139715309b086c960e18fd969774eb8",
+			"c87aa53824b4d7ae2eb035a2b5bbbccc080e76cdc6d1692c4b0b62d798e6d906", "7e2d58d8b3bcdf1abadec7829054f90dda9805aab56c77333024b9d0a508b75c",
+			"5831aaeed7b44bb74e5eab94ba9d4294c49bcf2a60728d8b4c200f50dd313c1bab745879a5ad954a72c45a91c3a51d3c7adea98d82f8481e0e1e03674a6f3fb7"},
+		{"0b432b2677937381aef05bb02a66ecd012773062cf3fa2549e44f58ed2401710", "25d1dff95105f5253c4022f628a996ad3a0d95fbf21d468a1b33f8c160d8f517",
+			"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			"7eb0509757e246f19449885651611cb965ecc1a187dd51b64fda1edc9637d5ec97582b9cb13db3933705b32ba982af5af25fd78881ebb32771fc5922efc66ea3"},
+	}
+	for _, test := range tests {
+		auxRand, _ := hex.DecodeString(test.auxRand)
+		msg, _ := hex.DecodeString(test.msg)
+		privKey := testPrivKey(test.privKey)
+		signature, err := schnorrSign(privKey, msg, auxRand)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(signature) != test.signature {
+			t.Errorf("schnorrSign(%s): %x", test.privKey, signature)
+		}
+		if publicKey := hex.EncodeToString(schnorr.SerializePubKey(privKey.PubKey())); publicKey != test.publicKey {
+			t.Errorf("public key of %s: %s", test.privKey, publicKey)
+		}
+	}
+}
+
+func TestSchnorrVerify(t *testing.T) {
+	// test vectors of BIP340
+	tests := []struct {
+		publicKey, msg, signature string
+		valid                     bool
+	}{
+		{"d69c3509bb99e412e68b0fe8544e72837dfa30746d8be2aa65975f29d22dc7b9", "4df3c3f68fcc83b27e9d42c90431a72499f17875c81a599b566c9889b9696703",
+			"00000000000000000000003b78ce563f89a0ed9414f5aa28ad0d96d6795f9c6376afb1548af603b3eb45c9f8207dee1060cb71c04e80f593060b07d28308d7f4", true},
+		// public key not on the curve
+		{"eefdea4cdb677750a420fee807eacf21eb9898ae79b9768766e4faa04a2d4a34", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6cff5c3ba86c69ea4b7376f31a9bcb4f74c1976089b2d9963da2e5543e17776969e89b4c5564d00349106b8497785dd7d1d713a8ae82b32fa79d5f7fc407d39b", false},
+		// R with odd y
+		{"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a14602975563cc27944640ac607cd107ae10923d9ef7a73c643e166be5ebeafa34b1ac553e2", false},
+		// negated message
+		{"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"1fa62e331edbc21c394792d2ab1100a7b432b013df3f6ff4f99fcb33e0e1515f28890b3edb6e7189b630448b515ce4f8622a954cfe545735aaea5134fccdb2bd", false},
+		// negated s
+		{"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6cff5c3ba86c69ea4b7376f31a9bcb4f74c1976089b2d9963da2e5543e177769961764b3aa9b2ffcb6ef947b6887a226e8d7c93e00c5ed0c1834ff0d0c2e6da6", false},
+		// R at infinity
+		{"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659", "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"0000000000000000000000000000000000000000000000000000000000000000123dda8328af9c23a94c1feecfd123ba4fb73476f0d594dcb65c6425bd186051", false},
+	}
+	for i, test := range tests {
+		publicKey, _ := hex.DecodeString(test.publicKey)
+		msg, _ := hex.DecodeString(test.msg)
+		signature, _ := hex.DecodeString(test.signature)
+		if valid := schnorrVerify(publicKey, msg, signature); valid != test.valid {
+			t.Errorf("schnorrVerify() of %d: %v", i, valid)
+		}
+	}
+}
+
+func TestTaprootOutputKey(t *testing.T) {
+	// scriptPubKey test vector of BIP341 without a script tree
+	internalKey, _ := hex.DecodeString("d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d")
+	outputKey, err := taprootOutputKey(internalKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(outputKey) != "53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343" {
+		t.Errorf("outputKey: %x", outputKey)
+	}
+	address, err := encodeSegwitAddress("bc", 1, outputKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "bc1p2wsldez5mud2yam29q22wgfh9439spgduvct83k3pm50fcxa5dps59h4z5" {
+		t.Errorf("address: %s", address)
+	}
+
+	// the tweaked private key signs for the output key of its internal key
+	for _, privKeyHex := range []string{"01", "03", "b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef"} {
+		privKey := testPrivKey(privKeyHex)
+		tweakedPrivKey, outputKey, err := taprootTweak(privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := taprootOutputKey(schnorr.SerializePubKey(privKey.PubKey()))
+		if hex.EncodeToString(outputKey) != hex.EncodeToString(expected) {
+			t.Errorf("output key of %s: %x, want %x", privKeyHex, outputKey, expected)
+		}
+		signature, err := taprootSignature(tweakedPrivKey, make([]byte, 32))
+		if err != nil || !schnorrVerify(outputKey, make([]byte, 32), signature) {
+			t.Errorf("signature by tweaked %s: %v", privKeyHex, err)
+		}
+	}
+}
+
+func TestEncodeSegwitAddress(t *testing.T) {
+	for _, address := range []string{
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+		"bcrt1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqc8gma6",
+	} {
+		witnessVersion, program, err := decodeSegwitAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hrp := address[:strings.LastIndex(address, "1")]
+		if encoded, _ := encodeSegwitAddress(hrp, witnessVersion, program); encoded != address {
+			t.Errorf("encodeSegwitAddress(): %s, want %s", encoded, address)
+		}
+	}
+}
+
+func TestTaprootSigHash(t *testing.T) {
+	tx, err := DecodeTx(testGenesisTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Inputs = []TxIn{{PrevTxID: testTxIDA, Vout: 0, Sequence: sequenceReplaceable}, {PrevTxID: testTxIDB, Vout: 1, Sequence: sequenceReplaceable}}
+	scripts := [][]byte{p2trScript(make([]byte, 32)), p2trScript(make([]byte, 32))}
+	hash, err := tx.taprootSigHash(0, scripts, []Amount{50000, 20000}, sigHashDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commits to the index, the hash type and amounts of every input
+	for _, sighash := range []func() ([]byte, error){
+		func() ([]byte, error) { return tx.taprootSigHash(1, scripts, []Amount{50000, 20000}, sigHashDefault) },
+		func() ([]byte, error) { return tx.taprootSigHash(0, scripts, []Amount{50000, 20000}, sigHashAll) },
+		func() ([]byte, error) { return tx.taprootSigHash(0, scripts, []Amount{50000, 20001}, sigHashDefault) },
+	} {
+		other, err := sighash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(other) == hex.EncodeToString(hash) {
+			t.Errorf("same sighash: %x", hash)
+		}
+	}
+	if _, err = tx.taprootSigHash(0, scripts[:1], []Amount{50000}, sigHashDefault); err == nil {
+		t.Errorf("taprootSigHash() without the script of an input: no error")
+	}
+	if _, err = tx.taprootSigHash(0, scripts, []Amount{50000, 20000}, 0x83); err == nil {
+		t.Errorf("taprootSigHash() of SIGHASH_SINGLE|ANYONECANPAY: no error")
+	}
+}
+
+func TestSignRawTxTaproot(t *testing.T) {
+	address, err := TaprootAddress(testWIF, "bc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if AddressType(address) != AddressTypeP2TR {
+		t.Fatalf("address: %s", address)
+	}
+	unspents := []Unspent{
+		{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true},
+		{TxID: testTxIDB, Vout: 1, Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", Amount: 20000, Expected: true},
+	}
+	taprootRawTx, err := createRawTx(unspents[:1], map[string]Amount{address: 48000}, "68656c6c6f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedRawTx, err := signRawTx(taprootRawTx, unspents, address, testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeTx(signedRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs[0].Witness) != 1 || len(tx.Inputs[0].Witness[0]) != 64 || len(tx.Inputs[0].ScriptSig) != 0 {
+		t.Fatalf("P2TR input: %+v", tx.Inputs[0])
+	}
+	script, _ := AddressScript(address)
+	hash, _ := tx.taprootSigHash(0, [][]byte{script}, []Amount{50000}, sigHashDefault)
+	if !schnorrVerify(script[2:], hash, tx.Inputs[0].Witness[0]) {
+		t.Errorf("signature does not verify")
+	}
+	// 57.5 vbytes of the key path input: 10.5 + 57.5 + 43 + 16
+	weight, _ := tx.Weight()
+	estimated := estimateVBytes([]string{address}, []string{address}, []int{5})
+	if vSizeOfWeight(weight) != 127 || estimated != 127 {
+		t.Errorf("vsize: %d, estimated: %f", vSizeOfWeight(weight), estimated)
+	}
+
+	// P2TR and P2WPKH inputs together, each sighash of both
+	rawTx, err := createRawTx(unspents, map[string]Amount{address: 68000}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedRawTx, err = signRawTx(rawTx, unspents, address, testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ = DecodeTx(signedRawTx)
+	if len(tx.Inputs[0].Witness) != 1 || len(tx.Inputs[1].Witness) != 2 {
+		t.Errorf("inputs: %+v", tx.Inputs)
+	}
+
+	// x-only internal key: compressed or not
+	if _, err = signRawTx(taprootRawTx, unspents, address, "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf"); err != nil {
+		t.Errorf("uncompressed WIF of the same key: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	sigHashDefault = 0x00 // taproot only, as SIGHASH_ALL without the byte in the signature
+	sigHashAll     = 0x01
+)
+
+// legacySigHash is the signature hash of input i before segwit, scriptCode in place of its scriptSig
+func (tx *Tx) legacySigHash(i int, scriptCode []byte, hashType uint32) (hash []byte, err error) {
+	if i < 0 || i >= len(tx.Inputs) {
+		err = fmt.Errorf("no input %d", i)
+		return
+	}
+	copied := Tx{Version: tx.Version, LockTime: tx.LockTime, Outputs: tx.Outputs}
+	copied.Inputs = make([]TxIn, len(tx.Inputs))
+	for j, input := range tx.Inputs {
+		copied.Inputs[j] = TxIn{PrevTxID: input.PrevTxID, Vout: input.Vout, Sequence: input.Sequence}
+	}
+	copied.Inputs[i].ScriptSig = scriptCode
+
+	serialized, err := copied.SerializeNoWitness()
+	if err != nil {
+		return
+	}
+	serialized = binary.LittleEndian.AppendUint32(serialized, hashType)
+	hash = doubleSha256(serialized)
+	return
+}
+
+// witnessV0SigHash is the signature hash of input i spending amount under BIP143
+func (tx *Tx) witnessV0SigHash(i int, scriptCode []byte, amount Amount, hashType uint32) (hash []byte, err error) {
+	if i < 0 || i >= len(tx.Inputs) {
+		err = fmt.Errorf("no input %d", i)
+		return
+	}
+	prevOuts := new(bytes.Buffer)
+	sequences := new(bytes.Buffer)
+	for _, input := range tx.Inputs {
+		txID, errT := txIDBytes(input.PrevTxID)
+		if errT != nil {
+			err = errT
+			return
+		}
+		prevOuts.Write(txID)
+		binary.Write(prevOuts, binary.LittleEndian, input.Vout)
+		binary.Write(sequences, binary.LittleEndian, input.Sequence)
+	}
+	outputs := new(bytes.Buffer)
+	for _, output := range tx.Outputs {
+		binary.Write(outputs, binary.LittleEndian, int64(output.Value))
+		writeVarBytes(outputs, output.ScriptPubKey)
+	}
+
+	input := tx.Inputs[i]
+	txID, err := txIDBytes(input.PrevTxID)
+	if err != nil {
+		return
+	}
+	preimage := new(bytes.Buffer)
+	binary.Write(preimage, binary.LittleEndian, tx.Version)
+	preimage.Write(doubleSha256(prevOuts.Bytes()))
+	preimage.Write(doubleSha256(sequences.Bytes()))
+	preimage.Write(txID)
+	binary.Write(preimage, binary.LittleEndian, input.Vout)
+	writeVarBytes(preimage, scriptCode)
+	binary.Write(preimage, binary.LittleEndian, int64(amount))
+	binary.Write(preimage, binary.LittleEndian, input.Sequence)
+	preimage.Write(doubleSha256(outputs.Bytes()))
+	binary.Write(preimage, binary.LittleEndian, tx.LockTime)
+	binary.Write(preimage, binary.LittleEndian, hashType)
+	hash = doubleSha256(preimage.Bytes())
+	return
+}
+
+// taprootSigHash is the signature hash of input i for key path spending under BIP341,
+// committing to scriptPubKeys and amounts of every input. hashType: sigHashDefault or sigHashAll
+func (tx *Tx) taprootSigHash(i int, scripts [][]byte, amounts []Amount, hashType byte) (hash []byte, err error) {
+	if i < 0 || i >= len(tx.Inputs) {
+		err = fmt.Errorf("no input %d", i)
+		return
+	}
+	if len(scripts) != len(tx.Inputs) || len(amounts) != len(tx.Inputs) {
+		err = fmt.Errorf("%d scripts, %d amounts of %d inputs", len(scripts), len(amounts), len(tx.Inputs))
+		return
+	}
+	if hashType != sigHashDefault && hashType != sigHashAll {
+		err = fmt.Errorf("unsupported sighash type 0x%02x", hashType)
+		return
+	}
+	prevOuts := new(bytes.Buffer)
+	amountsBuffer := new(bytes.Buffer)
+	scriptPubKeys := new(bytes.Buffer)
+	sequences := new(bytes.Buffer)
+	for j, input := range tx.Inputs {
+		txID, errT := txIDBytes(input.PrevTxID)
+		if errT != nil {
+			err = errT
+			return
+		}
+		prevOuts.Write(txID)
+		binary.Write(prevOuts, binary.LittleEndian, input.Vout)
+		binary.Write(amountsBuffer, binary.LittleEndian, int64(amounts[j]))
+		writeVarBytes(scriptPubKeys, scripts[j])
+		binary.Write(sequences, binary.LittleEndian, input.Sequence)
+	}
+	outputs := new(bytes.Buffer)
+	for _, output := range tx.Outputs {
+		binary.Write(outputs, binary.LittleEndian, int64(output.Value))
+		writeVarBytes(outputs, output.ScriptPubKey)
+	}
+	single := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		return digest[:]
+	}
+
+	message := new(bytes.Buffer)
+	message.WriteByte(0x00) // epoch
+	message.WriteByte(hashType)
+	binary.Write(message, binary.LittleEndian, tx.Version)
+	binary.Write(message, binary.LittleEndian, tx.LockTime)
+	message.Write(single(prevOuts.Bytes()))
+	message.Write(single(amountsBuffer.Bytes()))
+	message.Write(single(scriptPubKeys.Bytes()))
+	message.Write(single(sequences.Bytes()))
+	message.Write(single(outputs.Bytes()))
+	message.WriteByte(0x00) // spend type: key path, no annex
+	binary.Write(message, binary.LittleEndian, uint32(i))
+	hash = taggedHash("TapSighash", message.Bytes())
+	return
+}
+
+// p2pkhScript is OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG, also the scriptCode of P2WPKH
+func p2pkhScript(pubKeyHash []byte) []byte {
+	return append(append([]byte{opDup, opHash160, byte(len(pubKeyHash))}, pubKeyHash...), opEqualVerify, opCheckSig)
+}
@@ -0,0 +1,329 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	versionWIFMainnet = 0x80
+	versionWIFTestnet = 0xef // testnet, signet, regtest
+)
+
+// wifKey is a private key of WIF
+type wifKey struct {
+	privKey    *btcec.PrivateKey
+	compressed bool
+}
+
+func decodeWIF(wif string) (key wifKey, err error) {
+	version, payload, err := base58CheckDecode(wif)
+	if err != nil {
+		err = fmt.Errorf("@base58CheckDecode(): %v", err)
+		return
+	}
+	if version != versionWIFMainnet && version != versionWIFTestnet {
+		err = fmt.Errorf("unknown version byte 0x%02x of WIF", version)
+		return
+	}
+	switch {
+	case len(payload) == 33 && payload[32] == 0x01:
+		key.compressed = true
+		payload = payload[:32]
+	case len(payload) != 32:
+		err = fmt.Errorf("incorrect length %d of WIF", len(payload))
+		return
+	}
+	var scalar btcec.ModNScalar
+	if overflow := scalar.SetByteSlice(payload); overflow || scalar.IsZero() {
+		err = fmt.Errorf("private key out of range")
+		return
+	}
+	key.privKey = btcec.PrivKeyFromScalar(&scalar)
+	return
+}
+
+func (key wifKey) pubKey() []byte {
+	if key.compressed {
+		return key.privKey.PubKey().SerializeCompressed()
+	}
+	return key.privKey.PubKey().SerializeUncompressed()
+}
+
+// signature is DER of ECDSA(RFC6979) with low-S, and the sighash type
+func (key wifKey) signature(hash []byte) (signature []byte, err error) {
+	sig := ecdsa.Sign(key.privKey, hash)
+	if !sig.Verify(hash, key.privKey.PubKey()) {
+		err = fmt.Errorf("signature does not verify")
+		return
+	}
+	signature = append(sig.Serialize(), sigHashAll)
+	return
+}
+
+// hash160 is ripemd160(sha256(data))
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return hasher.Sum(nil)
+}
+
+// TaprootAddress is the P2TR address of key path spending by privKey of WIF without a script tree(BIP86),
+// hrp: "bc" mainnet, "tb" testnet & signet, "bcrt" regtest. Fund it to write OP_RETURN from a taproot output.
+func TaprootAddress(privKey string, hrp string) (address string, err error) {
+	key, err := decodeWIF(privKey)
+	if err != nil {
+		err = fmt.Errorf("@decodeWIF(): %v", err)
+		return
+	}
+	_, outputKey, err := taprootTweak(key.privKey)
+	if err != nil {
+		err = fmt.Errorf("@taprootTweak(): %v", err)
+		return
+	}
+	address, err = encodeSegwitAddress(hrp, 1, outputKey)
+	return
+}
+
+// taprootSignature is BIP340 by the tweaked key of P2TR key path, SIGHASH_DEFAULT: without the sighash type
+func taprootSignature(tweakedPrivKey *btcec.PrivateKey, hash []byte) (signature []byte, err error) {
+	auxRand := make([]byte, 32)
+	if _, err = rand.Read(auxRand); err != nil {
+		err = fmt.Errorf("@rand.Read(): %v", err)
+		return
+	}
+	signature, err = schnorrSign(tweakedPrivKey, hash, auxRand)
+	return
+}
+
+// pushData is the smallest push of data up to 255 bytes
+func pushData(data []byte) []byte {
+	if len(data) <= 75 {
+		return append([]byte{byte(len(data))}, data...)
+	}
+	return append([]byte{opPushData1, byte(len(data))}, data...)
+}
+
+func isP2PKHScript(script []byte) bool {
+	return len(script) == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 && script[23] == opEqualVerify && script[24] == opCheckSig
+}
+
+func isP2WPKHScript(script []byte) bool {
+	return len(script) == 22 && script[0] == opFalse && script[1] == 20
+}
+
+func isP2SHScript(script []byte) bool {
+	return len(script) == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual
+}
+
+// p2wpkhScript is OP_0 <pubKeyHash>, the redeemScript of P2SH-P2WPKH
+func p2wpkhScript(pubKeyHash []byte) []byte {
+	return append([]byte{opFalse, 20}, pubKeyHash...)
+}
+
+// isKeyOfScript reports whether script pays to pubKey: P2PKH, P2WPKH or P2SH-P2WPKH
+func isKeyOfScript(pubKey []byte, script []byte) bool {
+	switch {
+	case isP2PKHScript(script):
+		return bytes.Equal(hash160(pubKey), script[3:23])
+	case isP2WPKHScript(script):
+		return bytes.Equal(hash160(pubKey), script[2:22])
+	case isP2SHScript(script):
+		return bytes.Equal(hash160(p2wpkhScript(hash160(pubKey))), script[2:22])
+	}
+	return false
+}
+
+// keySpend is the scriptSig and witness spending script(P2PKH, P2WPKH or P2SH-P2WPKH) by signature of pubKey
+func keySpend(script []byte, pubKey []byte, signature []byte) (scriptSig []byte, witness [][]byte) {
+	switch {
+	case isP2PKHScript(script):
+		scriptSig = append(pushData(signature), pushData(pubKey)...)
+	case isP2SHScript(script):
+		scriptSig = pushData(p2wpkhScript(hash160(pubKey)))
+		witness = [][]byte{signature, pubKey}
+	default:
+		witness = [][]byte{signature, pubKey}
+	}
+	return
+}
+
+// pushedData splits script of pushes only, up to OP_PUSHDATA1, into the data. nil: other opcodes
+func pushedData(script []byte) (data [][]byte) {
+	for len(script) > 0 {
+		n, offset := int(script[0]), 1
+		switch {
+		case script[0] <= 75:
+		case script[0] == opPushData1 && len(script) >= 2:
+			n, offset = int(script[1]), 2
+		default:
+			return nil
+		}
+		if len(script) < offset+n {
+			return nil
+		}
+		data = append(data, script[offset:offset+n])
+		script = script[offset+n:]
+	}
+	return
+}
+
+func outpoint(txID string, vout int) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+// prevOutScripts finds scriptPubKey and amount of each input of tx from unspents, Address "": defaultAddress
+func prevOutScripts(tx *Tx, unspents []Unspent, defaultAddress string) (scripts [][]byte, amounts []Amount, err error) {
+	byOutpoint := make(map[string]Unspent)
+	for _, unspent := range unspents {
+		byOutpoint[outpoint(unspent.TxID, unspent.Vout)] = unspent
+	}
+	for _, input := range tx.Inputs {
+		unspent, ok := byOutpoint[outpoint(input.PrevTxID, int(input.Vout))]
+		if !ok {
+			err = fmt.Errorf("no unspent for input '%s'", outpoint(input.PrevTxID, int(input.Vout)))
+			return
+		}
+		address := unspent.Address
+		if address == "" {
+			address = defaultAddress
+		}
+		script, errA := AddressScript(address)
+		if errA != nil {
+			err = fmt.Errorf("@AddressScript('%s'): %v", address, errA)
+			return
+		}
+		scripts = append(scripts, script)
+		amounts = append(amounts, unspent.Amount)
+	}
+	return
+}
+
+// signInputs signs every P2PKH, P2WPKH, P2SH-P2WPKH and P2TR(key path) input of tx by key, in process.
+func (tx *Tx) signInputs(scripts [][]byte, amounts []Amount, key wifKey) (err error) {
+	pubKey := key.pubKey()
+	pubKeyHash := hash160(pubKey)
+	for i, script := range scripts {
+		switch {
+		case isP2PKHScript(script):
+			if !bytes.Equal(script[3:23], pubKeyHash) {
+				err = fmt.Errorf("key does not match P2PKH of input %d", i)
+				return
+			}
+			hash, errH := tx.legacySigHash(i, script, sigHashAll)
+			if errH != nil {
+				err = errH
+				return
+			}
+			signature, errS := key.signature(hash)
+			if errS != nil {
+				err = errS
+				return
+			}
+			tx.Inputs[i].ScriptSig = append(pushData(signature), pushData(pubKey)...)
+			tx.Inputs[i].Witness = nil
+		case isP2WPKHScript(script):
+			if !key.compressed {
+				err = fmt.Errorf("uncompressed key for P2WPKH of input %d", i)
+				return
+			}
+			if !bytes.Equal(script[2:22], pubKeyHash) {
+				err = fmt.Errorf("key does not match P2WPKH of input %d", i)
+				return
+			}
+			hash, errH := tx.witnessV0SigHash(i, p2pkhScript(pubKeyHash), amounts[i], sigHashAll)
+			if errH != nil {
+				err = errH
+				return
+			}
+			signature, errS := key.signature(hash)
+			if errS != nil {
+				err = errS
+				return
+			}
+			tx.Inputs[i].ScriptSig = nil
+			tx.Inputs[i].Witness = [][]byte{signature, pubKey}
+		case isP2SHScript(script):
+			redeemScript := p2wpkhScript(pubKeyHash)
+			if !bytes.Equal(script[2:22], hash160(redeemScript)) {
+				err = fmt.Errorf("key does not match P2SH-P2WPKH of input %d, other P2SH scripts are not supported", i)
+				return
+			}
+			if !key.compressed {
+				err = fmt.Errorf("uncompressed key for P2SH-P2WPKH of input %d", i)
+				return
+			}
+			hash, errH := tx.witnessV0SigHash(i, p2pkhScript(pubKeyHash), amounts[i], sigHashAll)
+			if errH != nil {
+				err = errH
+				return
+			}
+			signature, errS := key.signature(hash)
+			if errS != nil {
+				err = errS
+				return
+			}
+			tx.Inputs[i].ScriptSig = pushData(redeemScript)
+			tx.Inputs[i].Witness = [][]byte{signature, pubKey}
+		case isP2TRScript(script):
+			tweakedPrivKey, outputKey, errT := taprootTweak(key.privKey)
+			if errT != nil {
+				err = fmt.Errorf("@taprootTweak(): %v", errT)
+				return
+			}
+			if !matchesP2TR(script, outputKey) {
+				err = fmt.Errorf("key does not match P2TR of input %d", i)
+				return
+			}
+			hash, errH := tx.taprootSigHash(i, scripts, amounts, sigHashDefault)
+			if errH != nil {
+				err = errH
+				return
+			}
+			signature, errS := taprootSignature(tweakedPrivKey, hash)
+			if errS != nil {
+				err = errS
+				return
+			}
+			tx.Inputs[i].ScriptSig = nil
+			tx.Inputs[i].Witness = [][]byte{signature}
+		default:
+			err = fmt.Errorf("unsupported script %x of input %d", script, i)
+			return
+		}
+	}
+	return
+}
+
+// signRawTx signs rawTx spending unspents(Address "": address) by privKey of WIF, in process: the key does not leave it.
+// P2PKH, P2WPKH, P2SH-P2WPKH and P2TR inputs are supported, other scripts are an error.
+// P2TR is key path spending of the output key tweaked from privKey without a script tree(BIP86).
+func signRawTx(rawTx string, unspents []Unspent, address string, privKey string) (signedRawTx string, err error) {
+	tx, err := DecodeTx(rawTx)
+	if err != nil {
+		err = fmt.Errorf("@DecodeTx(rawTx): %v", err)
+		return
+	}
+	scripts, amounts, err := prevOutScripts(&tx, unspents, address)
+	if err != nil {
+		return
+	}
+	key, err := decodeWIF(privKey)
+	if err != nil {
+		err = fmt.Errorf("@decodeWIF(): %v", err)
+		return
+	}
+	if err = tx.signInputs(scripts, amounts, key); err != nil {
+		err = fmt.Errorf("@tx.signInputs(): %v", err)
+		return
+	}
+	signedRawTx, err = tx.Hex()
+	return
+}
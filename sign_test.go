@@ -0,0 +1,177 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// testPubKey1 is the compressed public key of private key 1, G
+const testPubKey1 = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+
+func TestHash160(t *testing.T) {
+	pubKey, _ := hex.DecodeString(testPubKey1)
+	if got := hex.EncodeToString(hash160(pubKey)); got != "751e76e8199196d454941c45d1b3a323f1433bd6" {
+		t.Errorf("hash160(G): %s", got)
+	}
+}
+
+func TestEcdsaSign(t *testing.T) {
+	// RFC 6979 vector of bitcoin libraries: private key 1, sha256("Satoshi Nakamoto")
+	hash := sha256.Sum256([]byte("Satoshi Nakamoto"))
+	key, _ := decodeWIF(testWIF)
+	signature, err := key.signature(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if der := hex.EncodeToString(signature); der != "3045022100"+"934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8"+"0220"+"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"+"01" {
+		t.Errorf("der: %s", der)
+	}
+	pubKey, _ := hex.DecodeString(testPubKey1)
+	if err = verifyECDSA(pubKey, signature, hash[:]); err != nil {
+		t.Errorf("verifyECDSA(): %v", err)
+	}
+	if err = verifyECDSA(testPrivKey("03").PubKey().SerializeCompressed(), signature, hash[:]); err == nil {
+		t.Errorf("verifyECDSA() by another key: no error")
+	}
+}
+
+func TestWitnessV0SigHash(t *testing.T) {
+	// native P2WPKH example of BIP143
+	tx, err := DecodeTx("0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyHash, _ := hex.DecodeString("1d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	hash, err := tx.witnessV0SigHash(1, p2pkhScript(pubKeyHash), 600000000, sigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(hash); got != "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670" {
+		t.Errorf("sighash: %s", got)
+	}
+	if _, err = tx.witnessV0SigHash(2, p2pkhScript(pubKeyHash), 600000000, sigHashAll); err == nil {
+		t.Errorf("witnessV0SigHash() of no input: no error")
+	}
+}
+
+func TestDecodeWIF(t *testing.T) {
+	key, err := decodeWIF(testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := append(make([]byte, 31), 1)
+	if !bytes.Equal(key.privKey.Serialize(), one) || !key.compressed || hex.EncodeToString(key.pubKey()) != testPubKey1 {
+		t.Errorf("key: %+v", key)
+	}
+	// uncompressed, private key 1
+	key, err = decodeWIF("5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key.privKey.Serialize(), one) || key.compressed || len(key.pubKey()) != 65 {
+		t.Errorf("key: %+v", key)
+	}
+	for _, wif := range []string{"", "privkey", testWIF[:len(testWIF)-1] + "o", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"} {
+		if _, err = decodeWIF(wif); err == nil {
+			t.Errorf("decodeWIF('%s'): no error", wif)
+		}
+	}
+}
+
+func TestSignRawTx(t *testing.T) {
+	p2wpkh := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	p2pkh := "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH" // of the compressed key 1
+	unspents := []Unspent{
+		{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true},
+		{TxID: testTxIDB, Vout: 1, Address: p2pkh, Amount: 20000, Expected: true},
+	}
+	rawTx, err := createRawTx(unspents, map[string]Amount{p2wpkh: 68000}, "68656c6c6f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no node: every input is signed in process
+	signedRawTx, err := signRawTx(rawTx, unspents, p2wpkh, testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeTx(signedRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := hex.DecodeString(testPubKey1)
+	scripts := map[string][]byte{}
+	for _, unspent := range unspents {
+		address := unspent.Address
+		if address == "" {
+			address = p2wpkh
+		}
+		scripts[unspent.TxID], _ = AddressScript(address)
+	}
+	for i, input := range tx.Inputs {
+		var hash, signature []byte
+		switch input.PrevTxID {
+		case testTxIDA:
+			if len(input.ScriptSig) != 0 || len(input.Witness) != 2 || hex.EncodeToString(input.Witness[1]) != hex.EncodeToString(pubKey) {
+				t.Fatalf("P2WPKH input: %+v", input)
+			}
+			hash, _ = tx.witnessV0SigHash(i, p2pkhScript(hash160(pubKey)), 50000, sigHashAll)
+			signature = input.Witness[0]
+		case testTxIDB:
+			if len(input.Witness) != 0 || len(input.ScriptSig) != 1+int(input.ScriptSig[0])+1+33 {
+				t.Fatalf("P2PKH input: %+v", input)
+			}
+			hash, _ = tx.legacySigHash(i, scripts[testTxIDB], sigHashAll)
+			signature = input.ScriptSig[1 : 1+input.ScriptSig[0]]
+		}
+		if signature[len(signature)-1] != sigHashAll {
+			t.Errorf("sighash type of input %d: %x", i, signature)
+		}
+		if err = verifyECDSA(pubKey, signature, hash); err != nil {
+			t.Errorf("signature of input %d: %v", i, err)
+		}
+	}
+
+	if _, err = signRawTx(rawTx, unspents, p2wpkh, "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf"); err == nil {
+		t.Errorf("signRawTx() by another key: no error")
+	}
+	if _, err = signRawTx(rawTx, unspents[:1], p2wpkh, testWIF); err == nil {
+		t.Errorf("signRawTx() without the unspent of an input: no error")
+	}
+}
+
+func TestSignRawTxP2SHP2WPKH(t *testing.T) {
+	p2shP2wpkh := "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN" // of the compressed key 1
+	unspents := []Unspent{{TxID: testTxIDA, Vout: 0, Address: p2shP2wpkh, Amount: 50000, Expected: true}}
+	rawTx, err := createRawTx(unspents, map[string]Amount{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4": 48000}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedRawTx, err := signRawTx(rawTx, unspents, "", testWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeTx(signedRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _ := hex.DecodeString(testPubKey1)
+	input := tx.Inputs[0]
+	if hex.EncodeToString(input.ScriptSig) != "16"+"0014751e76e8199196d454941c45d1b3a323f1433bd6" || len(input.Witness) != 2 || !bytes.Equal(input.Witness[1], pubKey) {
+		t.Fatalf("P2SH-P2WPKH input: %+v", input)
+	}
+	hash, _ := tx.witnessV0SigHash(0, p2pkhScript(hash160(pubKey)), 50000, sigHashAll)
+	if err = verifyECDSA(pubKey, input.Witness[0], hash); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	// other P2SH scripts are not signed, nor sent to a node with the key
+	unspents[0].Address = "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"
+	if rawTx, err = createRawTx(unspents, map[string]Amount{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4": 48000}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signRawTx(rawTx, unspents, "", testWIF); err == nil {
+		t.Errorf("signRawTx() of a foreign P2SH: no error")
+	}
+}
@@ -0,0 +1,250 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	goBitcoinCli "github.com/ideajoo/go-bitcoin-cli-light"
+)
+
+// Signer signs rawTx spending unspents(Address "": address). The key stays with the Signer,
+// and OpReturn or Payment keeps no key material.
+type Signer interface {
+	SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error)
+}
+
+const redacted = "[redacted]"
+
+func signerOrDefault(signer Signer) Signer {
+	if signer == nil {
+		return AutoSigner{}
+	}
+	return signer
+}
+
+// WIFSigner holds a private key of WIF in memory. It is marshalled and printed redacted.
+type WIFSigner struct {
+	privKey string
+}
+
+// NewWIFSigner checks privKey of WIF, mainnet or testnet
+func NewWIFSigner(privKey string) (signer WIFSigner, err error) {
+	if _, err = decodeWIF(privKey); err != nil {
+		err = fmt.Errorf("@decodeWIF(): %v", err)
+		return
+	}
+	signer.privKey = privKey
+	return
+}
+
+func (signer WIFSigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	if signer.privKey == "" {
+		err = fmt.Errorf("no key, WIFSigner is not made by NewWIFSigner")
+		return
+	}
+	signedRawTx, err = signRawTx(rawTx, unspents, address, signer.privKey)
+	return
+}
+
+func (signer WIFSigner) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+func (signer WIFSigner) String() string {
+	return "WIFSigner" + redacted
+}
+
+func (signer WIFSigner) GoString() string {
+	return signer.String()
+}
+
+// DumpPrivKeySigner dumps the key of address from the wallet of the node(dumpprivkey) at each signing,
+// and does not keep it. Legacy wallets only.
+type DumpPrivKeySigner struct{}
+
+// dumpPrivKey calls dumpprivkey, keeping the rpc error which goBitcoinCli drops
+func dumpPrivKey(bitcoinCli goBitcoinCli.BitcoinRpc, address string) (privKey string, err error) {
+	err = callRpc(bitcoinCli, "dumpprivkey", []interface{}{address}, &privKey)
+	if err != nil {
+		return
+	}
+	if privKey == "" {
+		err = fmt.Errorf("no key of '%s'", address)
+		return
+	}
+	return
+}
+
+// dumpPrivKeyUnsupported reports whether err of dumpprivkey is of a descriptor wallet, or of a node without the method
+func dumpPrivKeyUnsupported(err error) bool {
+	rpcError, ok := err.(*RpcError)
+	if !ok {
+		return false
+	}
+	switch rpcError.Code {
+	case rpcMethodNotFound:
+		return true
+	case rpcWalletError:
+		// "Only legacy wallets are supported by this command"
+		return strings.Contains(strings.ToLower(rpcError.Message), "legacy wallet")
+	}
+	return false
+}
+
+func (signer DumpPrivKeySigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	privKey, err := dumpPrivKey(bitcoinCli, address)
+	if err != nil {
+		err = fmt.Errorf("@dumpPrivKey('%s'): %v", address, err)
+		return
+	}
+	signedRawTx, err = signRawTx(rawTx, unspents, address, privKey)
+	return
+}
+
+// AutoSigner is the Signer of nil. It signs by the key of dumpprivkey like DumpPrivKeySigner,
+// and when the wallet does not support dumpprivkey(descriptor wallets), by the wallet:
+// signrawtransactionwithwallet, then walletprocesspsbt.
+type AutoSigner struct{}
+
+func (signer AutoSigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	privKey, errD := dumpPrivKey(bitcoinCli, address)
+	if errD == nil {
+		signedRawTx, err = signRawTx(rawTx, unspents, address, privKey)
+		return
+	}
+	if !dumpPrivKeyUnsupported(errD) {
+		err = fmt.Errorf("@dumpPrivKey('%s'): %v", address, errD)
+		return
+	}
+
+	signedRawTx, errW := WalletSigner{}.SignRawTx(bitcoinCli, rawTx, unspents, address)
+	if errW == nil {
+		return
+	}
+	signedRawTx, errP := WalletPsbtSigner{}.SignRawTx(bitcoinCli, rawTx, unspents, address)
+	if errP == nil {
+		return
+	}
+	err = fmt.Errorf("no way to sign by the wallet of '%s', dumpprivkey: %v, signrawtransactionwithwallet: %v, walletprocesspsbt: %v", address, errD, errW, errP)
+	return
+}
+
+// WalletSigner signs by the wallet of the node(signrawtransactionwithwallet), the key never leaves the node.
+type WalletSigner struct{}
+
+type signRawTxResult struct {
+	Hex      string `json:"hex"`
+	Complete bool   `json:"complete"`
+	Errors   []struct {
+		TxID  string `json:"txid"`
+		Vout  int    `json:"vout"`
+		Error string `json:"error"`
+	} `json:"errors"`
+}
+
+func (signer WalletSigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	result := signRawTxResult{}
+	err = callRpc(bitcoinCli, "signrawtransactionwithwallet", []interface{}{rawTx}, &result)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('signrawtransactionwithwallet'): %v", err)
+		return
+	}
+	if !result.Complete {
+		if len(result.Errors) > 0 {
+			err = fmt.Errorf("incomplete signing, input '%s': %s", outpoint(result.Errors[0].TxID, result.Errors[0].Vout), result.Errors[0].Error)
+			return
+		}
+		err = fmt.Errorf("incomplete signing")
+		return
+	}
+	signedRawTx = result.Hex
+	return
+}
+
+// WalletPsbtSigner signs a psbt by the wallet of the node(walletprocesspsbt), the key never leaves the node.
+// The signatures of P2PKH, P2WPKH, P2SH-P2WPKH and P2TR inputs are verified before extracting the signed tx,
+// also of the inputs finalized by the wallet.
+type WalletPsbtSigner struct{}
+
+func (signer WalletPsbtSigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	p, err := newPsbt(bitcoinCli, rawTx, unspents, address)
+	if err != nil {
+		err = fmt.Errorf("@newPsbt(): %v", err)
+		return
+	}
+	psbtBase64, err := p.Base64()
+	if err != nil {
+		err = fmt.Errorf("@psbt.Base64(): %v", err)
+		return
+	}
+
+	type resultWalletProcessPsbt struct {
+		Psbt     string `json:"psbt"`
+		Complete bool   `json:"complete"`
+	}
+	result := resultWalletProcessPsbt{}
+	err = callRpc(bitcoinCli, "walletprocesspsbt", []interface{}{psbtBase64, true}, &result)
+	if err != nil {
+		err = fmt.Errorf("@callRpc('walletprocesspsbt'): %v", err)
+		return
+	}
+	if !result.Complete {
+		err = fmt.Errorf("incomplete signing, the wallet lacks keys of some inputs")
+		return
+	}
+	_, signedRawTx, _, _, err = importPsbt(result.Psbt, rawTx)
+	if err != nil {
+		err = fmt.Errorf("@importPsbt(): %v", err)
+		return
+	}
+	return
+}
+
+// KeystoreSigner reads a private key of WIF from the file at Path, or from the environment variable Env,
+// at each signing, and does not keep it. Only the location is marshalled.
+type KeystoreSigner struct {
+	Path string // file of WIF, not readable by group or others. "": Env
+	Env  string // name of the environment variable
+}
+
+func (signer KeystoreSigner) privKey() (privKey string, err error) {
+	switch {
+	case signer.Path != "":
+		info, errS := os.Stat(signer.Path)
+		if errS != nil {
+			err = fmt.Errorf("@os.Stat('%s'): %v", signer.Path, errS)
+			return
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			err = fmt.Errorf("keystore '%s' is accessible by group or others, %04o", signer.Path, info.Mode().Perm())
+			return
+		}
+		data, errR := os.ReadFile(signer.Path)
+		if errR != nil {
+			err = fmt.Errorf("@os.ReadFile('%s'): %v", signer.Path, errR)
+			return
+		}
+		privKey = strings.TrimSpace(string(data))
+	case signer.Env != "":
+		privKey = strings.TrimSpace(os.Getenv(signer.Env))
+	default:
+		err = fmt.Errorf("neither of Path nor Env is set")
+		return
+	}
+	if privKey == "" {
+		err = fmt.Errorf("empty keystore")
+		return
+	}
+	return
+}
+
+func (signer KeystoreSigner) SignRawTx(bitcoinCli goBitcoinCli.BitcoinRpc, rawTx string, unspents []Unspent, address string) (signedRawTx string, err error) {
+	privKey, err := signer.privKey()
+	if err != nil {
+		err = fmt.Errorf("@signer.privKey(): %v", err)
+		return
+	}
+	signedRawTx, err = signRawTx(rawTx, unspents, address, privKey)
+	return
+}
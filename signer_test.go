@@ -0,0 +1,270 @@
+package gobitcoinopreturn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testWIFSigner() WIFSigner {
+	signer, err := NewWIFSigner(testWIF)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+// testSignerRawTx spends the 2 unspents of testListUnspent to testWIF's P2WPKH
+func testSignerRawTx(t *testing.T) (rawTx string, unspents []Unspent) {
+	unspents = []Unspent{
+		{TxID: testTxIDA, Vout: 0, Amount: 50000, Expected: true},
+		{TxID: testTxIDB, Vout: 1, Amount: 20000, Expected: true},
+	}
+	rawTx, err := createRawTx(unspents, map[string]Amount{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4": 69000}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestWIFSignerRedacted(t *testing.T) {
+	signer := testWIFSigner()
+	opReturn := OpReturn{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", Signer: signer}
+	payment := Payment{Address: opReturn.Address, Signer: &signer}
+	item := DeferredOpReturn{ID: "id", OpReturn: opReturn}
+
+	for _, v := range []interface{}{signer, &signer, opReturn, &payment, item} {
+		jsonDump, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(jsonDump), testWIF) {
+			t.Errorf("key in json: %s", jsonDump)
+		}
+		for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+			if printed := fmt.Sprintf(format, v); strings.Contains(printed, testWIF) {
+				t.Errorf("key in %s: %s", format, printed)
+			}
+		}
+	}
+	if jsonDump, _ := json.Marshal(signer); string(jsonDump) != `"[redacted]"` {
+		t.Errorf("json of WIFSigner: %s", jsonDump)
+	}
+}
+
+func TestWIFSigner(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	noRpc := (&OpReturn{}).bitcoinCli()
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+
+	signedRawTx, err := testWIFSigner().SignRawTx(noRpc, rawTx, unspents, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+	if signedRawTx != expected {
+		t.Errorf("signed: %s, want %s", signedRawTx, expected)
+	}
+
+	if _, err = NewWIFSigner("privkey"); err == nil {
+		t.Errorf("NewWIFSigner('privkey'): no error")
+	}
+	if _, err = (WIFSigner{}).SignRawTx(noRpc, rawTx, unspents, address); err == nil {
+		t.Errorf("zero WIFSigner: no error")
+	}
+}
+
+func TestDumpPrivKeySigner(t *testing.T) {
+	bitcoind := newTestBitcoind(map[string]string{
+		"dumpprivkey": `"` + testWIF + `"`,
+	})
+	defer bitcoind.Close()
+	opReturn := OpReturn{}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	rawTx, unspents := testSignerRawTx(t)
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+
+	expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+	for _, signer := range []Signer{DumpPrivKeySigner{}, signerOrDefault(nil)} {
+		signedRawTx, err := signer.SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signedRawTx != expected || !bitcoind.called("dumpprivkey") {
+			t.Errorf("signed by %T: %s, want %s", signer, signedRawTx, expected)
+		}
+	}
+	if bitcoind.called("signrawtransactionwithwallet") || bitcoind.called("walletprocesspsbt") {
+		t.Errorf("signed by the wallet with dumpprivkey")
+	}
+}
+
+func TestAutoSignerDescriptorWallet(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+	unsupported := RpcError{Code: rpcWalletError, Message: "Only legacy wallets are supported by this command"}
+
+	// signrawtransactionwithwallet
+	bitcoind := newTestBitcoind(map[string]string{
+		"signrawtransactionwithwallet": `{"hex":"` + expected + `","complete":true}`,
+	})
+	bitcoind.rpcErrors = map[string]RpcError{"dumpprivkey": unsupported}
+	defer bitcoind.Close()
+	opReturn := OpReturn{}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+	signedRawTx, err := AutoSigner{}.SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address)
+	if err != nil || signedRawTx != expected {
+		t.Errorf("signed by signrawtransactionwithwallet: %s, %v", signedRawTx, err)
+	}
+	if bitcoind.called("walletprocesspsbt") {
+		t.Errorf("walletprocesspsbt after signrawtransactionwithwallet")
+	}
+
+	// walletprocesspsbt, of a node without dumpprivkey nor signrawtransactionwithwallet
+	p, err := newPsbt(opReturn.bitcoinCli(), rawTx, unspents, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedPsbt, _ := p.Base64()
+	psbtBitcoind := newTestBitcoind(map[string]string{
+		"walletprocesspsbt": `{"psbt":"` + testSignPsbt(t, unsignedPsbt) + `","complete":true}`,
+	})
+	defer psbtBitcoind.Close()
+	opReturn.RpcConnect, opReturn.RpcPort = psbtBitcoind.connectPort()
+	signedRawTx, err = signerOrDefault(nil).SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address)
+	if err != nil || signedRawTx != expected {
+		t.Errorf("signed by walletprocesspsbt: %s, %v", signedRawTx, err)
+	}
+	if !strings.Contains(string(psbtBitcoind.params["walletprocesspsbt"]), unsignedPsbt) {
+		t.Errorf("params of walletprocesspsbt: %s", psbtBitcoind.params["walletprocesspsbt"])
+	}
+
+	// neither
+	noWallet := newTestBitcoind(map[string]string{
+		"walletprocesspsbt": `{"psbt":"` + unsignedPsbt + `","complete":false}`,
+	})
+	noWallet.rpcErrors = map[string]RpcError{"dumpprivkey": unsupported}
+	defer noWallet.Close()
+	opReturn.RpcConnect, opReturn.RpcPort = noWallet.connectPort()
+	_, err = AutoSigner{}.SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address)
+	if err == nil || !strings.Contains(err.Error(), "signrawtransactionwithwallet") || !strings.Contains(err.Error(), "walletprocesspsbt") {
+		t.Errorf("neither path: %v", err)
+	}
+
+	// a locked wallet does not fall back
+	locked := newTestBitcoind(map[string]string{})
+	locked.rpcErrors = map[string]RpcError{"dumpprivkey": {Code: -13, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}}
+	defer locked.Close()
+	opReturn.RpcConnect, opReturn.RpcPort = locked.connectPort()
+	if _, err = (AutoSigner{}).SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address); err == nil || locked.called("signrawtransactionwithwallet") {
+		t.Errorf("locked wallet: %v", err)
+	}
+}
+
+func TestWalletSigner(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	bitcoind := newTestBitcoind(map[string]string{
+		"signrawtransactionwithwallet": `{"hex":"signedhex","complete":true}`,
+	})
+	defer bitcoind.Close()
+	opReturn := OpReturn{}
+	opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+
+	signedRawTx, err := WalletSigner{}.SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedRawTx != "signedhex" || !strings.Contains(string(bitcoind.params["signrawtransactionwithwallet"]), rawTx) {
+		t.Errorf("signed: %s, params: %s", signedRawTx, bitcoind.params["signrawtransactionwithwallet"])
+	}
+
+	incomplete := newTestBitcoind(map[string]string{
+		"signrawtransactionwithwallet": `{"hex":"` + rawTx + `","complete":false,"errors":[{"txid":"` + testTxIDB + `","vout":1,"error":"Input not found or already spent"}]}`,
+	})
+	defer incomplete.Close()
+	opReturn.RpcConnect, opReturn.RpcPort = incomplete.connectPort()
+	if _, err = (WalletSigner{}).SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, ""); err == nil || !strings.Contains(err.Error(), testTxIDB+":1") {
+		t.Errorf("incomplete signing: %v", err)
+	}
+}
+
+func TestWalletPsbtSignerFinalized(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+	p, err := newPsbt((&OpReturn{}).bitcoinCli(), rawTx, unspents, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedPsbt, _ := p.Base64()
+	finalizedPsbt := testFinalizePsbt(t, testSignPsbt(t, unsignedPsbt))
+	tampered, _ := decodePsbt(finalizedPsbt)
+	signature := append([]byte{}, tampered.Inputs[1].FinalScriptWitness[0]...)
+	signature[10] ^= 0x01
+	tampered.Inputs[1].FinalScriptWitness = [][]byte{signature, tampered.Inputs[1].FinalScriptWitness[1]}
+	tamperedPsbt, _ := tampered.Base64()
+
+	for _, test := range []struct {
+		psbt    string
+		wantErr bool
+	}{{finalizedPsbt, false}, {tamperedPsbt, true}} {
+		bitcoind := newTestBitcoind(map[string]string{
+			"walletprocesspsbt": `{"psbt":"` + test.psbt + `","complete":true}`,
+		})
+		opReturn := OpReturn{}
+		opReturn.RpcConnect, opReturn.RpcPort = bitcoind.connectPort()
+		signedRawTx, err := WalletPsbtSigner{}.SignRawTx(opReturn.bitcoinCli(), rawTx, unspents, address)
+		bitcoind.Close()
+		if test.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "input 1") {
+				t.Errorf("finalized by the wallet with a tampered signature: %v", err)
+			}
+			continue
+		}
+		if err != nil || signedRawTx != expected {
+			t.Errorf("finalized by the wallet: %s, %v", signedRawTx, err)
+		}
+	}
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	rawTx, unspents := testSignerRawTx(t)
+	noRpc := (&OpReturn{}).bitcoinCli()
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	expected, _ := signRawTx(rawTx, unspents, address, testWIF)
+
+	path := filepath.Join(t.TempDir(), "key.wif")
+	if err := os.WriteFile(path, []byte(testWIF+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer := KeystoreSigner{Path: path}
+	signedRawTx, err := signer.SignRawTx(noRpc, rawTx, unspents, address)
+	if err != nil || signedRawTx != expected {
+		t.Errorf("signed by file: %s, %v", signedRawTx, err)
+	}
+	if jsonDump, _ := json.Marshal(OpReturn{Signer: signer}); strings.Contains(string(jsonDump), testWIF) {
+		t.Errorf("key in json: %s", jsonDump)
+	}
+
+	if err = os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.SignRawTx(noRpc, rawTx, unspents, address); err == nil {
+		t.Errorf("keystore readable by others: no error")
+	}
+
+	t.Setenv("GO_BITCOIN_OPRETURN_TEST_WIF", testWIF)
+	signedRawTx, err = KeystoreSigner{Env: "GO_BITCOIN_OPRETURN_TEST_WIF"}.SignRawTx(noRpc, rawTx, unspents, address)
+	if err != nil || signedRawTx != expected {
+		t.Errorf("signed by env: %s, %v", signedRawTx, err)
+	}
+	for _, signer := range []KeystoreSigner{{}, {Env: "GO_BITCOIN_OPRETURN_TEST_NO_WIF"}, {Path: path + ".missing"}} {
+		if _, err = signer.SignRawTx(noRpc, rawTx, unspents, address); err == nil {
+			t.Errorf("%+v: no error", signer)
+		}
+	}
+}
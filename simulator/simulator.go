@@ -0,0 +1,143 @@
+package simulator
+
+import (
+	"fmt"
+
+	goBitcoinOpReturn "github.com/ideajoo/go-bitcoin-opreturn"
+)
+
+// Step is one recorded point in time: the unspents of the address, the remote fees and the job to fund.
+type Step struct {
+	Unspents   []goBitcoinOpReturn.Unspent
+	RemoteFees goBitcoinOpReturn.RemoteFees
+	PayInfos   map[string]goBitcoinOpReturn.Amount // payment targets, or extra outputs of an OpReturn
+	MessageHex string
+	Payment    bool // false: OpReturn, true: Payment
+}
+
+type Config struct {
+	Address                 string
+	SpeedLevelFee           goBitcoinOpReturn.SpeedLevel
+	ConfirmationTarget      int                      // blocks instead of SpeedLevelFee, 0: SpeedLevelFee
+	LimitFeeSatsPerVByteMin float64                  // Sats 1
+	LimitFeeSatsPerVByteMax float64                  // Sats 1
+	LimitFeeSats            goBitcoinOpReturn.Amount // OpReturn only
+	LongTermFeePerVByte     float64                  // Sats 1
+	CoinSelector            goBitcoinOpReturn.CoinSelector
+	Confirmations           int // 0: 3, as OpReturn.Run() and Payment.Run()
+}
+
+type StepResult struct {
+	FeePerVByte    float64 // Sats 1
+	Fee            goBitcoinOpReturn.Amount
+	CountInputs    int
+	Change         goBitcoinOpReturn.Amount
+	Waste          goBitcoinOpReturn.Amount
+	PoolSizeBefore int
+	PoolSizeAfter  int
+	Error          string `json:",omitempty"`
+}
+
+type Report struct {
+	Steps         []StepResult
+	TotalFees     goBitcoinOpReturn.Amount
+	TotalWaste    goBitcoinOpReturn.Amount
+	ChangeOutputs int
+	PoolGrowth    int // sum of (PoolSizeAfter - PoolSizeBefore)
+	Failures      int
+}
+
+func Run(config Config, steps []Step) (report Report) {
+	report.Steps = make([]StepResult, 0)
+	for _, step := range steps {
+		result := RunStep(config, step)
+		report.Steps = append(report.Steps, result)
+		if result.Error != "" {
+			report.Failures += 1
+			continue
+		}
+		report.TotalFees += result.Fee
+		report.TotalWaste += result.Waste
+		if result.Change > 0 {
+			report.ChangeOutputs += 1
+		}
+		report.PoolGrowth += result.PoolSizeAfter - result.PoolSizeBefore
+	}
+	return
+}
+
+func RunStep(config Config, step Step) (result StepResult) {
+	confirmations := config.Confirmations
+	if confirmations <= 0 {
+		confirmations = 3
+	}
+
+	// copy, selection marks Expected and may add balance pay-info
+	unspents := append([]goBitcoinOpReturn.Unspent{}, step.Unspents...)
+	payInfos := make(map[string]goBitcoinOpReturn.Amount)
+	for address, amount := range step.PayInfos {
+		payInfos[address] = amount
+	}
+
+	result.PoolSizeBefore = len(unspents)
+	var err error
+	if config.ConfirmationTarget > 0 {
+		result.FeePerVByte, err = step.RemoteFees.FeePerVByteForTarget(config.LimitFeeSatsPerVByteMin, config.LimitFeeSatsPerVByteMax, config.ConfirmationTarget)
+	} else {
+		result.FeePerVByte, err = step.RemoteFees.FeePerVByte(config.LimitFeeSatsPerVByteMin, config.LimitFeeSatsPerVByteMax, config.SpeedLevelFee)
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("%v", err)
+		result.PoolSizeAfter = result.PoolSizeBefore
+		return
+	}
+
+	var report goBitcoinOpReturn.SelectionReport
+	switch step.Payment {
+	case true:
+		payment := goBitcoinOpReturn.Payment{
+			Address:             config.Address,
+			PayInfos:            payInfos,
+			Unspents:            unspents,
+			Confirmations:       confirmations,
+			CoinSelector:        config.CoinSelector,
+			SpeedLevelFee:       config.SpeedLevelFee,
+			LimitFeePerVByteMax: config.LimitFeeSatsPerVByteMax,
+			LimitFeePerVByteMin: config.LimitFeeSatsPerVByteMin,
+			LongTermFeePerVByte: config.LongTermFeePerVByte,
+		}
+		err = payment.SelectUnspents(result.FeePerVByte)
+		report = payment.SelectionReport
+	case false:
+		opReturn := goBitcoinOpReturn.OpReturn{
+			Address:                 config.Address,
+			PayInfos:                payInfos,
+			MessageHex:              step.MessageHex,
+			Unspents:                unspents,
+			Confirmations:           confirmations,
+			CoinSelector:            config.CoinSelector,
+			SpeedLevelFee:           config.SpeedLevelFee,
+			LimitFeeSatsPerVByteMax: config.LimitFeeSatsPerVByteMax,
+			LimitFeeSatsPerVByteMin: config.LimitFeeSatsPerVByteMin,
+			LimitFeeSats:            config.LimitFeeSats,
+			LongTermFeePerVByte:     config.LongTermFeePerVByte,
+		}
+		err = opReturn.SelectUnspents(result.FeePerVByte)
+		report = opReturn.SelectionReport
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("%v", err)
+		result.PoolSizeAfter = result.PoolSizeBefore
+		return
+	}
+
+	result.Fee = report.Fee
+	result.CountInputs = len(report.Inputs)
+	result.Change = report.Change
+	result.Waste = report.Waste
+	result.PoolSizeAfter = result.PoolSizeBefore - result.CountInputs
+	if result.Change > 0 {
+		result.PoolSizeAfter += 1
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package simulator
+
+import (
+	"testing"
+
+	goBitcoinOpReturn "github.com/ideajoo/go-bitcoin-opreturn"
+)
+
+func TestRun(t *testing.T) {
+	unspents := []goBitcoinOpReturn.Unspent{
+		{TxID: "a", Vout: 0, Amount: 100000, Confirmations: 10},
+		{TxID: "b", Vout: 0, Amount: 20000, Confirmations: 10},
+		{TxID: "c", Vout: 0, Amount: 1000, Confirmations: 10},
+	}
+	remoteFees := goBitcoinOpReturn.RemoteFees{FastestFee: 20, HalfHourFee: 15, HourFee: 10, EconomyFee: 5, MinimumFee: 2}
+	steps := []Step{
+		{Unspents: unspents, RemoteFees: remoteFees, MessageHex: "68656c6c6f"},
+		{Unspents: unspents, RemoteFees: remoteFees, PayInfos: map[string]goBitcoinOpReturn.Amount{"bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c": 50000}, Payment: true},
+		{Unspents: unspents, RemoteFees: remoteFees, PayInfos: map[string]goBitcoinOpReturn.Amount{"bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c": goBitcoinOpReturn.SatoshiPerBitcoin}, Payment: true},
+	}
+	config := Config{
+		Address:                 "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c",
+		SpeedLevelFee:           "Level4",
+		LimitFeeSatsPerVByteMin: 1,
+		LimitFeeSatsPerVByteMax: 50,
+	}
+
+	report := Run(config, steps)
+	if len(report.Steps) != 3 || report.Failures != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Steps[0].FeePerVByte != 10 || report.Steps[0].CountInputs != 1 || report.Steps[0].Change <= 0 {
+		t.Fatalf("unexpected first step %+v", report.Steps[0])
+	}
+	if report.ChangeOutputs != 2 || report.PoolGrowth != 0 {
+		t.Fatalf("unexpected totals %+v", report)
+	}
+	if report.TotalFees != report.Steps[0].Fee+report.Steps[1].Fee {
+		t.Fatalf("unexpected total fees %+v", report)
+	}
+	if unspents[0].Expected {
+		t.Fatal("simulation must not change the recorded snapshot")
+	}
+}
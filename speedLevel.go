@@ -0,0 +1,90 @@
+package gobitcoinopreturn
+
+import (
+	"fmt"
+	"math"
+)
+
+// SpeedLevel picks a fee rate among RemoteFees levels.
+type SpeedLevel string
+
+const (
+	SpeedLevel1 SpeedLevel = "Level1" // MinimumFee
+	SpeedLevel2 SpeedLevel = "Level2" // EconomyFee
+	SpeedLevel3 SpeedLevel = "Level3" // (EconomyFee + HourFee) / 2
+	SpeedLevel4 SpeedLevel = "Level4" // HourFee
+	SpeedLevel5 SpeedLevel = "Level5" // (HourFee + HalfHourFee) / 2
+	SpeedLevel6 SpeedLevel = "Level6" // HalfHourFee
+	SpeedLevel7 SpeedLevel = "Level7" // (HalfHourFee + FastestFee) / 2
+	SpeedLevel8 SpeedLevel = "Level8" // FastestFee
+
+	DefaultSpeedLevel = SpeedLevel6
+)
+
+// Validate accepts "" for DefaultSpeedLevel and Level1 ~ Level8.
+func (speedLevel SpeedLevel) Validate() (err error) {
+	switch speedLevel {
+	case "", SpeedLevel1, SpeedLevel2, SpeedLevel3, SpeedLevel4, SpeedLevel5, SpeedLevel6, SpeedLevel7, SpeedLevel8:
+		return
+	}
+	err = fmt.Errorf("unknown speed level['%s'], expected Level1 ~ Level8", string(speedLevel))
+	return
+}
+
+// TargetFeeEstimator estimates fees for confirmation in confTarget blocks by itself, like bitcoind.
+// Other estimators are interpolated between the levels of RemoteFees.
+type TargetFeeEstimator interface {
+	EstimateFeeForTarget(confTarget int) (feePerVByte float64, err error)
+}
+
+const maxConfirmationTarget = confTargetMinimumFee
+
+func validateConfirmationTarget(confTarget int) (err error) {
+	if confTarget < 1 || confTarget > maxConfirmationTarget {
+		err = fmt.Errorf("incorrect confirmation target[%d], expected 1 ~ %d blocks", confTarget, maxConfirmationTarget)
+	}
+	return
+}
+
+// FeePerVByteForTarget interpolates sats/vbyte for confirmation in confTarget blocks
+// between the levels of RemoteFees at 1, 3, 6, 144 and 1008 blocks, on a log scale of blocks.
+func (remoteFees RemoteFees) FeePerVByteForTarget(limitFeePerVByteMin float64, limitFeePerVByteMax float64, confTarget int) (fee float64, err error) {
+	if err = validateConfirmationTarget(confTarget); err != nil {
+		return
+	}
+
+	buckets := []struct {
+		confTarget int
+		fee        float64
+	}{
+		{confTargetFastestFee, remoteFees.FastestFee},
+		{confTargetHalfHourFee, remoteFees.HalfHourFee},
+		{confTargetHourFee, remoteFees.HourFee},
+		{confTargetEconomyFee, remoteFees.EconomyFee},
+		{confTargetMinimumFee, remoteFees.MinimumFee},
+	}
+	fee = buckets[len(buckets)-1].fee
+	for i := 1; i < len(buckets); i++ {
+		lower, upper := buckets[i-1], buckets[i]
+		if confTarget > upper.confTarget {
+			continue
+		}
+		ratio := math.Log(float64(confTarget)/float64(lower.confTarget)) / math.Log(float64(upper.confTarget)/float64(lower.confTarget))
+		fee = lower.fee + (upper.fee-lower.fee)*ratio
+		break
+	}
+
+	fee = clampFeePerVByte(fee, limitFeePerVByteMin, limitFeePerVByteMax)
+	return
+}
+
+// clampFeePerVByte clamps fee into the limits, limitFeePerVByteMax 0: no upper limit
+func clampFeePerVByte(fee float64, limitFeePerVByteMin float64, limitFeePerVByteMax float64) float64 {
+	if fee < limitFeePerVByteMin {
+		return limitFeePerVByteMin // min
+	}
+	if limitFeePerVByteMax > 0 && fee > limitFeePerVByteMax {
+		return limitFeePerVByteMax // max
+	}
+	return fee
+}
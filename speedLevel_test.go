@@ -0,0 +1,70 @@
+package gobitcoinopreturn
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpeedLevelValidate(t *testing.T) {
+	for _, speedLevel := range []SpeedLevel{"", SpeedLevel1, SpeedLevel8} {
+		if err := speedLevel.Validate(); err != nil {
+			t.Errorf("'%s': %v", speedLevel, err)
+		}
+	}
+	for _, speedLevel := range []SpeedLevel{"level4", "Lv4", "Level9", "4"} {
+		if err := speedLevel.Validate(); err == nil {
+			t.Errorf("'%s': expected an error", speedLevel)
+		}
+	}
+
+	remoteFees := RemoteFees{FastestFee: 40, HalfHourFee: 30, HourFee: 20, EconomyFee: 10, MinimumFee: 2}
+	if fee, err := remoteFees.FeePerVByte(1, 100, ""); err != nil || fee != 30 {
+		t.Errorf("default fee: %f, err: %v", fee, err)
+	}
+	if _, err := remoteFees.FeePerVByte(1, 100, "Lv4"); err == nil {
+		t.Errorf("expected an error for 'Lv4'")
+	}
+	if _, _, err := getFeePerVByte3(StaticFeeEstimator{FeePerVByte: 5}, 1, 100, "level4", 0); err == nil {
+		t.Errorf("expected an error for 'level4'")
+	}
+	if _, _, err := getFeePerVByte3(StaticFeeEstimator{FeePerVByte: 5}, 1, 100, SpeedLevel4, 6); err == nil {
+		t.Errorf("expected an error for both of speed level and confirmation target")
+	}
+}
+
+func TestFeePerVByteForTarget(t *testing.T) {
+	remoteFees := RemoteFees{FastestFee: 40, HalfHourFee: 30, HourFee: 20, EconomyFee: 10, MinimumFee: 2}
+	testCases := []struct {
+		confTarget int
+		fee        float64
+	}{
+		{1, 40},
+		{3, 30},
+		{6, 20},
+		{144, 10},
+		{1008, 2},
+		{2, 40 - 10*math.Log(2)/math.Log(3)},
+		{12, 20 - 10*math.Log(2)/math.Log(24)},
+	}
+	for _, testCase := range testCases {
+		fee, err := remoteFees.FeePerVByteForTarget(1, 100, testCase.confTarget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(fee-testCase.fee) > 1e-9 {
+			t.Errorf("%d blocks: fee %f, expected %f", testCase.confTarget, fee, testCase.fee)
+		}
+	}
+
+	if fee, _ := remoteFees.FeePerVByteForTarget(15, 100, 144); fee != 15 {
+		t.Errorf("fee %f, expected the min limit 15", fee)
+	}
+	if fee, _ := remoteFees.FeePerVByteForTarget(1, 0, 1); fee != 40 {
+		t.Errorf("fee %f, expected 40 without the max limit", fee)
+	}
+	for _, confTarget := range []int{-1, 0, 1009} {
+		if _, err := remoteFees.FeePerVByteForTarget(1, 100, confTarget); err == nil {
+			t.Errorf("%d blocks: expected an error", confTarget)
+		}
+	}
+}
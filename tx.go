@@ -0,0 +1,398 @@
+package gobitcoinopreturn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+const txVersion = 2
+
+type TxIn struct {
+	PrevTxID  string // hex in RPC order, reversed from the serialized outpoint
+	Vout      uint32
+	ScriptSig []byte
+	Sequence  uint32
+	Witness   [][]byte
+}
+
+type TxOut struct {
+	Value        Amount
+	ScriptPubKey []byte
+}
+
+// Tx is a bitcoin transaction, serialized as BIP144 with witnesses of any input.
+type Tx struct {
+	Version  int32
+	Inputs   []TxIn
+	Outputs  []TxOut
+	LockTime uint32
+}
+
+func (tx *Tx) HasWitness() bool {
+	for _, input := range tx.Inputs {
+		if len(input.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCompactSize(buffer *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buffer.WriteByte(byte(n))
+	case n <= 0xffff:
+		buffer.WriteByte(0xfd)
+		binary.Write(buffer, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		buffer.WriteByte(0xfe)
+		binary.Write(buffer, binary.LittleEndian, uint32(n))
+	default:
+		buffer.WriteByte(0xff)
+		binary.Write(buffer, binary.LittleEndian, n)
+	}
+}
+
+func writeVarBytes(buffer *bytes.Buffer, data []byte) {
+	writeCompactSize(buffer, uint64(len(data)))
+	buffer.Write(data)
+}
+
+// txIDBytes decodes a txid in RPC order into the serialized order
+func txIDBytes(txID string) (hash []byte, err error) {
+	hash, err = hex.DecodeString(txID)
+	if err != nil {
+		err = fmt.Errorf("@hex.DecodeString('%s'): %v", txID, err)
+		return
+	}
+	if len(hash) != 32 {
+		err = fmt.Errorf("incorrect txid '%s': %d bytes", txID, len(hash))
+		return
+	}
+	hash = reverseBytes(hash)
+	return
+}
+
+func reverseBytes(data []byte) (reversed []byte) {
+	reversed = make([]byte, len(data))
+	for i := range data {
+		reversed[len(data)-1-i] = data[i]
+	}
+	return
+}
+
+func (tx *Tx) serialize(withWitness bool) (serialized []byte, err error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, tx.Version)
+	withWitness = withWitness && tx.HasWitness()
+	if withWitness {
+		buffer.Write([]byte{0x00, 0x01}) // marker & flag
+	}
+
+	writeCompactSize(buffer, uint64(len(tx.Inputs)))
+	for _, input := range tx.Inputs {
+		hash, errH := txIDBytes(input.PrevTxID)
+		if errH != nil {
+			err = errH
+			return
+		}
+		buffer.Write(hash)
+		binary.Write(buffer, binary.LittleEndian, input.Vout)
+		writeVarBytes(buffer, input.ScriptSig)
+		binary.Write(buffer, binary.LittleEndian, input.Sequence)
+	}
+	writeCompactSize(buffer, uint64(len(tx.Outputs)))
+	for _, output := range tx.Outputs {
+		binary.Write(buffer, binary.LittleEndian, int64(output.Value))
+		writeVarBytes(buffer, output.ScriptPubKey)
+	}
+	if withWitness {
+		for _, input := range tx.Inputs {
+			writeCompactSize(buffer, uint64(len(input.Witness)))
+			for _, item := range input.Witness {
+				writeVarBytes(buffer, item)
+			}
+		}
+	}
+	binary.Write(buffer, binary.LittleEndian, tx.LockTime)
+	serialized = buffer.Bytes()
+	return
+}
+
+// Serialize encodes tx with witnesses, as sendrawtransaction takes.
+func (tx *Tx) Serialize() (serialized []byte, err error) {
+	return tx.serialize(true)
+}
+
+// SerializeNoWitness encodes tx without witnesses, as hashed for the txid.
+func (tx *Tx) SerializeNoWitness() (serialized []byte, err error) {
+	return tx.serialize(false)
+}
+
+func (tx *Tx) Hex() (txHex string, err error) {
+	serialized, err := tx.Serialize()
+	if err != nil {
+		return
+	}
+	txHex = hex.EncodeToString(serialized)
+	return
+}
+
+func (tx *Tx) TxID() (txID string, err error) {
+	serialized, err := tx.SerializeNoWitness()
+	if err != nil {
+		return
+	}
+	txID = hex.EncodeToString(reverseBytes(doubleSha256(serialized)))
+	return
+}
+
+// WTxID is the hash with witnesses(BIP141), same as TxID without witnesses.
+func (tx *Tx) WTxID() (wTxID string, err error) {
+	serialized, err := tx.Serialize()
+	if err != nil {
+		return
+	}
+	wTxID = hex.EncodeToString(reverseBytes(doubleSha256(serialized)))
+	return
+}
+
+// Weight is size without witness * 3 + total size
+func (tx *Tx) Weight() (weight int, err error) {
+	base, err := tx.SerializeNoWitness()
+	if err != nil {
+		return
+	}
+	total, err := tx.Serialize()
+	if err != nil {
+		return
+	}
+	weight = len(base)*3 + len(total)
+	return
+}
+
+// txReader reads a serialized tx
+type txReader struct {
+	data []byte
+	pos  int
+}
+
+func (reader *txReader) read(n int) (read []byte, err error) {
+	if n < 0 || reader.pos+n > len(reader.data) {
+		err = fmt.Errorf("unexpected end of tx at %d", reader.pos)
+		return
+	}
+	read = reader.data[reader.pos : reader.pos+n]
+	reader.pos += n
+	return
+}
+
+func (reader *txReader) readUint32() (n uint32, err error) {
+	read, err := reader.read(4)
+	if err != nil {
+		return
+	}
+	n = binary.LittleEndian.Uint32(read)
+	return
+}
+
+func (reader *txReader) readCompactSize() (n uint64, err error) {
+	prefix, err := reader.read(1)
+	if err != nil {
+		return
+	}
+	size := 0
+	switch prefix[0] {
+	case 0xfd:
+		size = 2
+	case 0xfe:
+		size = 4
+	case 0xff:
+		size = 8
+	default:
+		n = uint64(prefix[0])
+		return
+	}
+	read, err := reader.read(size)
+	if err != nil {
+		return
+	}
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(read[i])
+	}
+	return
+}
+
+func (reader *txReader) readVarBytes() (read []byte, err error) {
+	n, err := reader.readCompactSize()
+	if err != nil {
+		return
+	}
+	if n > uint64(len(reader.data)) {
+		err = fmt.Errorf("unexpected length %d at %d", n, reader.pos)
+		return
+	}
+	read, err = reader.read(int(n))
+	if err != nil {
+		return
+	}
+	read = append([]byte{}, read...)
+	return
+}
+
+// DecodeTx decodes a serialized tx hex, with or without witnesses.
+func DecodeTx(txHex string) (tx Tx, err error) {
+	data, err := hex.DecodeString(txHex)
+	if err != nil {
+		err = fmt.Errorf("@hex.DecodeString(txHex): %v", err)
+		return
+	}
+	reader := &txReader{data: data}
+
+	version, err := reader.readUint32()
+	if err != nil {
+		return
+	}
+	tx.Version = int32(version)
+	hasWitness := reader.pos+1 < len(data) && data[reader.pos] == 0x00 && data[reader.pos+1] == 0x01
+	if hasWitness {
+		reader.pos += 2 // marker & flag
+	}
+
+	countTxIns, err := reader.readCompactSize()
+	if err != nil {
+		return
+	}
+	if countTxIns > uint64(len(data)) {
+		err = fmt.Errorf("unexpected count of inputs %d", countTxIns)
+		return
+	}
+	tx.Inputs = make([]TxIn, countTxIns)
+	for i := range tx.Inputs {
+		hash, errR := reader.read(32)
+		if errR != nil {
+			err = errR
+			return
+		}
+		tx.Inputs[i].PrevTxID = hex.EncodeToString(reverseBytes(hash))
+		if tx.Inputs[i].Vout, err = reader.readUint32(); err != nil {
+			return
+		}
+		if tx.Inputs[i].ScriptSig, err = reader.readVarBytes(); err != nil {
+			return
+		}
+		if tx.Inputs[i].Sequence, err = reader.readUint32(); err != nil {
+			return
+		}
+	}
+
+	countTxOuts, err := reader.readCompactSize()
+	if err != nil {
+		return
+	}
+	if countTxOuts > uint64(len(data)) {
+		err = fmt.Errorf("unexpected count of outputs %d", countTxOuts)
+		return
+	}
+	tx.Outputs = make([]TxOut, countTxOuts)
+	for i := range tx.Outputs {
+		value, errR := reader.read(8)
+		if errR != nil {
+			err = errR
+			return
+		}
+		tx.Outputs[i].Value = Amount(binary.LittleEndian.Uint64(value))
+		if tx.Outputs[i].ScriptPubKey, err = reader.readVarBytes(); err != nil {
+			return
+		}
+	}
+
+	if hasWitness {
+		for i := range tx.Inputs {
+			countItems, errR := reader.readCompactSize()
+			if errR != nil {
+				err = errR
+				return
+			}
+			if countItems > uint64(len(data)) {
+				err = fmt.Errorf("unexpected count of witness items %d", countItems)
+				return
+			}
+			for j := uint64(0); j < countItems; j++ {
+				item, errI := reader.readVarBytes()
+				if errI != nil {
+					err = errI
+					return
+				}
+				tx.Inputs[i].Witness = append(tx.Inputs[i].Witness, item)
+			}
+		}
+	}
+	if tx.LockTime, err = reader.readUint32(); err != nil {
+		return
+	}
+	if reader.pos != len(data) {
+		err = fmt.Errorf("unexpected %d bytes after locktime", len(data)-reader.pos)
+		return
+	}
+	return
+}
+
+// newUnsignedTx makes a BIP125 replaceable tx of the expected unspents, paying payInfos sorted by address,
+// then OP_RETURN of messageHex("": none) last, as createrawtransaction did.
+func newUnsignedTx(unspents []Unspent, payInfos map[string]Amount, messageHex string) (tx Tx, err error) {
+	tx = Tx{Version: txVersion}
+	for _, unspent := range unspents {
+		if !unspent.Expected {
+			continue
+		}
+		if unspent.Vout < 0 {
+			err = fmt.Errorf("incorrect vout %d of '%s'", unspent.Vout, unspent.TxID)
+			return
+		}
+		tx.Inputs = append(tx.Inputs, TxIn{PrevTxID: unspent.TxID, Vout: uint32(unspent.Vout), Sequence: sequenceReplaceable})
+	}
+
+	addresses := make([]string, 0, len(payInfos))
+	for address := range payInfos {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if payInfos[address] < 0 {
+			continue
+		}
+		script, errA := AddressScript(address)
+		if errA != nil {
+			err = fmt.Errorf("@AddressScript('%s'): %v", address, errA)
+			return
+		}
+		tx.Outputs = append(tx.Outputs, TxOut{Value: payInfos[address], ScriptPubKey: script})
+	}
+	if messageHex != "" {
+		data, errH := hex.DecodeString(messageHex)
+		if errH != nil {
+			err = fmt.Errorf("@hex.DecodeString(messageHex): %v", errH)
+			return
+		}
+		tx.Outputs = append(tx.Outputs, TxOut{ScriptPubKey: opReturnScript(data)})
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		err = fmt.Errorf("tx of %d inputs and %d outputs", len(tx.Inputs), len(tx.Outputs))
+		return
+	}
+	return
+}
+
+// createRawTx serializes newUnsignedTx, in place of createrawtransaction of bitcoind.
+func createRawTx(unspents []Unspent, payInfos map[string]Amount, messageHex string) (rawTx string, err error) {
+	tx, err := newUnsignedTx(unspents, payInfos, messageHex)
+	if err != nil {
+		return
+	}
+	rawTx, err = tx.Hex()
+	return
+}
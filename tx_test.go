@@ -0,0 +1,100 @@
+package gobitcoinopreturn
+
+import (
+	"strings"
+	"testing"
+)
+
+// coinbase of the genesis block
+const testGenesisTx = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestDecodeTx(t *testing.T) {
+	tx, err := DecodeTx(testGenesisTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txID, err := tx.TxID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wTxID, err := tx.WTxID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txID != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" || wTxID != txID {
+		t.Errorf("txid: %s, wtxid: %s", txID, wTxID)
+	}
+	if tx.Version != 1 || len(tx.Inputs) != 1 || tx.Inputs[0].Vout != 0xffffffff || len(tx.Outputs) != 1 || tx.Outputs[0].Value != 50*SatoshiPerBitcoin {
+		t.Errorf("tx: %+v", tx)
+	}
+	if txHex, _ := tx.Hex(); txHex != testGenesisTx {
+		t.Errorf("hex: %s", txHex)
+	}
+}
+
+func TestDecodeTxWitness(t *testing.T) {
+	tx, err := DecodeTx(testSignedRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.HasWitness() || len(tx.Inputs[0].Witness) != 2 || len(tx.Inputs[0].Witness[0]) != 72 || tx.Inputs[0].Sequence != sequenceReplaceable {
+		t.Errorf("tx: %+v", tx)
+	}
+	if txHex, _ := tx.Hex(); txHex != testSignedRawTx {
+		t.Errorf("hex: %s", txHex)
+	}
+	weight, err := tx.Weight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weight != 98*3+208 || vSizeOfWeight(weight) != 126 { // 98 bytes without witness, 208 bytes in total
+		t.Errorf("weight: %d", weight)
+	}
+
+	// txid does not commit to the witness, wtxid does
+	txID, _ := tx.TxID()
+	wTxID, _ := tx.WTxID()
+	stripped := tx
+	stripped.Inputs = []TxIn{tx.Inputs[0]}
+	stripped.Inputs[0].Witness = nil
+	strippedTxID, _ := stripped.TxID()
+	strippedWTxID, _ := stripped.WTxID()
+	if txID == wTxID || txID != strippedTxID || strippedWTxID != strippedTxID {
+		t.Errorf("txid: %s, wtxid: %s, stripped: %s", txID, wTxID, strippedTxID)
+	}
+
+	for _, txHex := range []string{"", "02000000", testSignedRawTx + "00", testSignedRawTx[:len(testSignedRawTx)-2]} {
+		if _, err = DecodeTx(txHex); err == nil {
+			t.Errorf("DecodeTx('%s'): no error", txHex)
+		}
+	}
+}
+
+func TestCreateRawTx(t *testing.T) {
+	address := "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"
+	unspents := []Unspent{
+		{TxID: testTxIDA, Vout: 1, Amount: 50000, Expected: true},
+		{TxID: testTxIDB, Vout: 0, Amount: 20000},
+	}
+	rawTx, err := createRawTx(unspents, map[string]Amount{address: 48745, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2": 1000}, "68656c6c6f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// outputs sorted by address, OP_RETURN last
+	expected := "02000000" +
+		"01" + testTxIDA + "01000000" + "00" + "fdffffff" +
+		"03" + "e803000000000000" + "19" + "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac" +
+		"69be000000000000" + "16" + testScriptPubKey +
+		"0000000000000000" + "07" + "6a0568656c6c6f" +
+		"00000000"
+	if rawTx != expected {
+		t.Errorf("rawTx: %s\nwant:  %s", rawTx, expected)
+	}
+
+	if _, err = createRawTx(unspents, map[string]Amount{"bc1qinvalid": 1000}, ""); err == nil || !strings.Contains(err.Error(), "bc1qinvalid") {
+		t.Errorf("invalid address: %v", err)
+	}
+	if _, err = createRawTx([]Unspent{{TxID: "aa", Expected: true}}, map[string]Amount{address: 1000}, ""); err == nil {
+		t.Errorf("invalid txid: no error")
+	}
+}
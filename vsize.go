@@ -0,0 +1,157 @@
+package gobitcoinopreturn
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	AddressTypeP2PKH  = "P2PKH" // Legacy
+	AddressTypeP2SH   = "P2SH"
+	AddressTypeP2WPKH = "P2WPKH"
+	AddressTypeP2WSH  = "P2WSH"
+	AddressTypeP2TR   = "P2TR"
+)
+
+// AddressType guesses the output type of a mainnet, testnet, signet or regtest address from its prefix and length.
+func AddressType(address string) (addressType string) {
+	tAddress := strings.ToLower(address)
+	for _, hrp := range []string{"bcrt1", "bc1", "tb1"} { // bcrt1 before bc1
+		if !strings.HasPrefix(tAddress, hrp) {
+			continue
+		}
+		data := tAddress[len(hrp):] // witness version + program + checksum
+		switch {
+		case strings.HasPrefix(data, "q") && len(data) == 39:
+			addressType = AddressTypeP2WPKH // 20 bytes program
+		case strings.HasPrefix(data, "q") && len(data) == 59:
+			addressType = AddressTypeP2WSH // 32 bytes program
+		case strings.HasPrefix(data, "p") && len(data) == 59:
+			addressType = AddressTypeP2TR // 32 bytes program
+		default:
+			continue
+		}
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(address, "3"), strings.HasPrefix(address, "2"): // mainnet, testnet & regtest
+		addressType = AddressTypeP2SH
+	default: // 1, m, n
+		addressType = AddressTypeP2PKH
+	}
+	return
+}
+
+func isWitnessType(addressType string) bool {
+	return addressType != AddressTypeP2PKH
+}
+
+// size of scriptPubKey
+func scriptPubKeySize(addressType string) (size int) {
+	switch addressType {
+	case AddressTypeP2SH:
+		size = 23 // OP_HASH160 <20> OP_EQUAL
+	case AddressTypeP2WPKH:
+		size = 22 // OP_0 <20>
+	case AddressTypeP2WSH, AddressTypeP2TR:
+		size = 34 // OP_0 <32>, OP_1 <32>
+	default:
+		size = 25 // OP_DUP OP_HASH160 <20> OP_EQUALVERIFY OP_CHECKSIG
+	}
+	return
+}
+
+// OP_RETURN <push data>
+func opReturnScriptSize(dataSize int) (size int) {
+	switch {
+	case dataSize == 0:
+		size = 1 + 1 // OP_RETURN OP_0
+	case dataSize <= 75:
+		size = 1 + 1 + dataSize // OP_RETURN <n> data
+	case dataSize <= 255:
+		size = 1 + 2 + dataSize // OP_RETURN OP_PUSHDATA1 <n> data
+	default:
+		size = 1 + 3 + dataSize // OP_RETURN OP_PUSHDATA2 <n> data
+	}
+	return
+}
+
+func compactSizeLen(n uint64) (size int) {
+	switch {
+	case n < 0xfd:
+		size = 1
+	case n <= 0xffff:
+		size = 3
+	case n <= 0xffffffff:
+		size = 5
+	default:
+		size = 9
+	}
+	return
+}
+
+// value(8) + script length + script
+func txOutSize(scriptSize int) int {
+	return 8 + compactSizeLen(uint64(scriptSize)) + scriptSize
+}
+
+// vbytes of an input signed by a single key, without segwit marker & flag
+func inputVBytes(address string) (vBytes float64) {
+	switch AddressType(address) {
+	case AddressTypeP2SH:
+		// P2SH-P2WPKH: outpoint(36) + scriptSig(1+23) + sequence(4) + witness(1+1+72+1+33)/4
+		vBytes = 91
+	case AddressTypeP2WPKH:
+		// outpoint(36) + scriptSig(1) + sequence(4) + witness(1+1+72+1+33)/4
+		vBytes = 68
+	case AddressTypeP2WSH:
+		// <pubkey> OP_CHECKSIG: outpoint(36) + scriptSig(1) + sequence(4) + witness(1+1+72+1+35)/4
+		vBytes = 68.5
+	case AddressTypeP2TR:
+		// key path: outpoint(36) + scriptSig(1) + sequence(4) + witness(1+1+64)/4
+		vBytes = 57.5
+	default:
+		// P2PKH: outpoint(36) + scriptSig(1+72+1+33) + sequence(4)
+		vBytes = 148
+	}
+	return
+}
+
+// estimateVBytes estimates the virtual size of a signed tx
+// spending inputAddresses to outputAddresses and OP_RETURN outputs with opReturnSizes bytes of data.
+func estimateVBytes(inputAddresses []string, outputAddresses []string, opReturnSizes []int) (vBytes float64) {
+	countTxOuts := len(outputAddresses) + len(opReturnSizes)
+
+	// version(4) + locktime(4) + count of inputs + count of outputs
+	vBytes = float64(4 + 4 + compactSizeLen(uint64(len(inputAddresses))) + compactSizeLen(uint64(countTxOuts)))
+	countLegacyInputs := 0
+	for _, address := range inputAddresses {
+		vBytes += inputVBytes(address)
+		if !isWitnessType(AddressType(address)) {
+			countLegacyInputs += 1
+		}
+	}
+	if countLegacyInputs < len(inputAddresses) {
+		vBytes += 0.5                               // segwit marker & flag: 2 WU
+		vBytes += float64(countLegacyInputs) * 0.25 // empty witness of legacy inputs: 1 WU
+	}
+
+	for _, address := range outputAddresses {
+		vBytes += float64(txOutSize(scriptPubKeySize(AddressType(address))))
+	}
+	for _, dataSize := range opReturnSizes {
+		vBytes += float64(txOutSize(opReturnScriptSize(dataSize)))
+	}
+	return
+}
+
+func calFee(inputAddresses []string, outputAddresses []string, opReturnSizes []int, feePerVByte float64) (fee Amount) {
+	vBytes := estimateVBytes(inputAddresses, outputAddresses, opReturnSizes)
+	fee = Amount(math.Ceil(vBytes * feePerVByte))
+	return
+}
+
+func vSizeOfWeight(weight int) int {
+	return (weight + 3) / 4
+}
@@ -0,0 +1,161 @@
+package gobitcoinopreturn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddressType(t *testing.T) {
+	cases := map[string]string{
+		"17M94TyjrY832rDgyY4cn92qSf697LtWgS":                             AddressTypeP2PKH,
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy":                             AddressTypeP2SH,
+		"bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c":                     AddressTypeP2WPKH,
+		"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3": AddressTypeP2WSH,
+		"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr": AddressTypeP2TR,
+	}
+	// testnet, signet & regtest
+	cases["mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"] = AddressTypeP2PKH
+	cases["2MzQwSSnBHWHqSAqtTVQ6v47XtaisrJa1Vc"] = AddressTypeP2SH
+	cases["tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"] = AddressTypeP2WPKH
+	cases["tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"] = AddressTypeP2WSH
+	cases["tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c"] = AddressTypeP2TR
+	cases["bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"] = AddressTypeP2WPKH
+	cases["bcrt1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qzf4jry"] = AddressTypeP2WSH
+	cases["bcrt1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqc8gma6"] = AddressTypeP2TR
+	for address, expected := range cases {
+		if addressType := AddressType(address); addressType != expected {
+			t.Fatalf("%s: expected %s, got %s", address, expected, addressType)
+		}
+	}
+}
+
+func TestEstimateVBytesOpReturn(t *testing.T) {
+	address := "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c"
+
+	// overhead 10.5 + input 68 + OP_RETURN(8+1+1+1+1) + output 31
+	if vBytes := estimateVBytes([]string{address}, []string{address}, []int{1}); vBytes != 121.5 {
+		t.Fatalf("1 byte message: unexpected vBytes %f", vBytes)
+	}
+	// OP_RETURN OP_PUSHDATA1 <80> data: 8+1+83
+	if vBytes := estimateVBytes([]string{address}, []string{address}, []int{80}); vBytes != 201.5 {
+		t.Fatalf("80 bytes message: unexpected vBytes %f", vBytes)
+	}
+	// P2PKH(34), P2SH(32), P2TR(43) outputs of legacy input 148
+	outputs := []string{"17M94TyjrY832rDgyY4cn92qSf697LtWgS", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"}
+	if vBytes := estimateVBytes([]string{"17M94TyjrY832rDgyY4cn92qSf697LtWgS"}, outputs, nil); vBytes != 10+148+34+32+43 {
+		t.Fatalf("legacy: unexpected vBytes %f", vBytes)
+	}
+
+	if fee := calFee([]string{address}, []string{address}, []int{80}, 2); fee != 403 {
+		t.Fatalf("unexpected fee %d", fee)
+	}
+}
+
+func TestEstimateVBytesInputTypes(t *testing.T) {
+	output := "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx" // 31
+	cases := map[string]float64{
+		"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn":                             10 + 148 + 31,
+		"2MzQwSSnBHWHqSAqtTVQ6v47XtaisrJa1Vc":                            10.5 + 91 + 31,
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx":                     10.5 + 68 + 31,
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7": 10.5 + 68.5 + 31,
+		"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c": 10.5 + 57.5 + 31,
+	}
+	for input, expected := range cases {
+		if vBytes := estimateVBytes([]string{input}, []string{output}, nil); vBytes != expected {
+			t.Fatalf("%s: expected %f, got %f", input, expected, vBytes)
+		}
+	}
+
+	// P2PKH & P2WPKH: the legacy input has an empty witness
+	vBytes := estimateVBytes([]string{"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", output}, []string{output}, nil)
+	if vBytes != 10+0.5+148+0.25+68+31 {
+		t.Fatalf("mixed: unexpected vBytes %f", vBytes)
+	}
+}
+
+func testTxWeight(rawTx string) (weight int, err error) {
+	tx, err := DecodeTx(rawTx)
+	if err != nil {
+		return
+	}
+	weight, err = tx.Weight()
+	return
+}
+
+func TestTxWeight(t *testing.T) {
+	// 1 P2WPKH input, 1 P2WPKH output
+	rawTx := "02000000" + "0001" +
+		"01" + strings.Repeat("00", 36) + "00" + "ffffffff" +
+		"01" + "e803000000000000" + "16" + "0014" + strings.Repeat("11", 20) +
+		"02" + "48" + strings.Repeat("22", 72) + "21" + strings.Repeat("33", 33) +
+		"00000000"
+	weight, err := testTxWeight(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (82 bytes without witness) * 3 + 192 bytes
+	if weight != 438 || vSizeOfWeight(weight) != 110 {
+		t.Fatalf("unexpected weight %d", weight)
+	}
+	address := "bc1qr3ypk033x9yeqwzfaczd98vckspf22v3nvw73c"
+	if estimated := estimateVBytes([]string{address}, []string{address}, nil); float64(vSizeOfWeight(weight)) < estimated {
+		t.Fatalf("estimated %f over real vsize %d", estimated, vSizeOfWeight(weight))
+	}
+
+	// legacy: no marker & flag, weight is size x 4
+	rawTx = "01000000" +
+		"01" + strings.Repeat("00", 36) + "02" + "5100" + "ffffffff" +
+		"01" + "e803000000000000" + "01" + "51" +
+		"00000000"
+	if weight, err = testTxWeight(rawTx); err != nil || weight != 4*63 {
+		t.Fatalf("unexpected legacy weight %d, %v", weight, err)
+	}
+
+	if _, err = testTxWeight(rawTx[:len(rawTx)-2]); err == nil {
+		t.Fatal("expected error for truncated tx")
+	}
+}
+
+func TestReconciledFee(t *testing.T) {
+	opReturn := OpReturn{Fee: 1000, VSize: 110, FeeReconcileMargin: 0.1}
+	opReturn.SelectionReport.FeePerVByte = 10
+	opReturn.EffectiveFeePerVByte = float64(opReturn.Fee) / float64(opReturn.VSize)
+
+	if fee, rebuild := opReturn.reconciledFee(); !rebuild || fee != 1100 {
+		t.Fatalf("underpaying: expected rebuild with 1100, got %d %t", fee, rebuild)
+	}
+
+	opReturn.Fee = 1500
+	opReturn.EffectiveFeePerVByte = float64(opReturn.Fee) / float64(opReturn.VSize)
+	if fee, rebuild := opReturn.reconciledFee(); !rebuild || fee != 1100 {
+		t.Fatalf("overpaying: expected rebuild with 1100, got %d %t", fee, rebuild)
+	}
+
+	opReturn.FeeReconcileMargin = 0.0
+	if _, rebuild := opReturn.reconciledFee(); rebuild {
+		t.Fatal("overpaying without margin: expected no rebuild")
+	}
+
+	opReturn.Fee = 1000
+	opReturn.LimitFeeSats = 1000
+	opReturn.EffectiveFeePerVByte = float64(opReturn.Fee) / float64(opReturn.VSize)
+	if _, rebuild := opReturn.reconciledFee(); rebuild {
+		t.Fatal("at LimitFeeSats: expected no rebuild")
+	}
+}
+
+func TestPrepareReconciledReport(t *testing.T) {
+	bitcoind := newTestQueueBitcoind()
+	defer bitcoind.Close()
+	opReturn := testQueuedOpReturn(bitcoind, "reconciled")
+	opReturn.FeeEstimator = StaticFeeEstimator{FeePerVByte: 10}
+	opReturn.PayInfos = map[string]Amount{}
+
+	// the estimate is under the real vsize, rebuilt at the fee of the signed tx
+	if err := opReturn.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if opReturn.Fee != Amount(opReturn.VSize*10) || opReturn.SelectionReport.FeePerVByte != 10 || opReturn.SelectionReport.FeeSource != "static" {
+		t.Errorf("fee: %s, vsize: %d, report: %+v", opReturn.Fee, opReturn.VSize, opReturn.SelectionReport)
+	}
+}